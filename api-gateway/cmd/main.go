@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,11 +13,13 @@ import (
 
 	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
 	"github.com/nutcase/shop-ecommerce/api-gateway/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/httpcache"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/idempotency"
 	custommiddleware "github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/ratelimit"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/revocation"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -24,10 +28,39 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
+	// Access log lines would be dropped by zap's own sampling
+	accessLogConfig := zap.NewProductionConfig()
+	accessLogConfig.Sampling = nil
+	accessLogger, _ := accessLogConfig.Build()
+	defer accessLogger.Sync()
+
+	configFile := flag.String("config", "", "YAML, TOML or JSON config file (overrides CONFIG_FILE)")
+	profile := flag.String("profile", "", "configuration profile: dev, staging or prod (overrides PROFILE)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+	if *configFile != "" {
+		config.Override("CONFIG_FILE", *configFile)
+	}
+	if *profile != "" {
+		config.Override("PROFILE", *profile)
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		sugar.Fatalf("Failed to load configuration: %v", err)
 	}
+	for _, warning := range cfg.Warnings {
+		sugar.Warn(warning)
+	}
+	if *printConfig {
+		out, err := json.MarshalIndent(cfg.Effective(), "", "  ")
+		if err != nil {
+			sugar.Fatalf("Failed to print configuration: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	sugar.Infow("Configuration loaded", "profile", cfg.Profile, "config_file", cfg.ConfigFile, "profile_file", cfg.ProfileFile)
 
 	tp, err := config.InitTracer(cfg)
 	if err != nil {
@@ -39,67 +72,108 @@ func main() {
 		}
 	}()
 
-	r := chi.NewRouter()
-
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
-
-	r.Use(custommiddleware.TracingMiddleware)
 	h := handlers.NewHandler(cfg, sugar)
 
-	r.Get("/health", h.HealthCheck)
-
-	r.Route("/api/identity", func(r chi.Router) {
-		r.Post("/register", h.RegisterUser)
-		r.Post("/login", h.LoginUser)
-		r.With(custommiddleware.AuthMiddleware(cfg.JWTSecret)).Get("/profile", h.GetUserProfile)
-	})
-
-	r.Route("/api/products", func(r chi.Router) {
-		r.Get("/", h.ListProducts)
-		r.Get("/{id}", h.GetProduct)
-		r.With(custommiddleware.AuthMiddleware(cfg.JWTSecret)).Post("/", h.CreateProduct)
-		r.With(custommiddleware.AuthMiddleware(cfg.JWTSecret)).Put("/{id}", h.UpdateProduct)
-		r.With(custommiddleware.AuthMiddleware(cfg.JWTSecret)).Delete("/{id}", h.DeleteProduct)
-	})
-
-	r.Route("/api/cart", func(r chi.Router) {
-		r.Use(custommiddleware.AuthMiddleware(cfg.JWTSecret))
-		r.Get("/", h.GetCart)
-		r.Post("/items", h.AddToCart)
-		r.Put("/items/{id}", h.UpdateCartItem)
-		r.Delete("/items/{id}", h.RemoveFromCart)
-		r.Delete("/", h.ClearCart)
-	})
-
-	r.Route("/api/orders", func(r chi.Router) {
-		r.Use(custommiddleware.AuthMiddleware(cfg.JWTSecret))
-		r.Get("/", h.GetOrders)
-		r.Get("/{id}", h.GetOrder)
-		r.Post("/", h.CreateOrder)
-		r.Post("/{id}/cancel", h.CancelOrder)
-	})
+	var rdb *redis.Client
+	if cfg.RedisAddr != "" {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		defer rdb.Close()
+	}
+
+	var limiter ratelimit.Limiter = ratelimit.NewMemory()
+	if rdb != nil {
+		limiter = ratelimit.NewFallback(ratelimit.NewRedis(rdb), limiter, sugar)
+	}
+
+	// Cancelled on shutdown; stops background work such as JWKS refreshes
+	// and upstream health checks
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	h.StartUpstreams(bgCtx)
+
+	authCfg := custommiddleware.AuthConfig{
+		Algorithms: cfg.JWTAlgorithms,
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		Logger:     sugar,
+	}
+	switch {
+	case !cfg.RevocationEnabled:
+	case rdb == nil:
+		sugar.Warn("REDIS_ADDR is not set, revoked tokens are accepted until they expire")
+	default:
+		authCfg.Revocations = revocation.NewCached(revocation.NewRedis(rdb), cfg.RevocationCacheTTL)
+	}
+	if cfg.JWTMode == config.JWTModeHS256 {
+		sugar.Warn("JWT_MODE is hs256; shared-secret tokens are meant for development only")
+		authCfg.Keyfunc = custommiddleware.HS256Keyfunc(cfg.JWTSecret)
+	} else {
+		jwks := custommiddleware.NewJWKS(cfg.JWKSURL, cfg.JWKSRefreshInterval, cfg.JWKSMinRefresh, sugar)
+		jwks.Start(bgCtx)
+		authCfg.Keyfunc = jwks.Keyfunc
+	}
+
+	deps := &routerDeps{
+		handler:      h,
+		auth:         custommiddleware.AuthMiddleware(authCfg),
+		limiter:      limiter,
+		logger:       sugar,
+		accessLogger: accessLogger.Named("access").Sugar(),
+	}
+	if cfg.CacheEnabled {
+		var shared httpcache.Shared
+		if rdb != nil && cfg.CacheRedisEnabled {
+			shared = httpcache.NewRedis(rdb)
+		}
+		deps.cache, err = httpcache.New(cfg.CacheMaxEntries, shared, sugar)
+		if err != nil {
+			sugar.Fatalf("Failed to create response cache: %v", err)
+		}
+	}
+
+	if cfg.IdempotencyEnabled {
+		deps.idempotency = idempotency.NewMemory()
+		if rdb != nil {
+			deps.idempotency = idempotency.NewRedis(rdb)
+		}
+	}
+
+	router, err := newRouter(cfg, deps)
+	if err != nil {
+		sugar.Fatalf("Failed to build router: %v", err)
+	}
+	reloads := newReloader(bgCtx, router, deps, sugar)
+
+	// SIGHUP reloads the configuration instead of stopping the gateway
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloads.Reload("SIGHUP")
+		}
+	}()
+	if cfg.ConfigWatch {
+		err := cfg.WatchFiles(func(path string) {
+			reloads.Reload("file change: " + path)
+		})
+		if err != nil {
+			sugar.Fatalf("Failed to watch configuration files: %v", err)
+		}
+	}
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: r,
+		Handler: reloads,
 	}
 
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		<-sig
 
@@ -117,6 +191,7 @@ func main() {
 		if err != nil {
 			sugar.Fatal(err)
 		}
+		stopBackground()
 		serverStopCtx()
 	}()
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// reloader serves requests through the current router and swaps in a new
+// one built from a freshly loaded configuration on reload. Requests in
+// flight finish on the router they started on.
+type reloader struct {
+	deps   *routerDeps
+	logger *zap.SugaredLogger
+	// ctx runs the background work of the upstream clients built on reload
+	ctx context.Context
+
+	// mu serializes reloads, which read the global viper
+	mu     sync.Mutex
+	router atomic.Pointer[http.Handler]
+}
+
+func newReloader(ctx context.Context, router http.Handler, deps *routerDeps, logger *zap.SugaredLogger) *reloader {
+	rl := &reloader{deps: deps, logger: logger, ctx: ctx}
+	rl.router.Store(&router)
+	return rl
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*rl.router.Load()).ServeHTTP(w, r)
+}
+
+// Reload loads and validates the configuration and rebuilds the router
+// from it. On any error the current router stays in place. trigger says
+// what caused the reload and is logged with the outcome. Upstream clients
+// whose settings changed are replaced; the replaced ones are closed once
+// the requests in flight on the previous router have timed out.
+func (rl *reloader) Reload(trigger string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.logger.Infow("Reloading configuration", "trigger", trigger)
+
+	cfg, err := config.Load()
+	var router http.Handler
+	var handler *handlers.Handler
+	if err == nil {
+		handler = rl.deps.handler.WithConfig(cfg)
+		deps := *rl.deps
+		deps.handler = handler
+		router, err = newRouter(cfg, &deps)
+		if err != nil {
+			handler.CloseUpstreams(rl.deps.handler)
+		}
+	}
+	metrics.ObserveReload(err)
+	if err != nil {
+		rl.logger.Errorw("Configuration reload failed, keeping the current configuration", "trigger", trigger, "error", err)
+		return
+	}
+
+	handler.StartUpstreams(rl.ctx)
+	previous := rl.deps.handler
+	rl.deps.handler = handler
+	rl.router.Store(&router)
+	time.AfterFunc(requestTimeout, func() { previous.CloseUpstreams(handler) })
+	for _, warning := range cfg.Warnings {
+		rl.logger.Warn(warning)
+	}
+	rl.logger.Infow("Configuration reloaded",
+		"trigger", trigger,
+		"routes", len(cfg.Routes),
+		"upstreams", cfg.Upstreams(),
+		"cors_allowed_origins", cfg.CORSAllowedOrigins,
+	)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/accesslog"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/graphql"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/httpcache"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/idempotency"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/metrics"
+	custommiddleware "github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/ratelimit"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// requestTimeout bounds the time the gateway spends on a request.
+const requestTimeout = 60 * time.Second
+
+// routerDeps are the parts of the gateway that outlive a configuration
+// reload: their state (connections, breakers, rate limit counters, cached
+// responses, signing keys) is kept when the router is rebuilt. The
+// handler is replaced on reload, keeping the clients of the upstreams
+// whose settings did not change.
+type routerDeps struct {
+	handler *handlers.Handler
+	auth    func(http.Handler) http.Handler
+	limiter ratelimit.Limiter
+	cache   *httpcache.Cache
+	// idempotency is nil when idempotency keys are disabled
+	idempotency idempotency.Store
+	logger      *zap.SugaredLogger
+	// accessLogger is not sampled by zap; the access log samples itself
+	accessLogger *zap.SugaredLogger
+}
+
+// newRouter builds the gateway's router for cfg. It fails without side
+// effects, so a bad reload leaves the current router in place.
+func newRouter(cfg *config.Config, deps *routerDeps) (http.Handler, error) {
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.StripSlashes)
+	if cfg.AccessLogEnabled {
+		r.Use(accesslog.Middleware(accesslog.Config{
+			Logger:     deps.accessLogger,
+			SampleRate: cfg.AccessLogSampleRate,
+		}))
+	}
+	r.Use(metrics.Middleware)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.Timeout(requestTimeout))
+	// Set before CORS so preflight responses carry the headers too
+	if cfg.SecurityHeadersEnabled {
+		r.Use(custommiddleware.SecurityHeaders(custommiddleware.SecurityHeadersConfig{
+			HSTSMaxAge:            cfg.HSTSMaxAge,
+			HSTSIncludeSubdomains: cfg.HSTSIncludeSubdomains,
+			HSTSPreload:           cfg.HSTSPreload,
+			ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+			FrameOptions:          cfg.FrameOptions,
+			ReferrerPolicy:        cfg.ReferrerPolicy,
+		}))
+	}
+	corsHandler, err := custommiddleware.CORS(custommiddleware.CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", custommiddleware.IdempotencyKeyHeader, cfg.CSRFHeaderName},
+		MaxAge:           cfg.CORSMaxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Use(corsHandler)
+	if cfg.CSRFEnabled {
+		r.Use(custommiddleware.CSRF(custommiddleware.CSRFConfig{
+			CookieName:     cfg.CSRFCookieName,
+			HeaderName:     cfg.CSRFHeaderName,
+			SessionCookies: cfg.SessionCookieNames,
+			Secure:         cfg.Profile != config.ProfileDev,
+			Logger:         deps.logger,
+		}))
+	}
+
+	r.Use(custommiddleware.TracingMiddleware)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "No route matches "+r.URL.Path)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+	h := deps.handler
+
+	r.Get("/health", h.Livez)
+	r.Get("/livez", h.Livez)
+	r.Get("/readyz", h.Readyz)
+	if cfg.MetricsPath != "" {
+		r.Handle(cfg.MetricsPath, metrics.Handler())
+	}
+
+	routeMiddleware := []custommiddleware.RouteMiddleware{custommiddleware.RouteVersion()}
+	if cfg.RateLimitEnabled {
+		// Limits callers by IP before their tokens are checked, and each
+		// user after
+		routeMiddleware = append(routeMiddleware, custommiddleware.RouteClientIPRateLimit(deps.limiter, cfg.RateLimits, deps.logger))
+	}
+	routeMiddleware = append(routeMiddleware,
+		custommiddleware.RouteAuth(deps.auth),
+		custommiddleware.RoutePolicy(cfg.Policies, cfg.RoleScopes, deps.logger),
+	)
+	if cfg.RateLimitEnabled {
+		routeMiddleware = append(routeMiddleware, custommiddleware.RouteRateLimit(deps.limiter, cfg.RateLimits, deps.logger))
+	}
+
+	validator, err := validation.NewValidator(cfg.SchemaDir, cfg.Schemas())
+	if err != nil {
+		return nil, err
+	}
+	routeMiddleware = append(routeMiddleware, custommiddleware.RouteValidation(validator))
+
+	if cfg.IdempotencyEnabled && deps.idempotency != nil {
+		routeMiddleware = append(routeMiddleware, custommiddleware.RouteIdempotency(deps.idempotency, custommiddleware.IdempotencyConfig{
+			TTL:              cfg.IdempotencyTTL,
+			LockTimeout:      cfg.IdempotencyLockTimeout,
+			MaxResponseBytes: cfg.IdempotencyMaxResponseBytes,
+			Logger:           deps.logger,
+		}))
+	}
+
+	if cfg.CacheEnabled && deps.cache != nil {
+		routeMiddleware = append(routeMiddleware, custommiddleware.RouteCache(deps.cache, cfg.CacheMaxEntryBytes, deps.logger))
+	}
+	routeMiddleware = append(routeMiddleware, custommiddleware.RouteTransform())
+
+	if err := h.RegisterRoutes(r, routeMiddleware...); err != nil {
+		return nil, err
+	}
+	h.RegisterStorefront(r, routeMiddleware...)
+	h.RegisterAdmin(r, routeMiddleware...)
+	if cfg.GraphQLEnabled {
+		limits := graphql.Limits{
+			MaxDepth:      cfg.GraphQLMaxDepth,
+			MaxComplexity: cfg.GraphQLMaxComplexity,
+			Introspection: cfg.GraphQLIntrospection,
+		}
+		if err := h.RegisterGraphQL(r, limits, routeMiddleware...); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,209 @@
+// Package accesslog writes a structured access log line for every request
+// through zap, so that the lines can be joined with traces and the logs of
+// the services. The layers that know more about a request than the
+// outermost middleware (the tracing and auth middleware, the proxy) add it
+// to the request's entry as they go.
+package accesslog
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveParams are the query parameters whose values are never logged.
+var sensitiveParams = []string{"password", "passwd", "secret", "token", "authorization", "api_key", "apikey", "email"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+(@|%40)[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Config tunes the access log.
+type Config struct {
+	Logger *zap.SugaredLogger
+	// SampleRate in [0, 1] is the share of 2xx lines written; other
+	// statuses are always logged
+	SampleRate float64
+}
+
+type entryKey struct{}
+
+// entry is what the inner layers learn about a request.
+type entry struct {
+	mu              sync.Mutex
+	spanContext     trace.SpanContext
+	userID          string
+	upstream        string
+	upstreamLatency time.Duration
+}
+
+func fromContext(ctx context.Context) *entry {
+	e, _ := ctx.Value(entryKey{}).(*entry)
+	return e
+}
+
+// SetSpan records the span serving the request.
+func SetSpan(ctx context.Context, sc trace.SpanContext) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.spanContext = sc
+	}
+}
+
+// SetUser records the authenticated caller.
+func SetUser(ctx context.Context, userID string) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.userID = userID
+	}
+}
+
+// SetUpstream records the upstream the request is routed to.
+func SetUpstream(ctx context.Context, name string) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.upstream = name
+	}
+}
+
+// ObserveUpstream adds d to the time the request spent waiting on
+// upstreams. Concurrent calls are summed.
+func ObserveUpstream(ctx context.Context, d time.Duration) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.upstreamLatency += d
+	}
+}
+
+// Middleware logs every request once it is handled. The route is the chi
+// route pattern, so it must wrap the router; the path and query are logged
+// with credentials and email addresses redacted, and headers are not
+// logged at all.
+func Middleware(cfg Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			e := &entry{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			// The path is read first: handlers may rewrite the request's URL
+			path, query := redactPath(r.URL.EscapedPath()), redactQuery(r.URL.Query())
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), entryKey{}, e)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if status >= 200 && status < 300 && !sampled(e.spanContext.TraceID(), cfg.SampleRate) {
+				return
+			}
+
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			fields := []interface{}{
+				"request_id", middleware.GetReqID(r.Context()),
+				"method", r.Method,
+				"path", path,
+				"route", route,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"remote_addr", r.RemoteAddr,
+				"user_agent", r.UserAgent(),
+			}
+			if query != "" {
+				fields = append(fields, "query", query)
+			}
+			if e.spanContext.IsValid() {
+				fields = append(fields, "trace_id", e.spanContext.TraceID().String(), "span_id", e.spanContext.SpanID().String())
+			}
+			if e.userID != "" {
+				fields = append(fields, "user_id", e.userID)
+			}
+			if e.upstream != "" {
+				fields = append(fields, "upstream", e.upstream)
+			}
+			if e.upstreamLatency > 0 {
+				fields = append(fields, "upstream_latency_ms", float64(e.upstreamLatency.Microseconds())/1000)
+			}
+
+			if status >= http.StatusInternalServerError {
+				cfg.Logger.Errorw("HTTP request", fields...)
+			} else {
+				cfg.Logger.Infow("HTTP request", fields...)
+			}
+		})
+	}
+}
+
+// sampled decides whether a 2xx line is written. Lines with a trace are
+// sampled by its ID, so that every service keeps or drops the same
+// requests.
+func sampled(traceID trace.TraceID, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	case traceID.IsValid():
+		return binary.BigEndian.Uint64(traceID[8:16])>>1 < uint64(rate*(1<<63))
+	default:
+		return rand.Float64() < rate
+	}
+}
+
+// redactPath hides the email addresses in a path.
+func redactPath(path string) string {
+	return emailPattern.ReplaceAllString(path, redacted)
+}
+
+// redactQuery encodes query with the values of credential parameters and
+// email addresses hidden.
+func redactQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	for key, values := range query {
+		sensitive := isSensitive(key)
+		for i, v := range values {
+			if sensitive {
+				values[i] = redacted
+			} else {
+				values[i] = emailPattern.ReplaceAllString(v, redacted)
+			}
+		}
+	}
+	encoded, err := url.QueryUnescape(query.Encode())
+	if err != nil {
+		return query.Encode()
+	}
+	return encoded
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveParams {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package accesslog
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// serve sends req through the access log to a handler answering status,
+// and returns the JSON lines written.
+func serve(t *testing.T, rate float64, status int, req *http.Request) []map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	h := Middleware(Config{Logger: zap.New(core).Sugar(), SampleRate: rate})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var lines []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestMiddlewareRedacts(t *testing.T) {
+	secrets := []string{"bearer-secret", "cookie-secret", "query-secret", "refresh-secret", "hunter2", "jane@example.com", "jane%40example.com"}
+
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized, http.StatusInternalServerError} {
+		req := httptest.NewRequest(http.MethodGet,
+			"/api/users/jane%40example.com/orders?access_token=query-secret&refresh_token=refresh-secret&password=hunter2&contact=jane@example.com&q=mug", nil)
+		req.Header.Set("Authorization", "Bearer bearer-secret")
+		req.Header.Set("Cookie", "session=cookie-secret")
+		req.Header.Set("X-Api-Key", "cookie-secret")
+
+		lines := serve(t, 1, status, req)
+		if len(lines) != 1 {
+			t.Fatalf("%d: got %d lines, want 1", status, len(lines))
+		}
+		line, _ := json.Marshal(lines[0])
+		for _, secret := range secrets {
+			if bytes.Contains(line, []byte(secret)) {
+				t.Errorf("%d: %q logged in %s", status, secret, line)
+			}
+		}
+		for _, header := range []string{"Authorization", "Cookie", "Bearer"} {
+			if strings.Contains(strings.ToLower(string(line)), strings.ToLower(header)) {
+				t.Errorf("%d: %s header logged in %s", status, header, line)
+			}
+		}
+
+		if path := lines[0]["path"]; path != "/api/users/"+redacted+"/orders" {
+			t.Errorf("%d: got path %v", status, path)
+		}
+		query, _ := lines[0]["query"].(string)
+		for _, param := range []string{"access_token=", "refresh_token=", "password=", "contact="} {
+			if !strings.Contains(query, param+redacted) {
+				t.Errorf("%d: got query %q, want %s redacted", status, query, param)
+			}
+		}
+		if !strings.Contains(query, "q=mug") {
+			t.Errorf("%d: got query %q, want the other parameters kept", status, query)
+		}
+	}
+}
+
+func TestMiddlewareSampling(t *testing.T) {
+	tests := []struct {
+		rate   float64
+		status int
+		level  string
+	}{
+		{1, http.StatusOK, "info"},
+		{0, http.StatusOK, ""},
+		{0, http.StatusNoContent, ""},
+		// Anything but a 2xx is always logged
+		{0, http.StatusMovedPermanently, "info"},
+		{0, http.StatusNotFound, "info"},
+		{0, http.StatusTooManyRequests, "info"},
+		{0, http.StatusInternalServerError, "error"},
+		{0, http.StatusBadGateway, "error"},
+		{0.5, http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		lines := serve(t, tt.rate, tt.status, httptest.NewRequest(http.MethodGet, "/api/products", nil))
+		switch {
+		case tt.level == "" && len(lines) != 0:
+			t.Errorf("rate %v, status %d: got %v, want no line", tt.rate, tt.status, lines)
+		case tt.level == "":
+		case len(lines) != 1:
+			t.Errorf("rate %v, status %d: got %d lines, want 1", tt.rate, tt.status, len(lines))
+		case lines[0]["level"] != tt.level || lines[0]["status"] != float64(tt.status):
+			t.Errorf("rate %v, status %d: got %v", tt.rate, tt.status, lines[0])
+		}
+	}
+}
+
+func TestSampledByTraceID(t *testing.T) {
+	const traces = 2000
+	kept := 0
+	for range traces {
+		var id trace.TraceID
+		rand.Read(id[:])
+		decision := sampled(id, 0.25)
+		// Every service sampling the trace makes the same decision
+		for range 3 {
+			if sampled(id, 0.25) != decision {
+				t.Fatalf("trace %s sampled inconsistently", id)
+			}
+		}
+		if decision {
+			kept++
+		}
+	}
+	if kept < traces/5 || kept > traces*3/10 {
+		t.Errorf("kept %d of %d traces at rate 0.25", kept, traces)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// JWTModeJWKS verifies asymmetrically signed tokens against the keys
+	// published by the identity service.
+	JWTModeJWKS = "jwks"
+	// JWTModeHS256 verifies tokens with JWT_SECRET. Development only.
+	JWTModeHS256 = "hs256"
+)
+
+var asymmetricAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// validateAuth checks the token verification settings. In jwks mode only
+// asymmetric algorithms are accepted, so a token signed with a public key
+// as an HMAC secret can never verify; hs256 must be chosen explicitly and
+// requires a secret.
+func (c *Config) validateAuth() error {
+	c.JWTMode = strings.ToLower(strings.TrimSpace(c.JWTMode))
+
+	algorithms := splitList(c.JWTAlgorithms)
+
+	switch c.JWTMode {
+	case JWTModeJWKS:
+		if len(algorithms) == 0 {
+			return errors.New("JWT_ALGORITHMS must list at least one algorithm")
+		}
+		for _, alg := range algorithms {
+			if !asymmetricAlgorithms[alg] {
+				return fmt.Errorf("JWT_ALGORITHMS: %q is not an asymmetric signing algorithm", alg)
+			}
+		}
+		if c.JWKSURL == "" {
+			c.JWKSURL = strings.TrimSuffix(c.IdentityServiceURL, "/") + "/.well-known/jwks.json"
+		}
+		if u, err := url.Parse(c.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("JWKS_URL: invalid URL %q", c.JWKSURL)
+		}
+		if c.JWKSRefreshInterval <= 0 || c.JWKSMinRefresh <= 0 {
+			return errors.New("JWKS_REFRESH_INTERVAL and JWKS_MIN_REFRESH_INTERVAL must be positive")
+		}
+	case JWTModeHS256:
+		if c.JWTSecret == "" {
+			return errors.New("JWT_SECRET is required when JWT_MODE is hs256")
+		}
+		algorithms = []string{"HS256"}
+	default:
+		return fmt.Errorf("JWT_MODE: unknown mode %q", c.JWTMode)
+	}
+
+	c.JWTAlgorithms = algorithms
+	return nil
+}
@@ -1,46 +1,238 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Port                int    `mapstructure:"API_GATEWAY_PORT"`
-	JWTSecret           string `mapstructure:"JWT_SECRET"`
+	// Profile is dev, staging or prod; see validateProfile
+	Profile             string `mapstructure:"PROFILE"`
+	// Optional YAML, TOML or JSON config file read on load and reload, with
+	// ProfileFile merged over it; environment variables take precedence
+	// over both. ConfigWatch reloads when they or RoutesFile change
+	ConfigFile          string `mapstructure:"CONFIG_FILE"`
+	ProfileFile         string `mapstructure:"-"`
+	ConfigWatch         bool   `mapstructure:"CONFIG_WATCH"`
+	// CORS settings for browser clients. Origins may use a wildcard
+	// subdomain (https://*.example.com) or port (http://localhost:*); the
+	// dev profile allows localhost when none are set
+	CORSAllowedOrigins  []string `mapstructure:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowCredentials bool    `mapstructure:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge          int      `mapstructure:"CORS_MAX_AGE"`
+	// Security response headers; HSTS is only sent over HTTPS and an
+	// HSTSMaxAge of 0 disables it, as does an empty value for the others
+	SecurityHeadersEnabled bool          `mapstructure:"SECURITY_HEADERS_ENABLED"`
+	HSTSMaxAge             time.Duration `mapstructure:"HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains  bool          `mapstructure:"HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload            bool          `mapstructure:"HSTS_PRELOAD"`
+	ContentSecurityPolicy  string        `mapstructure:"CONTENT_SECURITY_POLICY"`
+	FrameOptions           string        `mapstructure:"FRAME_OPTIONS"`
+	ReferrerPolicy         string        `mapstructure:"REFERRER_POLICY"`
+	// Double-submit CSRF protection for requests carrying a session cookie
+	CSRFEnabled         bool     `mapstructure:"CSRF_ENABLED"`
+	CSRFCookieName      string   `mapstructure:"CSRF_COOKIE_NAME"`
+	CSRFHeaderName      string   `mapstructure:"CSRF_HEADER_NAME"`
+	SessionCookieNames  []string `mapstructure:"SESSION_COOKIE_NAMES"`
+	// Access token verification; see validateAuth
+	JWTMode             string        `mapstructure:"JWT_MODE"`
+	JWTSecret           string        `mapstructure:"JWT_SECRET"`
+	JWTAlgorithms       []string      `mapstructure:"JWT_ALGORITHMS"`
+	JWTIssuer           string        `mapstructure:"JWT_ISSUER"`
+	JWTAudience         string        `mapstructure:"JWT_AUDIENCE"`
+	JWKSURL             string        `mapstructure:"JWKS_URL"`
+	JWKSRefreshInterval time.Duration `mapstructure:"JWKS_REFRESH_INTERVAL"`
+	JWKSMinRefresh      time.Duration `mapstructure:"JWKS_MIN_REFRESH_INTERVAL"`
+	// Revocation checks against the identity service's records in Redis
+	RevocationEnabled   bool          `mapstructure:"REVOCATION_ENABLED"`
+	RevocationCacheTTL  time.Duration `mapstructure:"REVOCATION_CACHE_TTL"`
 	IdentityServiceURL  string `mapstructure:"IDENTITY_SERVICE_URL"`
 	ProductServiceURL   string `mapstructure:"PRODUCT_SERVICE_URL"`
 	CartServiceURL      string `mapstructure:"CART_SERVICE_URL"`
 	OrderServiceURL     string `mapstructure:"ORDER_SERVICE_URL"`
-	// Deprecated: Use OTLPEndpoint and OTLPPort instead
-	JaegerAgentHost     string `mapstructure:"JAEGER_AGENT_HOST"`
-	// Deprecated: Use OTLPEndpoint and OTLPPort instead
-	JaegerAgentPort     int    `mapstructure:"JAEGER_AGENT_PORT"`
-	// OTLP exporter configuration
+	InventoryServiceURL string `mapstructure:"INVENTORY_SERVICE_URL"`
+	PaymentServiceURL   string `mapstructure:"PAYMENT_SERVICE_URL"`
+	// Timeout for the product and inventory lookups of the storefront
+	// endpoints; a lookup that misses it degrades its item
+	StorefrontFanoutTimeout time.Duration `mapstructure:"STOREFRONT_FANOUT_TIMEOUT"`
+	// GraphQL endpoint and the limits on its queries
+	GraphQLEnabled       bool `mapstructure:"GRAPHQL_ENABLED"`
+	GraphQLMaxDepth      int  `mapstructure:"GRAPHQL_MAX_DEPTH"`
+	GraphQLMaxComplexity int  `mapstructure:"GRAPHQL_MAX_COMPLEXITY"`
+	GraphQLIntrospection bool `mapstructure:"GRAPHQL_INTROSPECTION"`
+	// OTLP exporter configuration; OTLPPort defaults to the standard port
+	// of OTLPProtocol, grpc or http
 	OTLPEndpoint        string `mapstructure:"OTLP_ENDPOINT"`
 	OTLPPort            int    `mapstructure:"OTLP_PORT"`
+	OTLPProtocol        string `mapstructure:"OTLP_PROTOCOL"`
+	// Share of new traces that are sampled; traces continued from a caller
+	// follow the caller's decision
+	TraceSampleRatio    float64 `mapstructure:"TRACE_SAMPLE_RATIO"`
+	// Prometheus metrics endpoint; empty disables it
+	MetricsPath         string `mapstructure:"METRICS_PATH"`
+	// Access log; 2xx lines are sampled at AccessLogSampleRate
+	AccessLogEnabled    bool    `mapstructure:"ACCESS_LOG_ENABLED"`
+	AccessLogSampleRate float64 `mapstructure:"ACCESS_LOG_SAMPLE_RATE"`
+	// Readiness probing of the upstream services; only the critical ones
+	// fail readiness, the others leave the gateway degraded
+	ReadinessTimeout    time.Duration `mapstructure:"READINESS_TIMEOUT"`
+	UpstreamHealthPath  string        `mapstructure:"UPSTREAM_HEALTH_PATH"`
+	CriticalUpstreams   []string      `mapstructure:"CRITICAL_UPSTREAMS"`
+	// Route table; loaded from RoutesFile, or the embedded default when unset
+	RoutesFile          string  `mapstructure:"ROUTES_FILE"`
+	Routes              []Route `mapstructure:"-"`
+	// Request validation; routes may override MaxBodyBytes, and SchemaDir
+	// replaces the embedded JSON Schemas when set
+	MaxBodyBytes        int64  `mapstructure:"MAX_BODY_BYTES"`
+	SchemaDir           string `mapstructure:"SCHEMA_DIR"`
+	// Rate limit policies from the route table, keyed by name
+	RateLimits          map[string]RateLimitPolicy `mapstructure:"-"`
+	RateLimitEnabled    bool                       `mapstructure:"RATE_LIMIT_ENABLED"`
+	// Authorization policies and the scopes each role grants, from the route table
+	Policies            map[string]Policy   `mapstructure:"-"`
+	RoleScopes          map[string][]string `mapstructure:"-"`
+	// API versions from the route table, their names oldest first, and the
+	// version served at the unversioned /api paths, if any
+	APIVersions         map[string]APIVersion `mapstructure:"-"`
+	APIVersionOrder     []string              `mapstructure:"-"`
+	UnversionedAPI      string                `mapstructure:"-"`
+	// Response cache for the routes that enable it; entries are shared
+	// through Redis when CacheRedisEnabled and RedisAddr are set
+	CacheEnabled        bool  `mapstructure:"CACHE_ENABLED"`
+	CacheMaxEntries     int   `mapstructure:"CACHE_MAX_ENTRIES"`
+	CacheMaxEntryBytes  int64 `mapstructure:"CACHE_MAX_ENTRY_BYTES"`
+	CacheRedisEnabled   bool  `mapstructure:"CACHE_REDIS_ENABLED"`
+	// Responses replayed for repeated Idempotency-Keys; stored in Redis
+	// when RedisAddr is set. The lock timeout must outlast the slowest
+	// request, or a retry is processed twice; zero derives it from the
+	// upstream timeouts
+	IdempotencyEnabled          bool          `mapstructure:"IDEMPOTENCY_ENABLED"`
+	IdempotencyTTL              time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
+	IdempotencyLockTimeout      time.Duration `mapstructure:"IDEMPOTENCY_LOCK_TIMEOUT"`
+	IdempotencyMaxResponseBytes int64         `mapstructure:"IDEMPOTENCY_MAX_RESPONSE_BYTES"`
+	// Shared Redis; the in-memory fallbacks are used when RedisAddr is empty
+	RedisAddr           string `mapstructure:"REDIS_ADDR"`
+	RedisPassword       string `mapstructure:"REDIS_PASSWORD"`
+	RedisDB             int    `mapstructure:"REDIS_DB"`
+	// Per-upstream HTTP client settings, keyed by upstream name
+	UpstreamClients     map[string]UpstreamClientConfig `mapstructure:"-"`
+	// Warnings about settings that are accepted but should be changed
+	Warnings            []string `mapstructure:"-"`
 }
 
 func Load() (*Config, error) {
 	viper.SetDefault("API_GATEWAY_PORT", 8080)
-	viper.SetDefault("JWT_SECRET", "default_secret_change_me")
+	viper.SetDefault("PROFILE", ProfileDev)
+	viper.SetDefault("CONFIG_FILE", "")
+	viper.SetDefault("CONFIG_WATCH", false)
+	viper.SetDefault("CORS_ALLOWED_ORIGINS", "")
+	viper.SetDefault("CORS_ALLOW_CREDENTIALS", true)
+	viper.SetDefault("CORS_MAX_AGE", 300)
+	viper.SetDefault("SECURITY_HEADERS_ENABLED", true)
+	viper.SetDefault("HSTS_MAX_AGE", 180*24*time.Hour)
+	viper.SetDefault("HSTS_INCLUDE_SUBDOMAINS", true)
+	viper.SetDefault("HSTS_PRELOAD", false)
+	viper.SetDefault("CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'")
+	viper.SetDefault("FRAME_OPTIONS", "DENY")
+	viper.SetDefault("REFERRER_POLICY", "no-referrer")
+	viper.SetDefault("CSRF_ENABLED", true)
+	viper.SetDefault("CSRF_COOKIE_NAME", "csrf_token")
+	viper.SetDefault("CSRF_HEADER_NAME", "X-CSRF-Token")
+	viper.SetDefault("SESSION_COOKIE_NAMES", "session")
+	viper.SetDefault("JWT_MODE", JWTModeJWKS)
+	viper.SetDefault("JWT_SECRET", "")
+	viper.SetDefault("JWT_ALGORITHMS", "RS256,ES256,EdDSA")
+	viper.SetDefault("JWT_ISSUER", "identity-service")
+	viper.SetDefault("JWT_AUDIENCE", "shop-ecommerce")
+	viper.SetDefault("JWKS_URL", "")
+	viper.SetDefault("JWKS_REFRESH_INTERVAL", 5*time.Minute)
+	viper.SetDefault("JWKS_MIN_REFRESH_INTERVAL", 30*time.Second)
+	viper.SetDefault("REVOCATION_ENABLED", true)
+	viper.SetDefault("REVOCATION_CACHE_TTL", 5*time.Second)
 	viper.SetDefault("IDENTITY_SERVICE_URL", "http://identity-service:8081")
 	viper.SetDefault("PRODUCT_SERVICE_URL", "http://product-service:8082")
 	viper.SetDefault("CART_SERVICE_URL", "http://cart-service:8083")
 	viper.SetDefault("ORDER_SERVICE_URL", "http://order-service:8084")
-	// For backward compatibility
-	viper.SetDefault("JAEGER_AGENT_HOST", "jaeger")
-	viper.SetDefault("JAEGER_AGENT_PORT", 6831)
+	viper.SetDefault("INVENTORY_SERVICE_URL", "http://inventory-service:8086")
+	viper.SetDefault("PAYMENT_SERVICE_URL", "http://payment-service:8085")
+	viper.SetDefault("STOREFRONT_FANOUT_TIMEOUT", 2*time.Second)
+	viper.SetDefault("GRAPHQL_ENABLED", true)
+	viper.SetDefault("GRAPHQL_MAX_DEPTH", 8)
+	viper.SetDefault("GRAPHQL_MAX_COMPLEXITY", 500)
+	viper.SetDefault("GRAPHQL_INTROSPECTION", true)
 	// OTLP defaults
 	viper.SetDefault("OTLP_ENDPOINT", "jaeger")
-	viper.SetDefault("OTLP_PORT", 4317)
+	viper.SetDefault("OTLP_PORT", 0)
+	viper.SetDefault("OTLP_PROTOCOL", OTLPProtocolGRPC)
+	viper.SetDefault("TRACE_SAMPLE_RATIO", 1.0)
+	viper.SetDefault("METRICS_PATH", "/metrics")
+	viper.SetDefault("ACCESS_LOG_ENABLED", true)
+	viper.SetDefault("ACCESS_LOG_SAMPLE_RATE", 1.0)
+	viper.SetDefault("READINESS_TIMEOUT", 2*time.Second)
+	viper.SetDefault("UPSTREAM_HEALTH_PATH", "/readyz")
+	viper.SetDefault("CRITICAL_UPSTREAMS", "")
+	viper.SetDefault("ROUTES_FILE", "")
+	viper.SetDefault("MAX_BODY_BYTES", 1<<20)
+	viper.SetDefault("SCHEMA_DIR", "")
+	viper.SetDefault("RATE_LIMIT_ENABLED", true)
+	viper.SetDefault("CACHE_ENABLED", true)
+	viper.SetDefault("CACHE_MAX_ENTRIES", 10000)
+	viper.SetDefault("CACHE_MAX_ENTRY_BYTES", 1<<20)
+	viper.SetDefault("CACHE_REDIS_ENABLED", true)
+	viper.SetDefault("IDEMPOTENCY_ENABLED", true)
+	viper.SetDefault("IDEMPOTENCY_TTL", 24*time.Hour)
+	viper.SetDefault("IDEMPOTENCY_LOCK_TIMEOUT", 0)
+	viper.SetDefault("IDEMPOTENCY_MAX_RESPONSE_BYTES", 1<<20)
+	viper.SetDefault("REDIS_ADDR", "")
+	viper.SetDefault("REDIS_PASSWORD", "")
+	viper.SetDefault("REDIS_DB", 0)
+	setUpstreamDefaults()
 
 	viper.AutomaticEnv()
 
+	profileFile, err := readConfigFiles()
+	if err != nil {
+		return nil, err
+	}
+
 	var config Config
-	err := viper.Unmarshal(&config)
+	err = viper.Unmarshal(&config)
+	if err != nil {
+		return nil, err
+	}
+	config.ProfileFile = profileFile
+	config.Warnings = deprecationWarnings()
+
+	if err := config.validateAuth(); err != nil {
+		return nil, err
+	}
+	if err := config.validateTracing(); err != nil {
+		return nil, err
+	}
+
+	config.UpstreamClients, err = loadUpstreamClients()
 	if err != nil {
 		return nil, err
 	}
 
+	table, err := loadRouteTable(config.RoutesFile)
+	if err != nil {
+		return nil, err
+	}
+	config.Routes = table.Routes
+	config.RateLimits = table.RateLimits
+	config.Policies = table.Policies
+	config.RoleScopes = table.RoleScopes
+	config.APIVersions = table.Versions
+	config.UnversionedAPI = table.UnversionedVersion
+	if err := config.validateRoutes(); err != nil {
+		return nil, err
+	}
+	if err := config.validateProfile(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// redacted replaces the value of a secret that is set.
+const redacted = "[REDACTED]"
+
+// secretKeys are the settings Effective never reveals.
+var secretKeys = map[string]bool{
+	"JWT_SECRET":     true,
+	"REDIS_PASSWORD": true,
+}
+
+// Effective returns the configuration keyed by setting name, with secrets
+// redacted and durations in their string form, for --print-config.
+func (c *Config) Effective() map[string]interface{} {
+	settings := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("mapstructure")
+		if key == "" || key == "-" {
+			continue
+		}
+		if secretKeys[key] && !v.Field(i).IsZero() {
+			settings[key] = redacted
+			continue
+		}
+		settings[key] = plain(v.Field(i))
+	}
+
+	clients := make(map[string]interface{}, len(c.UpstreamClients))
+	for name, client := range c.UpstreamClients {
+		clients[name] = plain(reflect.ValueOf(client))
+	}
+	settings["UPSTREAM_CLIENTS"] = clients
+	settings["PROFILE_FILE"] = c.ProfileFile
+	settings["ROUTES"] = len(c.Routes)
+	return settings
+}
+
+// plain converts v into maps, slices and scalars that print readably.
+func plain(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			fields[v.Type().Field(i).Name] = plain(v.Field(i))
+		}
+		return fields
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = plain(v.Index(i))
+		}
+		return items
+	}
+	return v.Interface()
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Profiles select how strictly the configuration is validated and which
+// profile file is layered over the config file.
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+// knownDefaultSecrets are placeholder secrets from examples and earlier
+// defaults that must never reach a shared environment.
+var knownDefaultSecrets = map[string]bool{
+	"default_secret_change_me": true,
+	"changeme":                 true,
+	"change_me":                true,
+	"secret":                   true,
+	"your_jwt_secret_here":     true,
+}
+
+// devAllowedOrigins are the CORS origins of the dev profile when none are
+// configured: local frontends on any port.
+var devAllowedOrigins = []string{"http://localhost:*", "http://127.0.0.1:*"}
+
+// lockTimeoutMargin is added to the slowest upstream request for the
+// derived IDEMPOTENCY_LOCK_TIMEOUT, for the gateway's own share of a request.
+const lockTimeoutMargin = 30 * time.Second
+
+// deprecatedKeys are settings that are no longer read, with what replaces
+// them.
+var deprecatedKeys = map[string]string{
+	"JAEGER_AGENT_HOST": "OTLP_ENDPOINT",
+	"JAEGER_AGENT_PORT": "OTLP_PORT",
+}
+
+// Override sets key above every other source. It is meant for command-line
+// flags, which then also hold across reloads.
+func Override(key string, value interface{}) {
+	viper.Set(key, value)
+}
+
+// readConfigFiles reads CONFIG_FILE, if set, and merges the profile file
+// next to it: for gateway.yaml and the prod profile, gateway.prod.yaml.
+// The profile file is optional. It returns the path of the profile file
+// that was merged, if any.
+func readConfigFiles() (string, error) {
+	path := viper.GetString("CONFIG_FILE")
+	if path == "" {
+		return "", nil
+	}
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		return "", fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	profile := strings.ToLower(strings.TrimSpace(viper.GetString("PROFILE")))
+	ext := filepath.Ext(path)
+	profilePath := strings.TrimSuffix(path, ext) + "." + profile + ext
+	if _, err := os.Stat(profilePath); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	viper.SetConfigFile(profilePath)
+	if err := viper.MergeInConfig(); err != nil {
+		return "", fmt.Errorf("failed to read profile file %s: %w", profilePath, err)
+	}
+	return profilePath, nil
+}
+
+// validateProfile checks the settings every profile must get right and,
+// in prod, refuses settings that are only acceptable in development.
+func (c *Config) validateProfile() error {
+	c.Profile = strings.ToLower(strings.TrimSpace(c.Profile))
+	switch c.Profile {
+	case ProfileDev, ProfileStaging, ProfileProd:
+	default:
+		return fmt.Errorf("PROFILE: unknown profile %q", c.Profile)
+	}
+
+	c.CORSAllowedOrigins = splitList(c.CORSAllowedOrigins)
+	if len(c.CORSAllowedOrigins) == 0 && c.Profile == ProfileDev {
+		c.CORSAllowedOrigins = devAllowedOrigins
+	}
+	c.SessionCookieNames = splitList(c.SessionCookieNames)
+	if c.CSRFEnabled && (c.CSRFCookieName == "" || c.CSRFHeaderName == "") {
+		return errors.New("CSRF_COOKIE_NAME and CSRF_HEADER_NAME must be set when CSRF_ENABLED is true")
+	}
+
+	var errs []error
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 1 {
+		errs = append(errs, errors.New("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1"))
+	}
+	if c.GraphQLEnabled && (c.GraphQLMaxDepth <= 0 || c.GraphQLMaxComplexity <= 0) {
+		errs = append(errs, errors.New("GRAPHQL_MAX_DEPTH and GRAPHQL_MAX_COMPLEXITY must be positive"))
+	}
+	upstreams := c.Upstreams()
+	names := make([]string, 0, len(upstreams))
+	for name := range upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.CriticalUpstreams = splitList(c.CriticalUpstreams)
+	for _, name := range c.CriticalUpstreams {
+		if _, ok := upstreams[name]; !ok {
+			errs = append(errs, fmt.Errorf("CRITICAL_UPSTREAMS: unknown upstream %q", name))
+		}
+	}
+	for _, name := range names {
+		if err := validateServiceURL(upstreams[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%sURL: %w", upstreamEnvPrefixes[name], err))
+		}
+		if client := c.UpstreamClients[name]; client.Pool.Enabled() {
+			client.Pool.setAddress(upstreams[name])
+			c.UpstreamClients[name] = client
+		}
+		if addr := c.UpstreamClients[name].GRPCAddr; addr != "" {
+			if err := validateGRPCAddr(name, addr); err != nil {
+				errs = append(errs, fmt.Errorf("%sGRPC_ADDR: %w", upstreamEnvPrefixes[name], err))
+			}
+		}
+	}
+
+	var slowest time.Duration
+	for _, client := range c.UpstreamClients {
+		slowest = max(slowest, client.MaxRequestDuration())
+	}
+	switch {
+	case c.IdempotencyLockTimeout == 0:
+		c.IdempotencyLockTimeout = slowest + lockTimeoutMargin
+	case c.IdempotencyLockTimeout < slowest:
+		c.Warnings = append(c.Warnings, fmt.Sprintf(
+			"IDEMPOTENCY_LOCK_TIMEOUT=%s is shorter than the slowest upstream request with its retries (%s); retries of slow requests may be processed twice",
+			c.IdempotencyLockTimeout, slowest))
+	}
+
+	if c.Profile != ProfileDev && knownDefaultSecrets[c.JWTSecret] {
+		errs = append(errs, fmt.Errorf("JWT_SECRET is a placeholder and not allowed in %s", c.Profile))
+	}
+	if c.Profile == ProfileProd {
+		if c.JWTMode == JWTModeHS256 {
+			errs = append(errs, errors.New("JWT_MODE=hs256 is not allowed in prod"))
+		}
+		if c.RedisAddr != "" && c.RedisPassword == "" {
+			errs = append(errs, errors.New("REDIS_PASSWORD must be set in prod"))
+		}
+		for _, origin := range c.CORSAllowedOrigins {
+			switch {
+			case origin == "*":
+				errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS must list the allowed origins in prod, not *"))
+			case !strings.HasPrefix(origin, "https://"):
+				errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q must use https in prod", origin))
+			}
+		}
+		if !c.CSRFEnabled {
+			errs = append(errs, errors.New("CSRF_ENABLED must be true in prod"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deprecationWarnings lists the deprecated settings that are still set.
+func deprecationWarnings() []string {
+	var warnings []string
+	for key, replacement := range deprecatedKeys {
+		if viper.IsSet(key) {
+			warnings = append(warnings, fmt.Sprintf("%s is no longer used; set %s instead", key, replacement))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// validateServiceURL accepts absolute http and https URLs without a query
+// or fragment, which the gateway could not append paths to.
+func validateServiceURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: missing host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid URL %q: must not have a query or fragment", raw)
+	}
+	return nil
+}
+
+// validateGRPCAddr accepts a host:port for the upstreams that serve gRPC.
+func validateGRPCAddr(name, addr string) error {
+	if !grpcUpstreams[name] {
+		return fmt.Errorf("the %s service has no gRPC API", name)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "" {
+		return fmt.Errorf("invalid address %q: must be host:port", addr)
+	}
+	return nil
+}
+
+// splitList flattens comma separated entries, which is how lists arrive
+// from environment variables, and drops empty ones.
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyLockTimeout(t *testing.T) {
+	slow := UpstreamClientConfig{
+		DialTimeout:     2 * time.Second,
+		ResponseTimeout: 30 * time.Second,
+		MaxRetries:      2,
+		RetryMaxBackoff: time.Second,
+	}
+	fast := UpstreamClientConfig{DialTimeout: time.Second, ResponseTimeout: 5 * time.Second}
+	// Three attempts timing out after 32s with two backoffs in between
+	slowest := 98 * time.Second
+
+	tests := []struct {
+		name       string
+		configured time.Duration
+		want       time.Duration
+		warning    bool
+	}{
+		{"derived", 0, slowest + lockTimeoutMargin, false},
+		{"long enough", 2 * time.Minute, 2 * time.Minute, false},
+		{"too short", time.Minute, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig(t)
+			cfg.Profile = ProfileDev
+			cfg.UpstreamClients = map[string]UpstreamClientConfig{"order": slow, "cart": fast}
+			cfg.IdempotencyLockTimeout = tt.configured
+			if err := cfg.validateProfile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.IdempotencyLockTimeout != tt.want {
+				t.Errorf("got %s, want %s", cfg.IdempotencyLockTimeout, tt.want)
+			}
+			warned := false
+			for _, warning := range cfg.Warnings {
+				warned = warned || strings.HasPrefix(warning, "IDEMPOTENCY_LOCK_TIMEOUT")
+			}
+			if warned != tt.warning {
+				t.Errorf("got warnings %q, want a lock timeout warning: %v", cfg.Warnings, tt.warning)
+			}
+		})
+	}
+}
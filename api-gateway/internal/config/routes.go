@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+//go:embed routes.yaml
+var defaultRoutes []byte
+
+// Route maps a gateway path onto a path template on one of the upstream
+// services.
+type Route struct {
+	Name         string            `mapstructure:"name"`
+	Method       string            `mapstructure:"method"`
+	Path         string            `mapstructure:"path"`
+	Upstream     string            `mapstructure:"upstream"`
+	UpstreamPath string            `mapstructure:"upstream_path"`
+	Auth         bool              `mapstructure:"auth"`
+	InjectBody   map[string]string `mapstructure:"inject_body"`
+	RateLimit    string            `mapstructure:"rate_limit"`
+	Policy       string            `mapstructure:"policy"`
+	// MaxBodyBytes overrides the gateway-wide request body limit
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"`
+	// Schema names the JSON Schema the request body must match
+	Schema string `mapstructure:"schema"`
+	// Cache enables the response cache on a public GET route
+	Cache *RouteCache `mapstructure:"cache"`
+	// Invalidates lists the cache tags a successful request invalidates
+	Invalidates []string `mapstructure:"invalidates"`
+	// Owner restricts the route to the owner of the resource it acts on
+	Owner *RouteOwner `mapstructure:"owner"`
+	// Idempotency replays the response to requests repeated with the same
+	// Idempotency-Key
+	Idempotency bool `mapstructure:"idempotency"`
+	// Since and Until are the first and last API versions serving the
+	// route; it is served in every version by default
+	Since string `mapstructure:"since"`
+	Until string `mapstructure:"until"`
+	// Transforms adapts the route to the API versions it differs in
+	Transforms map[string]RouteTransform `mapstructure:"transforms"`
+	// ResponseFields, when set, are the only fields of the upstream's 2xx
+	// responses passed on to clients, named by dotted paths as in
+	// BodyTransform
+	ResponseFields []string `mapstructure:"response_fields"`
+	// Version is set on the copies of the route made by VersionRoutes
+	Version *RouteVersion `mapstructure:"-"`
+}
+
+// RouteOwner names the resource that must belong to the caller. Before
+// the request is forwarded, Path (which may reference URL parameters) is
+// fetched from Upstream and its Field must equal the caller's user ID.
+type RouteOwner struct {
+	Upstream string `mapstructure:"upstream"`
+	Path     string `mapstructure:"path"`
+	// Field is the JSON field holding the owner's ID; user_id by default
+	Field string `mapstructure:"field"`
+}
+
+// RouteCache configures response caching for a route. Entries are kept for
+// TTL and tagged with Tags, which may reference URL parameters (e.g.
+// product:{id}). MaxAge is the max-age sent to clients; at zero clients
+// must revalidate every time, which is cheap thanks to the ETag.
+type RouteCache struct {
+	TTL    time.Duration `mapstructure:"ttl"`
+	MaxAge time.Duration `mapstructure:"max_age"`
+	Tags   []string      `mapstructure:"tags"`
+}
+
+// ClientIPRateLimit names the rate limit policy applied to every request
+// by client IP, before authentication.
+const ClientIPRateLimit = "client_ip"
+
+// RateLimitPolicy is a token bucket refilled with Requests tokens every
+// Window and holding at most Burst tokens.
+type RateLimitPolicy struct {
+	Requests int           `mapstructure:"requests"`
+	Window   time.Duration `mapstructure:"window"`
+	Burst    int           `mapstructure:"burst"`
+}
+
+// Policy is an authorization policy. A caller satisfies it when its role
+// is one of Roles, if any are listed, and it holds every scope in Scopes.
+type Policy struct {
+	Roles  []string `mapstructure:"roles"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// routeTable is the content of the routes file.
+type routeTable struct {
+	Routes     []Route                    `mapstructure:"routes"`
+	RateLimits map[string]RateLimitPolicy `mapstructure:"rate_limits"`
+	Policies   map[string]Policy          `mapstructure:"policies"`
+	RoleScopes map[string][]string        `mapstructure:"role_scopes"`
+	Versions   map[string]APIVersion      `mapstructure:"versions"`
+	// UnversionedVersion is the version served at the unversioned paths
+	UnversionedVersion string `mapstructure:"unversioned_version"`
+}
+
+// loadRouteTable reads the route table from path, or the embedded default
+// table when path is empty.
+func loadRouteTable(path string) (*routeTable, error) {
+	v := viper.New()
+	if path == "" {
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewReader(defaultRoutes)); err != nil {
+			return nil, fmt.Errorf("failed to read default routes: %w", err)
+		}
+	} else {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read routes file %s: %w", path, err)
+		}
+	}
+
+	var table routeTable
+	hooks := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+	))
+	if err := v.Unmarshal(&table, hooks); err != nil {
+		return nil, fmt.Errorf("failed to parse routes: %w", err)
+	}
+	// Unmarshal drops versions declared without settings, such as v2: {}
+	for name := range v.GetStringMap("versions") {
+		if _, ok := table.Versions[name]; !ok {
+			if table.Versions == nil {
+				table.Versions = make(map[string]APIVersion)
+			}
+			table.Versions[name] = APIVersion{}
+		}
+	}
+	return &table, nil
+}
+
+func (c *Config) validateRoutes() error {
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("MAX_BODY_BYTES must be positive")
+	}
+	if err := c.validateVersions(); err != nil {
+		return err
+	}
+
+	upstreams := c.Upstreams()
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		route.Method = strings.ToUpper(route.Method)
+
+		switch route.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return fmt.Errorf("route %d: unsupported method %q", i, route.Method)
+		}
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("route %s: path must start with /", route.Path)
+		}
+		if !strings.HasPrefix(route.UpstreamPath, "/") {
+			return fmt.Errorf("route %s %s: upstream_path must start with /", route.Method, route.Path)
+		}
+		if _, ok := upstreams[route.Upstream]; !ok {
+			return fmt.Errorf("route %s %s: unknown upstream %q", route.Method, route.Path, route.Upstream)
+		}
+		if route.Name == "" {
+			route.Name = route.Method + " " + route.Path
+		}
+
+		if route.RateLimit == "" {
+			if _, ok := c.RateLimits["default"]; ok {
+				route.RateLimit = "default"
+			}
+		} else if _, ok := c.RateLimits[route.RateLimit]; !ok {
+			return fmt.Errorf("route %s %s: unknown rate limit policy %q", route.Method, route.Path, route.RateLimit)
+		}
+
+		if route.MaxBodyBytes < 0 {
+			return fmt.Errorf("route %s %s: max_body_bytes must not be negative", route.Method, route.Path)
+		}
+		if route.MaxBodyBytes == 0 {
+			route.MaxBodyBytes = c.MaxBodyBytes
+		}
+		if route.Schema != "" && route.Method == http.MethodGet {
+			return fmt.Errorf("route %s %s: GET requests have no body to validate", route.Method, route.Path)
+		}
+
+		if err := validateCache(route); err != nil {
+			return fmt.Errorf("route %s %s: %w", route.Method, route.Path, err)
+		}
+
+		if err := validateOwner(route, upstreams); err != nil {
+			return fmt.Errorf("route %s %s: %w", route.Method, route.Path, err)
+		}
+
+		if route.Idempotency && (!route.Auth || route.Method == http.MethodGet) {
+			return fmt.Errorf("route %s %s: idempotency requires auth and a method other than GET", route.Method, route.Path)
+		}
+
+		if err := c.validateRouteVersions(route); err != nil {
+			return fmt.Errorf("route %s %s: %w", route.Method, route.Path, err)
+		}
+		for _, path := range route.ResponseFields {
+			if err := validateFieldPath(path); err != nil {
+				return fmt.Errorf("route %s %s: response_fields: %w", route.Method, route.Path, err)
+			}
+		}
+
+		if route.Policy != "" {
+			if _, ok := c.Policies[route.Policy]; !ok {
+				return fmt.Errorf("route %s %s: unknown policy %q", route.Method, route.Path, route.Policy)
+			}
+			if !route.Auth {
+				return fmt.Errorf("route %s %s: policy %q requires auth", route.Method, route.Path, route.Policy)
+			}
+		}
+	}
+
+	for name, policy := range c.Policies {
+		if len(policy.Roles) == 0 && len(policy.Scopes) == 0 {
+			return fmt.Errorf("policy %s: must require at least one role or scope", name)
+		}
+	}
+
+	for name, policy := range c.RateLimits {
+		if policy.Requests <= 0 || policy.Window <= 0 {
+			return fmt.Errorf("rate limit policy %s: requests and window must be positive", name)
+		}
+		if policy.Burst <= 0 {
+			policy.Burst = policy.Requests
+			c.RateLimits[name] = policy
+		}
+	}
+	return nil
+}
+
+// WithDefaults returns route, served by the gateway itself rather than
+// from the route table, with the defaults table routes get.
+func (c *Config) WithDefaults(route Route) Route {
+	if route.RateLimit == "" {
+		if _, ok := c.RateLimits["default"]; ok {
+			route.RateLimit = "default"
+		}
+	}
+	if route.MaxBodyBytes == 0 {
+		route.MaxBodyBytes = c.MaxBodyBytes
+	}
+	return route
+}
+
+func validateOwner(route *Route, upstreams map[string]string) error {
+	owner := route.Owner
+	if owner == nil {
+		return nil
+	}
+	if !route.Auth {
+		return errors.New("owner requires auth")
+	}
+	if _, ok := upstreams[owner.Upstream]; !ok {
+		return fmt.Errorf("owner: unknown upstream %q", owner.Upstream)
+	}
+	if !strings.HasPrefix(owner.Path, "/") {
+		return errors.New("owner path must start with /")
+	}
+	for _, m := range tagParamPattern.FindAllStringSubmatch(owner.Path, -1) {
+		if !strings.Contains(route.Path, "{"+m[1]+"}") && !strings.Contains(route.Path, "{"+m[1]+":") {
+			return fmt.Errorf("owner path: {%s} is not a parameter of the path", m[1])
+		}
+	}
+	if owner.Field == "" {
+		owner.Field = "user_id"
+	}
+	return nil
+}
+
+// tagParamPattern matches the {name} URL parameters in a cache tag.
+var tagParamPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func validateCache(route *Route) error {
+	tags := route.Invalidates
+	if route.Cache != nil {
+		// Responses to authenticated requests may differ per caller
+		if route.Method != http.MethodGet || route.Auth {
+			return errors.New("cache is only supported on public GET routes")
+		}
+		if route.Cache.TTL <= 0 {
+			return errors.New("cache ttl must be positive")
+		}
+		if route.Cache.MaxAge < 0 {
+			return errors.New("cache max_age must not be negative")
+		}
+		tags = append(slices.Clone(tags), route.Cache.Tags...)
+	}
+
+	for _, tag := range tags {
+		for _, m := range tagParamPattern.FindAllStringSubmatch(tag, -1) {
+			if !strings.Contains(route.Path, "{"+m[1]+"}") && !strings.Contains(route.Path, "{"+m[1]+":") {
+				return fmt.Errorf("cache tag %q: {%s} is not a parameter of the path", tag, m[1])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/validation"
+)
+
+func TestDefaultInventoryRoutes(t *testing.T) {
+	cfg := defaultConfig(t)
+	found := 0
+	for _, route := range cfg.Routes {
+		if route.Upstream != "inventory" {
+			continue
+		}
+		found++
+		admin := strings.HasPrefix(route.Name, "Admin")
+		if admin && len(route.ResponseFields) > 0 {
+			t.Errorf("%s: got response_fields %v, want the full record", route.Name, route.ResponseFields)
+		}
+		if !admin && !slices.Equal(route.ResponseFields, []string{"item.product_id", "item.status"}) {
+			t.Errorf("%s: got response_fields %v, want only the product and its status", route.Name, route.ResponseFields)
+		}
+	}
+	if found == 0 {
+		t.Fatal("no inventory routes")
+	}
+}
+
+func TestResponseFieldsAreValidated(t *testing.T) {
+	cfg := defaultConfig(t)
+	cfg.Routes[0].ResponseFields = []string{"item..status"}
+	if err := cfg.validateRoutes(); err == nil || !strings.Contains(err.Error(), "response_fields") {
+		t.Errorf("got %v, want an invalid response_fields error", err)
+	}
+}
+
+func TestDefaultRouteSchemasCompile(t *testing.T) {
+	cfg := defaultConfig(t)
+	if _, err := validation.NewValidator("", cfg.Schemas()); err != nil {
+		t.Error(err)
+	}
+}
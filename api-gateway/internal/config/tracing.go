@@ -2,30 +2,78 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
-// InitTracer initializes the OpenTelemetry tracer
-func InitTracer(cfg *Config) (*tracesdk.TracerProvider, error) {
-	ctx := context.Background()
+const (
+	// OTLPProtocolGRPC exports spans over OTLP/gRPC, by default to port 4317.
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTP exports spans over OTLP/HTTP, by default to port 4318.
+	OTLPProtocolHTTP = "http"
+)
 
-	// Configure OTLP exporter client options
-	opts := []otlptracegrpc.Option{
-		otlptracegrpc.WithEndpoint(fmt.Sprintf("%s:%d", cfg.OTLPEndpoint, cfg.OTLPPort)),
-		otlptracegrpc.WithInsecure(), // For development; use secure connection in production
-		otlptracegrpc.WithTimeout(5 * time.Second),
+// validateTracing checks the exporter and sampler settings and fills in the
+// collector port of the chosen protocol when none is set.
+func (c *Config) validateTracing() error {
+	c.OTLPProtocol = strings.ToLower(strings.TrimSpace(c.OTLPProtocol))
+	switch c.OTLPProtocol {
+	case OTLPProtocolGRPC:
+		if c.OTLPPort == 0 {
+			c.OTLPPort = 4317
+		}
+	case OTLPProtocolHTTP:
+		if c.OTLPPort == 0 {
+			c.OTLPPort = 4318
+		}
+	default:
+		return fmt.Errorf("OTLP_PROTOCOL: unknown protocol %q", c.OTLPProtocol)
+	}
+	if c.OTLPPort < 0 || c.OTLPPort > 65535 {
+		return fmt.Errorf("OTLP_PORT: invalid port %d", c.OTLPPort)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return errors.New("TRACE_SAMPLE_RATIO must be between 0 and 1")
 	}
+	return nil
+}
+
+// InitTracer initializes the OpenTelemetry tracer. Traces are continued
+// from and propagated to other services with W3C trace context and
+// baggage; a request that carries a trace keeps its caller's sampling
+// decision, and new traces are sampled at TraceSampleRatio.
+func InitTracer(cfg *Config) (*tracesdk.TracerProvider, error) {
+	ctx := context.Background()
 
 	// Create OTLP exporter
-	exp, err := otlptrace.New(ctx, otlptracegrpc.NewClient(opts...))
+	endpoint := fmt.Sprintf("%s:%d", cfg.OTLPEndpoint, cfg.OTLPPort)
+	var client otlptrace.Client
+	switch cfg.OTLPProtocol {
+	case OTLPProtocolHTTP:
+		client = otlptracehttp.NewClient(
+			otlptracehttp.WithEndpoint(endpoint),
+			otlptracehttp.WithInsecure(), // For development; use TLS in production
+			otlptracehttp.WithTimeout(5*time.Second),
+		)
+	default:
+		client = otlptracegrpc.NewClient(
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(), // For development; use secure connection in production
+			otlptracegrpc.WithTimeout(5*time.Second),
+		)
+	}
+	exp, err := otlptrace.New(ctx, client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
 	}
@@ -33,14 +81,19 @@ func InitTracer(cfg *Config) (*tracesdk.TracerProvider, error) {
 	// Create trace provider with the exporter
 	tp := tracesdk.NewTracerProvider(
 		tracesdk.WithBatcher(exp),
+		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(cfg.TraceSampleRatio))),
 		tracesdk.WithResource(resource.NewWithAttributes(
 			semconv.SchemaURL,
 			semconv.ServiceName("api-gateway"),
 		)),
 	)
 
-	// Set global trace provider
+	// Set global trace provider and propagators
 	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
 
 	return tp, nil
 }
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// upstreamEnvPrefixes maps each upstream name to the prefix of its
+// environment variables.
+var upstreamEnvPrefixes = map[string]string{
+	"identity":  "IDENTITY_SERVICE_",
+	"product":   "PRODUCT_SERVICE_",
+	"cart":      "CART_SERVICE_",
+	"order":     "ORDER_SERVICE_",
+	"inventory": "INVENTORY_SERVICE_",
+	"payment":   "PAYMENT_SERVICE_",
+}
+
+// grpcUpstreams are the upstreams whose API is also served over gRPC.
+var grpcUpstreams = map[string]bool{
+	"identity": true,
+	"product":  true,
+	"cart":     true,
+	"order":    true,
+}
+
+// UpstreamClientConfig tunes the HTTP client used for one upstream service.
+type UpstreamClientConfig struct {
+	// GRPCAddr, when set, is the host:port of the upstream's gRPC server;
+	// requests are then transcoded to gRPC calls instead of sent over HTTP
+	GRPCAddr            string
+	DialTimeout         time.Duration
+	ResponseTimeout     time.Duration
+	IdleConnTimeout     time.Duration
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	MaxRetries          int
+	RetryBackoff        time.Duration
+	RetryMaxBackoff     time.Duration
+	MaxRetryBodyBytes   int64
+	Breaker             BreakerConfig
+	// Pool spreads the requests over several instances of the upstream
+	Pool PoolConfig
+}
+
+// MaxRequestDuration is the longest a request to the upstream can take:
+// every attempt times out and each retry waits the longest backoff.
+func (u UpstreamClientConfig) MaxRequestDuration() time.Duration {
+	attempts := time.Duration(u.MaxRetries + 1)
+	return attempts*(u.DialTimeout+u.ResponseTimeout) + time.Duration(u.MaxRetries)*u.RetryMaxBackoff
+}
+
+// BreakerConfig tunes the circuit breaker in front of one upstream service.
+type BreakerConfig struct {
+	// FailureRate in [0, 1] at which a closed breaker opens
+	FailureRate float64
+	// MinRequests that must be seen in a window before the breaker can open
+	MinRequests int
+	// Window after which a closed breaker resets its counts
+	Window time.Duration
+	// ProbeInterval an open breaker waits before letting probes through
+	ProbeInterval time.Duration
+	// HalfOpenProbes that must succeed before the breaker closes again
+	HalfOpenProbes int
+}
+
+// Ways of finding the instances of an upstream.
+const (
+	DiscoveryStatic = "static"
+	DiscoveryDNS    = "dns"
+	DiscoverySRV    = "srv"
+)
+
+// Strategies for picking the instance that serves a request.
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceLeastOutstanding = "least_outstanding"
+	BalanceWeighted         = "weighted"
+)
+
+// PoolConfig describes the instances of an upstream. Requests keep the
+// scheme and path of the upstream's URL and are sent to the host:port of
+// an instance picked by Strategy.
+type PoolConfig struct {
+	// Discovery is static, dns or srv; empty sends every request to the
+	// host of the upstream's URL
+	Discovery string
+	// Instances are the static instances
+	Instances []InstanceConfig
+	// DNSName is the name looked up by dns (A/AAAA records, with Port)
+	// and srv discovery
+	DNSName string
+	Port    string
+	// Scheme is the scheme of the upstream's URL, used for health checks
+	Scheme string
+	// RefreshInterval is how often DNS names are looked up again
+	RefreshInterval time.Duration
+	Strategy        string
+	HealthCheck     HealthCheckConfig
+	Outlier         OutlierConfig
+}
+
+// Enabled reports whether the upstream has a pool of instances.
+func (p PoolConfig) Enabled() bool {
+	return p.Discovery != ""
+}
+
+// setAddress takes the scheme of the upstream's URL, and defaults the DNS
+// name and port looked up by dns and srv discovery to its host and port.
+func (p *PoolConfig) setAddress(serviceURL string) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return
+	}
+	p.Scheme = u.Scheme
+	if p.DNSName == "" {
+		p.DNSName = u.Hostname()
+	}
+	p.Port = u.Port()
+	if p.Port == "" {
+		p.Port = "80"
+		if u.Scheme == "https" {
+			p.Port = "443"
+		}
+	}
+}
+
+// InstanceConfig is a static instance of an upstream. Weight is only used
+// by the weighted strategy, e.g. to send a share of the traffic to a
+// canary.
+type InstanceConfig struct {
+	Addr   string
+	Weight int
+}
+
+// HealthCheckConfig tunes the active health checks of an upstream's
+// instances. An instance is taken out of rotation after
+// UnhealthyThreshold failed checks in a row and put back after
+// HealthyThreshold successful ones.
+type HealthCheckConfig struct {
+	// Interval between checks; zero disables them
+	Interval           time.Duration
+	Timeout            time.Duration
+	Path               string
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// OutlierConfig tunes the passive outlier detection of an upstream's
+// instances, which ejects those failing real requests even while their
+// health checks pass.
+type OutlierConfig struct {
+	// ConsecutiveFailures ejects an instance after that many failed
+	// requests in a row; zero disables it
+	ConsecutiveFailures int
+	// FailureRate in [0, 1] ejects an instance whose failure rate over an
+	// Interval reaches it, given at least MinRequests; zero disables it
+	FailureRate float64
+	MinRequests int
+	Interval    time.Duration
+	// EjectionTime is multiplied by the number of times the instance was
+	// ejected in a row, up to MaxEjectionTime
+	EjectionTime    time.Duration
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent bounds the share of the instances ejected at once
+	MaxEjectionPercent int
+}
+
+// Upstreams returns the base URL of every upstream service, keyed by the
+// name used in the route table.
+func (c *Config) Upstreams() map[string]string {
+	return map[string]string{
+		"identity":  c.IdentityServiceURL,
+		"product":   c.ProductServiceURL,
+		"cart":      c.CartServiceURL,
+		"order":     c.OrderServiceURL,
+		"inventory": c.InventoryServiceURL,
+		"payment":   c.PaymentServiceURL,
+	}
+}
+
+func setUpstreamDefaults() {
+	viper.SetDefault("UPSTREAM_DIAL_TIMEOUT", 2*time.Second)
+	viper.SetDefault("UPSTREAM_RESPONSE_TIMEOUT", 15*time.Second)
+	viper.SetDefault("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second)
+	viper.SetDefault("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32)
+	viper.SetDefault("UPSTREAM_MAX_CONNS_PER_HOST", 256)
+	viper.SetDefault("UPSTREAM_MAX_RETRIES", 2)
+	viper.SetDefault("UPSTREAM_RETRY_BACKOFF", 50*time.Millisecond)
+	viper.SetDefault("UPSTREAM_RETRY_MAX_BACKOFF", time.Second)
+	viper.SetDefault("UPSTREAM_MAX_RETRY_BODY_BYTES", 1<<20)
+	viper.SetDefault("UPSTREAM_BREAKER_FAILURE_RATE", 0.5)
+	viper.SetDefault("UPSTREAM_BREAKER_MIN_REQUESTS", 10)
+	viper.SetDefault("UPSTREAM_BREAKER_WINDOW", 30*time.Second)
+	viper.SetDefault("UPSTREAM_BREAKER_PROBE_INTERVAL", 10*time.Second)
+	viper.SetDefault("UPSTREAM_BREAKER_HALF_OPEN_PROBES", 1)
+	viper.SetDefault("UPSTREAM_BALANCER", BalanceRoundRobin)
+	viper.SetDefault("UPSTREAM_DNS_REFRESH_INTERVAL", 30*time.Second)
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_INTERVAL", 10*time.Second)
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_TIMEOUT", 2*time.Second)
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_HEALTHY_THRESHOLD", 2)
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_UNHEALTHY_THRESHOLD", 3)
+	viper.SetDefault("UPSTREAM_OUTLIER_CONSECUTIVE_FAILURES", 5)
+	viper.SetDefault("UPSTREAM_OUTLIER_FAILURE_RATE", 0.5)
+	viper.SetDefault("UPSTREAM_OUTLIER_MIN_REQUESTS", 20)
+	viper.SetDefault("UPSTREAM_OUTLIER_INTERVAL", 10*time.Second)
+	viper.SetDefault("UPSTREAM_OUTLIER_EJECTION_TIME", 30*time.Second)
+	viper.SetDefault("UPSTREAM_OUTLIER_MAX_EJECTION_TIME", 5*time.Minute)
+	viper.SetDefault("UPSTREAM_OUTLIER_MAX_EJECTION_PERCENT", 50)
+}
+
+// loadUpstreamClients reads the client settings of every upstream. A
+// setting such as CART_SERVICE_RESPONSE_TIMEOUT overrides the shared
+// UPSTREAM_RESPONSE_TIMEOUT for that upstream only.
+func loadUpstreamClients() (map[string]UpstreamClientConfig, error) {
+	var errs []error
+	clients := make(map[string]UpstreamClientConfig, len(upstreamEnvPrefixes))
+	for name, prefix := range upstreamEnvPrefixes {
+		key := func(suffix string) string {
+			if viper.IsSet(prefix + suffix) {
+				return prefix + suffix
+			}
+			return "UPSTREAM_" + suffix
+		}
+
+		clients[name] = UpstreamClientConfig{
+			GRPCAddr:            viper.GetString(prefix + "GRPC_ADDR"),
+			DialTimeout:         viper.GetDuration(key("DIAL_TIMEOUT")),
+			ResponseTimeout:     viper.GetDuration(key("RESPONSE_TIMEOUT")),
+			IdleConnTimeout:     viper.GetDuration(key("IDLE_CONN_TIMEOUT")),
+			MaxIdleConnsPerHost: viper.GetInt(key("MAX_IDLE_CONNS_PER_HOST")),
+			MaxConnsPerHost:     viper.GetInt(key("MAX_CONNS_PER_HOST")),
+			MaxRetries:          viper.GetInt(key("MAX_RETRIES")),
+			RetryBackoff:        viper.GetDuration(key("RETRY_BACKOFF")),
+			RetryMaxBackoff:     viper.GetDuration(key("RETRY_MAX_BACKOFF")),
+			MaxRetryBodyBytes:   viper.GetInt64(key("MAX_RETRY_BODY_BYTES")),
+			Breaker: BreakerConfig{
+				FailureRate:    viper.GetFloat64(key("BREAKER_FAILURE_RATE")),
+				MinRequests:    viper.GetInt(key("BREAKER_MIN_REQUESTS")),
+				Window:         viper.GetDuration(key("BREAKER_WINDOW")),
+				ProbeInterval:  viper.GetDuration(key("BREAKER_PROBE_INTERVAL")),
+				HalfOpenProbes: max(viper.GetInt(key("BREAKER_HALF_OPEN_PROBES")), 1),
+			},
+		}
+
+		pool, err := loadPool(prefix, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		client := clients[name]
+		client.Pool = pool
+		clients[name] = client
+	}
+	return clients, errors.Join(errs...)
+}
+
+// loadPool reads the instances of the upstream with the given prefix.
+// Without INSTANCES or DISCOVERY the upstream has no pool.
+func loadPool(prefix string, key func(string) string) (PoolConfig, error) {
+	pool := PoolConfig{
+		Discovery:       strings.ToLower(viper.GetString(prefix + "DISCOVERY")),
+		DNSName:         viper.GetString(prefix + "DNS_NAME"),
+		RefreshInterval: viper.GetDuration("UPSTREAM_DNS_REFRESH_INTERVAL"),
+		Strategy:        strings.ToLower(viper.GetString(key("BALANCER"))),
+		HealthCheck: HealthCheckConfig{
+			Interval:           viper.GetDuration(key("HEALTH_CHECK_INTERVAL")),
+			Timeout:            viper.GetDuration(key("HEALTH_CHECK_TIMEOUT")),
+			Path:               viper.GetString(key("HEALTH_PATH")),
+			HealthyThreshold:   max(viper.GetInt(key("HEALTH_CHECK_HEALTHY_THRESHOLD")), 1),
+			UnhealthyThreshold: max(viper.GetInt(key("HEALTH_CHECK_UNHEALTHY_THRESHOLD")), 1),
+		},
+		Outlier: OutlierConfig{
+			ConsecutiveFailures: viper.GetInt(key("OUTLIER_CONSECUTIVE_FAILURES")),
+			FailureRate:         viper.GetFloat64(key("OUTLIER_FAILURE_RATE")),
+			MinRequests:         max(viper.GetInt(key("OUTLIER_MIN_REQUESTS")), 1),
+			Interval:            viper.GetDuration(key("OUTLIER_INTERVAL")),
+			EjectionTime:        viper.GetDuration(key("OUTLIER_EJECTION_TIME")),
+			MaxEjectionTime:     viper.GetDuration(key("OUTLIER_MAX_EJECTION_TIME")),
+			MaxEjectionPercent:  viper.GetInt(key("OUTLIER_MAX_EJECTION_PERCENT")),
+		},
+	}
+	instances := splitList(viper.GetStringSlice(prefix + "INSTANCES"))
+	if pool.Discovery == "" && len(instances) > 0 {
+		pool.Discovery = DiscoveryStatic
+	}
+	if pool.Discovery == "" {
+		return PoolConfig{}, nil
+	}
+
+	for _, instance := range instances {
+		addr, weight, hasWeight := strings.Cut(instance, "=")
+		parsed := InstanceConfig{Addr: addr, Weight: 1}
+		if hasWeight {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 0 {
+				return PoolConfig{}, fmt.Errorf("%sINSTANCES: invalid weight in %q", prefix, instance)
+			}
+			parsed.Weight = w
+		}
+		if host, port, err := net.SplitHostPort(addr); err != nil || host == "" || port == "" {
+			return PoolConfig{}, fmt.Errorf("%sINSTANCES: invalid address %q: must be host:port", prefix, addr)
+		}
+		pool.Instances = append(pool.Instances, parsed)
+	}
+
+	switch pool.Discovery {
+	case DiscoveryStatic:
+		if len(pool.Instances) == 0 {
+			return PoolConfig{}, fmt.Errorf("%sINSTANCES must list the instances for static discovery", prefix)
+		}
+	case DiscoveryDNS, DiscoverySRV:
+		if len(pool.Instances) > 0 {
+			return PoolConfig{}, fmt.Errorf("%sINSTANCES is only used by static discovery", prefix)
+		}
+	default:
+		return PoolConfig{}, fmt.Errorf("%sDISCOVERY: unknown discovery %q; use static, dns or srv", prefix, pool.Discovery)
+	}
+	switch pool.Strategy {
+	case BalanceRoundRobin, BalanceLeastOutstanding, BalanceWeighted:
+	default:
+		return PoolConfig{}, fmt.Errorf("%sBALANCER: unknown strategy %q; use round_robin, least_outstanding or weighted", prefix, pool.Strategy)
+	}
+	if pool.Outlier.FailureRate < 0 || pool.Outlier.FailureRate > 1 {
+		return PoolConfig{}, fmt.Errorf("%sOUTLIER_FAILURE_RATE must be between 0 and 1", prefix)
+	}
+	return pool, nil
+}
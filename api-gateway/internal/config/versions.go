@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the path prefix of the public API; a version is served
+// under apiPrefix + "/" + name.
+const apiPrefix = "/api"
+
+// versionPattern matches version names: v1, v2 and so on.
+var versionPattern = regexp.MustCompile(`^v([1-9][0-9]*)$`)
+
+// APIVersion is a version of the public API from the route table. A
+// deprecated version is served as usual; Deprecated, Sunset and Link are
+// only announced to its clients in response headers.
+type APIVersion struct {
+	// Deprecated is when the version was deprecated; zero while supported
+	Deprecated time.Time `mapstructure:"deprecated"`
+	// Sunset is when the version is expected to be removed
+	Sunset time.Time `mapstructure:"sunset"`
+	// Link points to the migration guide for clients of the version
+	Link string `mapstructure:"link"`
+}
+
+// RouteTransform adapts a route to an API version whose request or
+// response bodies differ from the upstream's.
+type RouteTransform struct {
+	// Schema replaces the route's schema, as clients of the version send
+	// the body in their own shape
+	Schema   string        `mapstructure:"schema"`
+	Request  BodyTransform `mapstructure:"request"`
+	Response BodyTransform `mapstructure:"response"`
+}
+
+// BodyTransform rewrites the fields of a JSON body: fields are renamed,
+// then removed, then defaulted. Fields are named by dotted paths (e.g.
+// items.product_id) that apply to every element of the arrays they pass
+// through, as does a transform of a body that is an array.
+type BodyTransform struct {
+	// Rename maps a field's path to its new name, which keeps its parent
+	Rename map[string]string `mapstructure:"rename"`
+	// Remove lists the paths of the fields to drop
+	Remove []string `mapstructure:"remove"`
+	// Defaults sets the fields at these paths when they are missing
+	Defaults map[string]interface{} `mapstructure:"defaults"`
+}
+
+// IsZero reports whether t leaves bodies unchanged.
+func (t BodyTransform) IsZero() bool {
+	return len(t.Rename) == 0 && len(t.Remove) == 0 && len(t.Defaults) == 0
+}
+
+// RouteVersion is the API version a copy of a route made by VersionRoutes
+// is served under.
+type RouteVersion struct {
+	Name string
+	APIVersion
+	// Prefix replaces /api at the start of the route's path
+	Prefix string
+	// Unversioned marks the copy served at the route's own path
+	Unversioned bool
+	// Successor is the prefix of the newest version serving the route, if
+	// that is not this one
+	Successor string
+	Transform RouteTransform
+}
+
+// VersionRoutes returns a copy of route for every API version that serves
+// it, with the path under the version's prefix, and one at the route's own
+// path when the unversioned paths serve one of those versions. Without
+// API versions in the route table the route is returned as it is.
+func (c *Config) VersionRoutes(route Route) []Route {
+	if len(c.APIVersionOrder) == 0 {
+		return []Route{route}
+	}
+
+	var names []string
+	for _, name := range c.APIVersionOrder {
+		if route.servedIn(name, c.APIVersionOrder) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	newest := apiPrefix + "/" + names[len(names)-1]
+
+	var routes []Route
+	add := func(name, prefix string, unversioned bool) {
+		version := &RouteVersion{
+			Name:        name,
+			APIVersion:  c.APIVersions[name],
+			Prefix:      prefix,
+			Unversioned: unversioned,
+			Transform:   route.Transforms[name],
+		}
+		if prefix != newest {
+			version.Successor = newest
+		}
+
+		versioned := route
+		versioned.Path = prefix + strings.TrimPrefix(route.Path, apiPrefix)
+		if version.Transform.Schema != "" {
+			versioned.Schema = version.Transform.Schema
+		}
+		versioned.Version = version
+		routes = append(routes, versioned)
+	}
+	for _, name := range names {
+		add(name, apiPrefix+"/"+name, false)
+	}
+	for _, name := range names {
+		if name == c.UnversionedAPI {
+			add(name, apiPrefix, true)
+		}
+	}
+	return routes
+}
+
+// servedIn reports whether the route is served in the named version,
+// given the versions in order.
+func (r *Route) servedIn(name string, order []string) bool {
+	i := versionIndex(name, order)
+	if r.Since != "" && i < versionIndex(r.Since, order) {
+		return false
+	}
+	if r.Until != "" && i > versionIndex(r.Until, order) {
+		return false
+	}
+	return true
+}
+
+func versionIndex(name string, order []string) int {
+	for i, n := range order {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateVersions checks the API versions of the route table and orders
+// them, oldest first.
+func (c *Config) validateVersions() error {
+	c.APIVersionOrder = make([]string, 0, len(c.APIVersions))
+	for name, version := range c.APIVersions {
+		if !versionPattern.MatchString(name) {
+			return fmt.Errorf("version %s: name must be v followed by a number", name)
+		}
+		if !version.Sunset.IsZero() && version.Deprecated.IsZero() {
+			return fmt.Errorf("version %s: sunset requires deprecated", name)
+		}
+		if !version.Sunset.IsZero() && version.Sunset.Before(version.Deprecated) {
+			return fmt.Errorf("version %s: sunset must not be before deprecated", name)
+		}
+		if version.Link != "" && !strings.HasPrefix(version.Link, "https://") && !strings.HasPrefix(version.Link, "http://") {
+			return fmt.Errorf("version %s: link must be an http or https URL", name)
+		}
+		c.APIVersionOrder = append(c.APIVersionOrder, name)
+	}
+	sort.Slice(c.APIVersionOrder, func(i, j int) bool {
+		return versionNumber(c.APIVersionOrder[i]) < versionNumber(c.APIVersionOrder[j])
+	})
+
+	if c.UnversionedAPI != "" {
+		if _, ok := c.APIVersions[c.UnversionedAPI]; !ok {
+			return fmt.Errorf("unversioned_version: unknown version %q", c.UnversionedAPI)
+		}
+	}
+	if len(c.APIVersions) == 0 && c.UnversionedAPI != "" {
+		return errors.New("unversioned_version requires versions")
+	}
+	return nil
+}
+
+func versionNumber(name string) int {
+	n, _ := strconv.Atoi(versionPattern.FindStringSubmatch(name)[1])
+	return n
+}
+
+// validateRouteVersions checks the version bounds and transforms of route.
+func (c *Config) validateRouteVersions(route *Route) error {
+	if len(c.APIVersions) == 0 {
+		if route.Since != "" || route.Until != "" || len(route.Transforms) > 0 {
+			return errors.New("since, until and transforms require versions")
+		}
+		return nil
+	}
+
+	if route.Path != apiPrefix && !strings.HasPrefix(route.Path, apiPrefix+"/") {
+		return fmt.Errorf("path must start with %s/ to be versioned", apiPrefix)
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(route.Path, apiPrefix+"/"), "/")
+	if versionPattern.MatchString(first) {
+		return errors.New("path must not include the version; it is added for every version serving the route")
+	}
+	for _, bound := range []string{route.Since, route.Until} {
+		if _, ok := c.APIVersions[bound]; bound != "" && !ok {
+			return fmt.Errorf("unknown version %q", bound)
+		}
+	}
+	if route.Since != "" && route.Until != "" && versionNumber(route.Since) > versionNumber(route.Until) {
+		return errors.New("since must not be after until")
+	}
+
+	for name, transform := range route.Transforms {
+		if _, ok := c.APIVersions[name]; !ok {
+			return fmt.Errorf("transforms: unknown version %q", name)
+		}
+		if !route.servedIn(name, c.APIVersionOrder) {
+			return fmt.Errorf("transforms: the route is not served in %s", name)
+		}
+		if transform.Schema != "" && route.Method == http.MethodGet {
+			return fmt.Errorf("transforms %s: GET requests have no body to validate", name)
+		}
+		if !transform.Request.IsZero() && route.Method == http.MethodGet {
+			return fmt.Errorf("transforms %s: GET requests have no body to transform", name)
+		}
+		for _, body := range []BodyTransform{transform.Request, transform.Response} {
+			if err := body.validate(); err != nil {
+				return fmt.Errorf("transforms %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t BodyTransform) validate() error {
+	paths := make([]string, 0, len(t.Rename)+len(t.Remove)+len(t.Defaults))
+	for path, name := range t.Rename {
+		if name == "" || strings.Contains(name, ".") {
+			return fmt.Errorf("rename %s: the new name must be a single field name", path)
+		}
+		paths = append(paths, path)
+	}
+	paths = append(paths, t.Remove...)
+	for path := range t.Defaults {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if err := validateFieldPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFieldPath checks a dotted path naming a field of a JSON body.
+func validateFieldPath(path string) error {
+	for _, field := range strings.Split(path, ".") {
+		if field == "" {
+			return fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return nil
+}
+
+// Schemas returns the names of the JSON Schemas the routes refer to.
+func (c *Config) Schemas() []string {
+	var schemas []string
+	for _, route := range c.Routes {
+		if route.Schema != "" {
+			schemas = append(schemas, route.Schema)
+		}
+		for _, transform := range route.Transforms {
+			if transform.Schema != "" {
+				schemas = append(schemas, transform.Schema)
+			}
+		}
+	}
+	return schemas
+}
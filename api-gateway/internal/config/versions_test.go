@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+)
+
+// defaultConfig returns a Config with the embedded route table.
+func defaultConfig(t *testing.T) *Config {
+	t.Helper()
+	table, err := loadRouteTable("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		MaxBodyBytes:        1 << 20,
+		IdentityServiceURL:  "http://identity:8084",
+		ProductServiceURL:   "http://product:8081",
+		CartServiceURL:      "http://cart:8083",
+		OrderServiceURL:     "http://order:8082",
+		InventoryServiceURL: "http://inventory:8085",
+		PaymentServiceURL:   "http://payment:8086",
+		Routes:              table.Routes,
+		RateLimits:          table.RateLimits,
+		Policies:            table.Policies,
+		RoleScopes:          table.RoleScopes,
+		APIVersions:         table.Versions,
+		UnversionedAPI:      table.UnversionedVersion,
+	}
+	if err := cfg.validateRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestDefaultRoutesTransformProductsInV2(t *testing.T) {
+	cfg := defaultConfig(t)
+
+	tests := []struct {
+		route      string
+		path       string
+		version    string
+		schema     string
+		renamed    string
+		renamedTo  string
+		transforms bool
+	}{
+		{"UpdateProduct", "/api/v1/products/{id}", "v1", "product", "", "", false},
+		{"UpdateProduct", "/api/products/{id}", "v1", "product", "", "", false},
+		{"UpdateProduct", "/api/v2/products/{id}", "v2", "product-v2", "image_url", "image", true},
+		{"CreateProduct", "/api/v2/products", "v2", "product-v2", "image_url", "image", true},
+		{"GetProduct", "/api/v2/products/{id}", "v2", "", "stock", "stock_quantity", true},
+		{"ListProducts", "/api/v1/products", "v1", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route+" "+tt.path, func(t *testing.T) {
+			var found *Route
+			for _, route := range cfg.Routes {
+				if route.Name != tt.route {
+					continue
+				}
+				for _, versioned := range cfg.VersionRoutes(route) {
+					if versioned.Path == tt.path {
+						found = &versioned
+					}
+				}
+			}
+			if found == nil {
+				t.Fatalf("no route %s at %s", tt.route, tt.path)
+			}
+			if found.Version.Name != tt.version {
+				t.Errorf("got version %s, want %s", found.Version.Name, tt.version)
+			}
+			if found.Schema != tt.schema {
+				t.Errorf("got schema %q, want %q", found.Schema, tt.schema)
+			}
+			transform := found.Version.Transform
+			if got := !transform.Request.IsZero() || !transform.Response.IsZero(); got != tt.transforms {
+				t.Fatalf("got transforms %+v", transform)
+			}
+			if tt.renamed != "" && transform.Response.Rename[tt.renamed] != tt.renamedTo {
+				t.Errorf("response renames %s to %q, want %q", tt.renamed, transform.Response.Rename[tt.renamed], tt.renamedTo)
+			}
+		})
+	}
+}
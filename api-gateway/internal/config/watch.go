@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// watchDebounce coalesces the bursts of events a single save produces,
+// such as a truncate followed by a write, so a half-written file is not
+// loaded.
+const watchDebounce = 250 * time.Millisecond
+
+// WatchFiles calls onChange with the path of the config file, the profile
+// file or the routes file whenever one of them is written or replaced. Each file gets
+// a viper instance of its own that only detects changes; the configuration
+// itself is read again by Load, so the global viper is never touched from
+// the watcher goroutines.
+func (c *Config) WatchFiles(onChange func(path string)) error {
+	for _, path := range []string{c.ConfigFile, c.ProfileFile, c.RoutesFile} {
+		if path == "" {
+			continue
+		}
+
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+
+		var (
+			mu    sync.Mutex
+			timer *time.Timer
+		)
+		v.OnConfigChange(func(fsnotify.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(watchDebounce, func() { onChange(path) })
+		})
+		v.WatchConfig()
+	}
+	return nil
+}
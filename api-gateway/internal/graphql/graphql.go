@@ -0,0 +1,195 @@
+// Package graphql serves a GraphQL schema over the catalog, cart, orders
+// and profile of the caller, resolved against the upstream services. Reads
+// of products and their stock go through per-request loaders, so a query
+// touching the same product many times, or many products at one level,
+// costs one batch of upstream calls rather than one call per field.
+//
+// Every request must be authenticated; the caller's identity comes from
+// the UserClaims the auth middleware put on the context, never from the
+// query. Queries deeper or more complex than the configured limits are
+// rejected before any resolver runs.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"go.uber.org/zap"
+)
+
+// Caller sends requests to the upstream services.
+type Caller interface {
+	// Call sends a request with the JSON encoding of body, if not nil, to
+	// path on the named upstream and decodes a 2xx response into out. Any
+	// other response is returned as an *UpstreamError.
+	Call(ctx context.Context, upstream, method, path string, body, out interface{}) error
+}
+
+// UpstreamError is a non-2xx response from an upstream service.
+type UpstreamError struct {
+	Upstream string
+	Status   int
+	// Code and Detail are taken from the problem document, if any
+	Code   string
+	Detail string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return fmt.Sprintf("%s service returned %d", e.Upstream, e.Status)
+}
+
+// Extensions adds the problem code and status to the GraphQL error.
+func (e *UpstreamError) Extensions() map[string]interface{} {
+	code := e.Code
+	if code == "" {
+		code = problem.CodeUpstreamDown
+	}
+	return map[string]interface{}{"code": code, "status": e.Status}
+}
+
+// Error is a GraphQL error with a problem code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// Limits protect the upstreams from expensive queries.
+type Limits struct {
+	// MaxDepth is the deepest allowed nesting of fields
+	MaxDepth int
+	// MaxComplexity bounds the number of fields a query may resolve, with
+	// the fields under a list counted once per expected element
+	MaxComplexity int
+	// Introspection allows __schema and __type queries
+	Introspection bool
+}
+
+// Handler serves GraphQL requests over HTTP.
+type Handler struct {
+	schema graphql.Schema
+	caller Caller
+	limits Limits
+	logger *zap.SugaredLogger
+}
+
+// request is a GraphQL request as sent in a POST body.
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func NewHandler(caller Caller, limits Limits, logger *zap.SugaredLogger) (*Handler, error) {
+	schema, err := newSchema()
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{schema: schema, caller: caller, limits: limits, logger: logger}, nil
+}
+
+// ServeHTTP executes a GraphQL request sent as a JSON POST body. Errors
+// in the query, including exceeded limits, are reported in the errors of
+// a 200 response as usual for GraphQL; only requests that are not GraphQL
+// at all get a problem document.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := r.Context().Value(middleware.UserKey).(*middleware.UserClaims)
+	if claims == nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Request body must be a JSON GraphQL request")
+		return
+	}
+	if req.Query == "" {
+		problem.Write(w, r, problem.Validation(problem.Required("query")))
+		return
+	}
+
+	writeResult(w, h.execute(r.Context(), claims, req))
+}
+
+func (h *Handler) execute(ctx context.Context, claims *middleware.UserClaims, req request) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(req.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	validation := graphql.ValidateDocument(&h.schema, doc, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	if err := h.checkLimits(doc, req.OperationName, req.Variables); err != nil {
+		h.logger.Warnw("GraphQL query rejected",
+			"user_id", claims.UserID,
+			"operation", req.OperationName,
+			"error", err,
+		)
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message:    err.Error(),
+			Locations:  []location.SourceLocation{},
+			Extensions: err.Extensions(),
+		}}}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       withRequest(ctx, claims, h.caller),
+	})
+}
+
+func (h *Handler) checkLimits(doc *ast.Document, operationName string, variables map[string]interface{}) *Error {
+	op := operation(doc, operationName)
+	if op == nil {
+		// Execution reports the missing operation
+		return nil
+	}
+
+	a := newAnalysis(&h.schema, doc, variables)
+	depth, complexity, introspection := a.measure(op)
+	switch {
+	case introspection && !h.limits.Introspection:
+		return &Error{Code: "introspection_disabled", Message: "Introspection is disabled"}
+	case h.limits.MaxDepth > 0 && depth > h.limits.MaxDepth:
+		return &Error{Code: "query_too_deep", Message: fmt.Sprintf("Query depth %d exceeds the limit of %d", depth, h.limits.MaxDepth)}
+	case h.limits.MaxComplexity > 0 && complexity > h.limits.MaxComplexity:
+		return &Error{Code: "query_too_complex", Message: fmt.Sprintf("Query complexity %d exceeds the limit of %d", complexity, h.limits.MaxComplexity)}
+	}
+	return nil
+}
+
+func writeResult(w http.ResponseWriter, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(result)
+}
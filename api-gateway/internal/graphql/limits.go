@@ -0,0 +1,184 @@
+package graphql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// defaultListSize is the number of elements expected of a list field that
+// takes no limit argument.
+const defaultListSize = 10
+
+// analysis measures the depth and complexity of an operation. The
+// document must have passed validation, so fragments exist and do not
+// form cycles.
+type analysis struct {
+	schema    *graphql.Schema
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+	// measured memoizes the cost of each fragment, which depends only on
+	// the fragment since its type condition is fixed
+	measured      map[string]cost
+	introspection bool
+}
+
+type cost struct {
+	depth      int
+	complexity int
+}
+
+func newAnalysis(schema *graphql.Schema, doc *ast.Document, variables map[string]interface{}) *analysis {
+	a := &analysis{
+		schema:    schema,
+		variables: variables,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		measured:  make(map[string]cost),
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			a.fragments[frag.Name.Value] = frag
+		}
+	}
+	return a
+}
+
+// operation returns the operation of doc that a request for name executes.
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+// measure returns the depth of op's deepest field and its complexity:
+// every field counts 1, and the fields below a list count once for each
+// element it is expected to hold. Introspection fields are not counted,
+// but reported.
+func (a *analysis) measure(op *ast.OperationDefinition) (depth, complexity int, introspection bool) {
+	var root *graphql.Object
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		root = a.schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = a.schema.SubscriptionType()
+	default:
+		root = a.schema.QueryType()
+	}
+	if root == nil {
+		return 0, 0, false
+	}
+	c := a.selectionSet(op.SelectionSet, root)
+	return c.depth, c.complexity, a.introspection
+}
+
+func (a *analysis) selectionSet(set *ast.SelectionSet, parent graphql.Type) cost {
+	var total cost
+	if set == nil {
+		return total
+	}
+	for _, sel := range set.Selections {
+		var c cost
+		switch sel := sel.(type) {
+		case *ast.Field:
+			name := sel.Name.Value
+			if strings.HasPrefix(name, "__") {
+				a.introspection = a.introspection || name != "__typename"
+				continue
+			}
+			def := fieldDefinition(parent, name)
+			if def == nil {
+				continue
+			}
+			named, _ := graphql.GetNamed(def.Type).(graphql.Type)
+			child := a.selectionSet(sel.SelectionSet, named)
+			c.depth = 1 + child.depth
+			c.complexity = 1 + child.complexity*a.listSize(def, sel)
+		case *ast.InlineFragment:
+			typ := parent
+			if sel.TypeCondition != nil {
+				typ = a.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			c = a.selectionSet(sel.SelectionSet, typ)
+		case *ast.FragmentSpread:
+			c = a.fragment(sel.Name.Value)
+		}
+		total.depth = max(total.depth, c.depth)
+		total.complexity += c.complexity
+	}
+	return total
+}
+
+func (a *analysis) fragment(name string) cost {
+	if c, ok := a.measured[name]; ok {
+		return c
+	}
+	frag, ok := a.fragments[name]
+	if !ok {
+		return cost{}
+	}
+	c := a.selectionSet(frag.SelectionSet, a.schema.Type(frag.TypeCondition.Name.Value))
+	a.measured[name] = c
+	return c
+}
+
+// listSize returns the number of elements expected of the field: its
+// limit argument for a list, or 1 for anything else.
+func (a *analysis) listSize(def *graphql.FieldDefinition, field *ast.Field) int {
+	typ := def.Type
+	if nonNull, ok := typ.(*graphql.NonNull); ok {
+		typ = nonNull.OfType
+	}
+	if _, ok := typ.(*graphql.List); !ok {
+		return 1
+	}
+
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			switch n := a.variables[v.Name.Value].(type) {
+			case float64:
+				return max(int(n), 1)
+			case int:
+				return max(n, 1)
+			}
+		}
+	}
+	for _, arg := range def.Args {
+		if n, ok := arg.DefaultValue.(int); ok && arg.Name() == "limit" {
+			return max(n, 1)
+		}
+	}
+	return defaultListSize
+}
+
+func fieldDefinition(parent graphql.Type, name string) *graphql.FieldDefinition {
+	switch t := parent.(type) {
+	case *graphql.Object:
+		return t.Fields()[name]
+	case *graphql.Interface:
+		return t.Fields()[name]
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc loads the values for keys. It returns one value and one error
+// per key, in the order of keys; errs may be nil when all succeeded.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (values []V, errs []error)
+
+// Loader batches and deduplicates the loads of one GraphQL request. Load
+// only queues a key and returns a thunk; the executor resolves all the
+// thunks of a level of the query after creating them, so the first thunk
+// called loads every key queued so far in a single batch.
+type Loader[K comparable, V any] struct {
+	batch BatchFunc[K, V]
+
+	mu      sync.Mutex
+	pending []K
+	results map[K]*loadResult[V]
+}
+
+type loadResult[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func NewLoader[K comparable, V any](batch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		batch:   batch,
+		results: make(map[K]*loadResult[V]),
+	}
+}
+
+// Load queues key and returns a thunk yielding its value. Loading the same
+// key again returns the result of the first load.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) func() (V, error) {
+	l.mu.Lock()
+	res, ok := l.results[key]
+	if !ok {
+		res = &loadResult[V]{done: make(chan struct{})}
+		l.results[key] = res
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.dispatch(ctx)
+		<-res.done
+		return res.value, res.err
+	}
+}
+
+// Prime stores value for key unless it is already loaded or queued.
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.results[key]; ok {
+		return
+	}
+	res := &loadResult[V]{done: make(chan struct{}), value: value}
+	close(res.done)
+	l.results[key] = res
+}
+
+// dispatch loads the queued keys.
+func (l *Loader[K, V]) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	results := make([]*loadResult[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.results[key]
+	}
+	l.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	values, errs := l.batch(ctx, keys)
+	for i, res := range results {
+		if i < len(values) {
+			res.value = values[i]
+		}
+		if i < len(errs) {
+			res.err = errs[i]
+		}
+		close(res.done)
+	}
+}
@@ -0,0 +1,219 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+)
+
+// stockConcurrency bounds the inventory lookups in flight for one batch;
+// the inventory service has no batch endpoint.
+const stockConcurrency = 8
+
+// productBatchSize is the most IDs the product service accepts in one ids
+// filter.
+const productBatchSize = 100
+
+type Product struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	ImageURL    string  `json:"image_url"`
+}
+
+type Stock struct {
+	Status    string `json:"status"`
+	Available int    `json:"available"`
+}
+
+type Cart struct {
+	UserID string     `json:"user_id"`
+	Items  []CartItem `json:"items"`
+	Total  float64    `json:"total"`
+}
+
+type CartItem struct {
+	ProductID string  `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+type Order struct {
+	ID              string      `json:"id"`
+	UserID          string      `json:"user_id"`
+	Status          string      `json:"status"`
+	ShippingAddress string      `json:"shipping_address"`
+	PaymentMethod   string      `json:"payment_method"`
+	Total           float64     `json:"total"`
+	CreatedAt       string      `json:"created_at"`
+	UpdatedAt       string      `json:"updated_at"`
+	Items           []OrderItem `json:"items"`
+}
+
+type OrderItem struct {
+	ProductID   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Quantity    int     `json:"quantity"`
+	Price       float64 `json:"price"`
+}
+
+type User struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type contextKey struct{}
+
+// requestState is what the resolvers of one request share.
+type requestState struct {
+	claims   *middleware.UserClaims
+	caller   Caller
+	products *Loader[string, *Product]
+	stock    *Loader[string, *Stock]
+}
+
+func withRequest(ctx context.Context, claims *middleware.UserClaims, caller Caller) context.Context {
+	rs := &requestState{claims: claims, caller: caller}
+	rs.products = NewLoader(rs.loadProducts)
+	rs.stock = NewLoader(rs.loadStock)
+	return context.WithValue(ctx, contextKey{}, rs)
+}
+
+func state(ctx context.Context) *requestState {
+	return ctx.Value(contextKey{}).(*requestState)
+}
+
+// loadProducts fetches a batch of products with one request per
+// productBatchSize IDs, sent concurrently. Unknown IDs load as nil; a
+// failed request fails the IDs it asked for.
+func (rs *requestState) loadProducts(ctx context.Context, ids []string) ([]*Product, []error) {
+	values := make([]*Product, len(ids))
+	errs := make([]error, len(ids))
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(ids); start += productBatchSize {
+		end := min(start+productBatchSize, len(ids))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunk := ids[start:end]
+			var products []*Product
+			err := rs.caller.Call(ctx, "product", http.MethodGet, "/api/products?ids="+url.QueryEscape(strings.Join(chunk, ",")), nil, &products)
+			if err != nil {
+				copy(errs[start:end], repeat(err, len(chunk)))
+				return
+			}
+
+			byID := make(map[string]*Product, len(products))
+			for _, p := range products {
+				byID[p.ID] = p
+			}
+			for i, id := range chunk {
+				values[start+i] = byID[id]
+			}
+		}()
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// loadStock fetches the stock of a batch of products concurrently.
+// Products without an inventory record load as nil.
+func (rs *requestState) loadStock(ctx context.Context, ids []string) ([]*Stock, []error) {
+	values := make([]*Stock, len(ids))
+	errs := make([]error, len(ids))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, stockConcurrency)
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var resp struct {
+				Item Stock `json:"item"`
+			}
+			err := rs.caller.Call(ctx, "inventory", http.MethodGet, "/api/inventory/"+url.PathEscape(id), nil, &resp)
+			if isNotFound(err) {
+				return
+			}
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			values[i] = &resp.Item
+		}()
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// order fetches an order of the caller. Orders of other users are
+// reported as not found, like the REST routes do.
+func (rs *requestState) order(ctx context.Context, id string) (*Order, error) {
+	var order Order
+	err := rs.caller.Call(ctx, "order", http.MethodGet, "/api/orders/"+url.PathEscape(id), nil, &order)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != rs.claims.UserID {
+		return nil, nil
+	}
+	return &order, nil
+}
+
+func (rs *requestState) cartPath(suffix string) string {
+	return "/api/carts/" + url.PathEscape(rs.claims.UserID) + suffix
+}
+
+func isNotFound(err error) bool {
+	var upstreamErr *UpstreamError
+	return errors.As(err, &upstreamErr) && upstreamErr.Status == http.StatusNotFound
+}
+
+func repeat(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// validation returns the error for an invalid argument.
+func validation(message string) error {
+	return &Error{Code: problem.CodeValidation, Message: message}
+}
+
+// checkQuantity applies the limits of the cart-item JSON Schema.
+func checkQuantity(quantity int) error {
+	if quantity < 1 || quantity > 999 {
+		return validation("quantity must be between 1 and 999, got " + strconv.Itoa(quantity))
+	}
+	return nil
+}
+
+// checkLength applies the string limits of the JSON Schemas.
+func checkLength(name, value string, maxLen int) error {
+	if value == "" {
+		return validation(name + " is required")
+	}
+	if len(value) > maxLen {
+		return validation(name + " must be at most " + strconv.Itoa(maxLen) + " characters")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// productCaller serves /api/products?ids= like the product service,
+// refusing more than productBatchSize IDs.
+type productCaller struct {
+	mu      sync.Mutex
+	batches [][]string
+	// missing IDs are left out of the responses
+	missing map[string]bool
+}
+
+func (c *productCaller) Call(_ context.Context, upstream, method, path string, _, out interface{}) error {
+	if upstream != "product" || method != http.MethodGet {
+		return fmt.Errorf("unexpected call %s %s %s", upstream, method, path)
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	ids := strings.Split(u.Query().Get("ids"), ",")
+
+	c.mu.Lock()
+	c.batches = append(c.batches, ids)
+	c.mu.Unlock()
+	if len(ids) > productBatchSize {
+		return &UpstreamError{Upstream: upstream, Status: http.StatusBadRequest}
+	}
+
+	var products []*Product
+	for _, id := range ids {
+		if !c.missing[id] {
+			products = append(products, &Product{ID: id, Name: "Product " + id})
+		}
+	}
+	data, err := json.Marshal(products)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func TestLoadProductsSplitsBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     int
+		batches int
+	}{
+		{"one", 1, 1},
+		{"exactly one batch", productBatchSize, 1},
+		{"one over", productBatchSize + 1, 2},
+		{"several batches", 2*productBatchSize + 50, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := &productCaller{missing: map[string]bool{"p3": true}}
+			rs := &requestState{caller: caller}
+			ids := make([]string, tt.ids)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("p%d", i)
+			}
+
+			values, errs := rs.loadProducts(context.Background(), ids)
+
+			if len(caller.batches) != tt.batches {
+				t.Fatalf("got %d requests, want %d", len(caller.batches), tt.batches)
+			}
+			for _, batch := range caller.batches {
+				if len(batch) > productBatchSize {
+					t.Errorf("request asked for %d IDs", len(batch))
+				}
+			}
+			for i, id := range ids {
+				if errs[i] != nil {
+					t.Fatalf("%s: unexpected error %v", id, errs[i])
+				}
+				switch {
+				case id == "p3":
+					if values[i] != nil {
+						t.Errorf("%s: missing product loaded as %+v", id, values[i])
+					}
+				case values[i] == nil || values[i].ID != id:
+					t.Errorf("%s: got %+v", id, values[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package graphql
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+)
+
+// maxProducts caps the limit argument of the products query.
+const maxProducts = 100
+
+// prop returns a field resolved from its source of type T.
+func prop[T any](typ graphql.Output, get func(T) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: typ,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(T)), nil
+		},
+	}
+}
+
+// later adapts a loader thunk to the thunks the executor resolves after
+// the other fields of the level, keeping a nil result null.
+func later[V any](thunk func() (*V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		v, err := thunk()
+		if v == nil || err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// productField resolves the product of a cart or order item through the
+// product loader.
+func productField(productID func(interface{}) string, productType *graphql.Object) *graphql.Field {
+	return &graphql.Field{
+		Type: productType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return later(state(p.Context).products.Load(p.Context, productID(p.Source))), nil
+		},
+	}
+}
+
+func newSchema() (graphql.Schema, error) {
+	stockType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Stock",
+		Description: "Availability of a product as reported by the inventory service.",
+		Fields: graphql.Fields{
+			"status":    prop(graphql.NewNonNull(graphql.String), func(s *Stock) interface{} { return s.Status }),
+			"available": prop(graphql.NewNonNull(graphql.Int), func(s *Stock) interface{} { return s.Available }),
+		},
+	})
+
+	productType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"id":          prop(graphql.NewNonNull(graphql.ID), func(p *Product) interface{} { return p.ID }),
+			"name":        prop(graphql.NewNonNull(graphql.String), func(p *Product) interface{} { return p.Name }),
+			"description": prop(graphql.String, func(p *Product) interface{} { return p.Description }),
+			"price":       prop(graphql.NewNonNull(graphql.Float), func(p *Product) interface{} { return p.Price }),
+			"imageUrl":    prop(graphql.String, func(p *Product) interface{} { return p.ImageURL }),
+			"stock": &graphql.Field{
+				Type:        stockType,
+				Description: "Null when the product has no inventory record.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return later(state(p.Context).stock.Load(p.Context, p.Source.(*Product).ID)), nil
+				},
+			},
+		},
+	})
+
+	cartItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CartItem",
+		Fields: graphql.Fields{
+			"productId": prop(graphql.NewNonNull(graphql.ID), func(i CartItem) interface{} { return i.ProductID }),
+			"quantity":  prop(graphql.NewNonNull(graphql.Int), func(i CartItem) interface{} { return i.Quantity }),
+			"price":     prop(graphql.NewNonNull(graphql.Float), func(i CartItem) interface{} { return i.Price }),
+			"product":   productField(func(src interface{}) string { return src.(CartItem).ProductID }, productType),
+		},
+	})
+
+	cartType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Cart",
+		Fields: graphql.Fields{
+			"items": prop(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(cartItemType))), func(c *Cart) interface{} { return c.Items }),
+			"total": prop(graphql.NewNonNull(graphql.Float), func(c *Cart) interface{} { return c.Total }),
+		},
+	})
+
+	orderItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderItem",
+		Fields: graphql.Fields{
+			"productId":   prop(graphql.NewNonNull(graphql.ID), func(i OrderItem) interface{} { return i.ProductID }),
+			"productName": prop(graphql.NewNonNull(graphql.String), func(i OrderItem) interface{} { return i.ProductName }),
+			"quantity":    prop(graphql.NewNonNull(graphql.Int), func(i OrderItem) interface{} { return i.Quantity }),
+			"price":       prop(graphql.NewNonNull(graphql.Float), func(i OrderItem) interface{} { return i.Price }),
+			"product":     productField(func(src interface{}) string { return src.(OrderItem).ProductID }, productType),
+		},
+	})
+
+	orderType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":              prop(graphql.NewNonNull(graphql.ID), func(o *Order) interface{} { return o.ID }),
+			"status":          prop(graphql.NewNonNull(graphql.String), func(o *Order) interface{} { return o.Status }),
+			"shippingAddress": prop(graphql.String, func(o *Order) interface{} { return o.ShippingAddress }),
+			"paymentMethod":   prop(graphql.String, func(o *Order) interface{} { return o.PaymentMethod }),
+			"total":           prop(graphql.NewNonNull(graphql.Float), func(o *Order) interface{} { return o.Total }),
+			"createdAt":       prop(graphql.String, func(o *Order) interface{} { return o.CreatedAt }),
+			"updatedAt":       prop(graphql.String, func(o *Order) interface{} { return o.UpdatedAt }),
+			"items":           prop(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderItemType))), func(o *Order) interface{} { return o.Items }),
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":        prop(graphql.NewNonNull(graphql.ID), func(u *User) interface{} { return u.ID }),
+			"email":     prop(graphql.NewNonNull(graphql.String), func(u *User) interface{} { return u.Email }),
+			"firstName": prop(graphql.String, func(u *User) interface{} { return u.FirstName }),
+			"lastName":  prop(graphql.String, func(u *User) interface{} { return u.LastName }),
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					var user User
+					if err := rs.caller.Call(p.Context, "identity", http.MethodGet, "/api/users/"+url.PathEscape(rs.claims.UserID), nil, &user); err != nil {
+						return nil, err
+					}
+					return &user, nil
+				},
+			},
+			"product": &graphql.Field{
+				Type: productType,
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return later(state(p.Context).products.Load(p.Context, p.Args["id"].(string))), nil
+				},
+			},
+			"products": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(productType))),
+				Args: graphql.FieldConfigArgument{
+					"limit":  {Type: graphql.Int, DefaultValue: 10},
+					"offset": {Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					limit, offset := p.Args["limit"].(int), p.Args["offset"].(int)
+					if limit < 1 || limit > maxProducts {
+						return nil, validation("limit must be between 1 and " + strconv.Itoa(maxProducts))
+					}
+					if offset < 0 {
+						return nil, validation("offset must not be negative")
+					}
+
+					rs := state(p.Context)
+					var products []*Product
+					query := url.Values{"limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(offset)}}
+					if err := rs.caller.Call(p.Context, "product", http.MethodGet, "/api/products?"+query.Encode(), nil, &products); err != nil {
+						return nil, err
+					}
+					for _, product := range products {
+						rs.products.Prime(product.ID, product)
+					}
+					return products, nil
+				},
+			},
+			"cart": &graphql.Field{
+				Type: graphql.NewNonNull(cartType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					var cart Cart
+					if err := rs.caller.Call(p.Context, "cart", http.MethodGet, rs.cartPath(""), nil, &cart); err != nil {
+						return nil, err
+					}
+					return &cart, nil
+				},
+			},
+			"orders": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					var orders []*Order
+					query := url.Values{"user_id": {rs.claims.UserID}}
+					if err := rs.caller.Call(p.Context, "order", http.MethodGet, "/api/orders?"+query.Encode(), nil, &orders); err != nil {
+						return nil, err
+					}
+					return orders, nil
+				},
+			},
+			"order": &graphql.Field{
+				Type:        orderType,
+				Description: "Null when the order does not exist or belongs to another user.",
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					order, err := state(p.Context).order(p.Context, p.Args["id"].(string))
+					if order == nil || err != nil {
+						return nil, err
+					}
+					return order, nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"addToCart": &graphql.Field{
+				Type: graphql.NewNonNull(cartType),
+				Args: graphql.FieldConfigArgument{
+					"productId": {Type: graphql.NewNonNull(graphql.ID)},
+					"quantity":  {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					productID, quantity := p.Args["productId"].(string), p.Args["quantity"].(int)
+					if err := checkLength("productId", productID, 64); err != nil {
+						return nil, err
+					}
+					if err := checkQuantity(quantity); err != nil {
+						return nil, err
+					}
+					rs := state(p.Context)
+					body := map[string]interface{}{"product_id": productID, "quantity": quantity}
+					var cart Cart
+					if err := rs.caller.Call(p.Context, "cart", http.MethodPost, rs.cartPath("/items"), body, &cart); err != nil {
+						return nil, err
+					}
+					return &cart, nil
+				},
+			},
+			"updateCartItem": &graphql.Field{
+				Type: graphql.NewNonNull(cartType),
+				Args: graphql.FieldConfigArgument{
+					"productId": {Type: graphql.NewNonNull(graphql.ID)},
+					"quantity":  {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					productID, quantity := p.Args["productId"].(string), p.Args["quantity"].(int)
+					if err := checkQuantity(quantity); err != nil {
+						return nil, err
+					}
+					rs := state(p.Context)
+					body := map[string]interface{}{"quantity": quantity}
+					var cart Cart
+					if err := rs.caller.Call(p.Context, "cart", http.MethodPut, rs.cartPath("/items/"+url.PathEscape(productID)), body, &cart); err != nil {
+						return nil, err
+					}
+					return &cart, nil
+				},
+			},
+			"removeFromCart": &graphql.Field{
+				Type: graphql.NewNonNull(cartType),
+				Args: graphql.FieldConfigArgument{
+					"productId": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					var cart Cart
+					if err := rs.caller.Call(p.Context, "cart", http.MethodDelete, rs.cartPath("/items/"+url.PathEscape(p.Args["productId"].(string))), nil, &cart); err != nil {
+						return nil, err
+					}
+					return &cart, nil
+				},
+			},
+			"clearCart": &graphql.Field{
+				Type: graphql.NewNonNull(cartType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					var cart Cart
+					if err := rs.caller.Call(p.Context, "cart", http.MethodDelete, rs.cartPath(""), nil, &cart); err != nil {
+						return nil, err
+					}
+					return &cart, nil
+				},
+			},
+			"createOrder": &graphql.Field{
+				Type: graphql.NewNonNull(orderType),
+				Args: graphql.FieldConfigArgument{
+					"shippingAddress": {Type: graphql.NewNonNull(graphql.String)},
+					"paymentMethod":   {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					address, method := p.Args["shippingAddress"].(string), p.Args["paymentMethod"].(string)
+					if err := checkLength("shippingAddress", address, 500); err != nil {
+						return nil, err
+					}
+					if err := checkLength("paymentMethod", method, 64); err != nil {
+						return nil, err
+					}
+					rs := state(p.Context)
+					body := map[string]interface{}{
+						"user_id":          rs.claims.UserID,
+						"shipping_address": address,
+						"payment_method":   method,
+					}
+					var order Order
+					if err := rs.caller.Call(p.Context, "order", http.MethodPost, "/api/orders", body, &order); err != nil {
+						return nil, err
+					}
+					return &order, nil
+				},
+			},
+			"cancelOrder": &graphql.Field{
+				Type: graphql.NewNonNull(orderType),
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rs := state(p.Context)
+					id := p.Args["id"].(string)
+					order, err := rs.order(p.Context, id)
+					if err != nil {
+						return nil, err
+					}
+					if order == nil {
+						return nil, &Error{Code: problem.CodeNotFound, Message: "Order " + id + " not found"}
+					}
+					var cancelled Order
+					if err := rs.caller.Call(p.Context, "order", http.MethodPost, "/api/orders/"+url.PathEscape(id)+"/cancel", nil, &cancelled); err != nil {
+						return nil, err
+					}
+					return &cancelled, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
+)
+
+// adminPolicy is the authorization policy of the admin endpoints.
+const adminPolicy = "gateway-admin"
+
+// adminRoutes are the operator endpoints served by the gateway itself.
+var adminRoutes = []config.Route{
+	{Name: "AdminUpstreams", Method: http.MethodGet, Path: "/admin/upstreams", Auth: true, Policy: adminPolicy},
+}
+
+// UpstreamStats is the state of an upstream as seen by the gateway.
+type UpstreamStats struct {
+	URL            string `json:"url"`
+	CircuitBreaker string `json:"circuit_breaker"`
+	// Pool is set for an upstream with several instances
+	Pool *upstream.PoolStats `json:"pool,omitempty"`
+}
+
+// RegisterAdmin mounts the admin endpoints on r, wrapped in the middleware
+// built for them by mws. They require the gateway-admin policy and are
+// not mounted when the route table does not define it.
+func (h *Handler) RegisterAdmin(r chi.Router, mws ...middleware.RouteMiddleware) {
+	if _, ok := h.cfg.Policies[adminPolicy]; !ok {
+		h.logger.Warnw("Admin endpoints are disabled, the route table has no policy " + adminPolicy)
+		return
+	}
+	handlers := map[string]http.HandlerFunc{
+		"AdminUpstreams": h.AdminUpstreams,
+	}
+	for _, route := range adminRoutes {
+		route = h.cfg.WithDefaults(route)
+		r.Method(route.Method, route.Path, wrapRoute(route, handlers[route.Name], mws))
+	}
+}
+
+// AdminUpstreams reports the circuit breaker of every upstream and the
+// state and request counts of the instances of those with a pool.
+func (h *Handler) AdminUpstreams(w http.ResponseWriter, r *http.Request) {
+	upstreams := make(map[string]UpstreamStats)
+	for name, baseURL := range h.cfg.Upstreams() {
+		stats := UpstreamStats{URL: baseURL}
+		if client, ok := h.clients[name]; ok {
+			stats.CircuitBreaker = client.BreakerState().String()
+			if pool := client.Pool(); pool != nil {
+				poolStats := pool.Stats()
+				stats.Pool = &poolStats
+			}
+		}
+		upstreams[name] = stats
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"upstreams": upstreams})
+}
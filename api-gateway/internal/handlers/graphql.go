@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/graphql"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
+)
+
+// graphqlRoute is the GraphQL endpoint. Its upstream is the one the
+// route middleware reports; the resolvers call every service.
+var graphqlRoute = config.Route{Name: "GraphQL", Method: http.MethodPost, Path: "/graphql", Upstream: "product", Auth: true}
+
+// authorizationKey carries the caller's Authorization header to the
+// upstream calls of the GraphQL resolvers.
+type authorizationKey struct{}
+
+// RegisterGraphQL mounts the GraphQL endpoint on r, wrapped in the
+// middleware built for it by mws.
+func (h *Handler) RegisterGraphQL(r chi.Router, limits graphql.Limits, mws ...middleware.RouteMiddleware) error {
+	gql, err := graphql.NewHandler(graphqlCaller{h}, limits, h.logger)
+	if err != nil {
+		return err
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), authorizationKey{}, r.Header.Get("Authorization"))
+		gql.ServeHTTP(w, r.WithContext(ctx))
+	})
+	route := h.cfg.WithDefaults(graphqlRoute)
+	r.Method(route.Method, route.Path, wrapRoute(route, handler, mws))
+	return nil
+}
+
+// graphqlCaller sends the upstream calls of the GraphQL resolvers through
+// the gateway's upstream clients.
+type graphqlCaller struct {
+	h *Handler
+}
+
+func (c graphqlCaller) Call(ctx context.Context, name, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.h.cfg.Upstreams()[name], "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth, _ := ctx.Value(authorizationKey{}).(string); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	req.Header.Set("X-Request-Id", chimiddleware.GetReqID(ctx))
+	setUser(req)
+
+	resp, err := c.h.do(name, req)
+	if err != nil {
+		return c.transportError(name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		upstreamErr := &graphql.UpstreamError{Upstream: name, Status: resp.StatusCode}
+		var p problem.Problem
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&p) == nil {
+			upstreamErr.Code = p.Code
+			upstreamErr.Detail = p.Detail
+		}
+		return upstreamErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// transportError reports a call that got no response the way the proxy
+// routes do.
+func (c graphqlCaller) transportError(name string, err error) error {
+	var openErr *upstream.OpenError
+	if errors.As(err, &openErr) {
+		return &graphql.UpstreamError{Upstream: name, Status: http.StatusServiceUnavailable, Code: problem.CodeUpstreamDown, Detail: name + " is unavailable"}
+	}
+	if errors.Is(err, errNoClient) {
+		c.h.logger.Errorw("No upstream client", "upstream", name)
+		return &graphql.UpstreamError{Upstream: name, Status: http.StatusBadGateway, Code: problem.CodeUpstreamDown, Detail: name + " is not configured"}
+	}
+	c.h.logger.Errorw("Failed to send request to "+name, "error", err)
+	if isTimeout(err) {
+		return &graphql.UpstreamError{Upstream: name, Status: http.StatusGatewayTimeout, Code: problem.CodeUpstreamTimeout, Detail: "Timed out waiting for " + name}
+	}
+	return &graphql.UpstreamError{Upstream: name, Status: http.StatusServiceUnavailable, Code: problem.CodeUpstreamDown, Detail: "Failed to communicate with " + name}
+}
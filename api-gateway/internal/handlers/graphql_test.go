@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/graphql"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// GraphQL is not versioned: it is served at /graphql alone whatever the
+// API versions, and names the product image imageUrl in every one.
+func TestGraphQLIsNotVersioned(t *testing.T) {
+	product := httptest.NewServer(serveJSON(`[{"id":"p1","name":"Mug","price":10,"image_url":"https://example.com/mug.jpg"}]`))
+	defer product.Close()
+	cfg := &config.Config{
+		ProductServiceURL: product.URL,
+		APIVersions:       map[string]config.APIVersion{"v1": {}, "v2": {}},
+		APIVersionOrder:   []string{"v1", "v2"},
+		UnversionedAPI:    "v1",
+		UpstreamClients:   map[string]config.UpstreamClientConfig{"product": testClientConfig()},
+	}
+	h := NewHandler(cfg, zap.NewNop().Sugar())
+	r := chi.NewRouter()
+	limits := graphql.Limits{MaxDepth: 10, MaxComplexity: 100}
+	if err := h.RegisterGraphQL(r, limits, middleware.RouteAuth(testAuth), middleware.RouteTransform()); err != nil {
+		t.Fatal(err)
+	}
+
+	query := `{"query":"{ products(limit: 1) { id imageUrl } }"}`
+	for path, status := range map[string]int{
+		"/graphql":        http.StatusOK,
+		"/api/v2/graphql": http.StatusNotFound,
+		"/api/graphql":    http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(query))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer u1")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != status {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, status)
+			continue
+		}
+		if status == http.StatusOK && !strings.Contains(rec.Body.String(), `"imageUrl":"https://example.com/mug.jpg"`) {
+			t.Errorf("%s: got %s, want the image as imageUrl", path, rec.Body.String())
+		}
+	}
+}
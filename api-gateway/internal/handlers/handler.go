@@ -1,18 +1,84 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+
 	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
 	"go.uber.org/zap"
 )
 
+// errNoClient is returned for a request to an upstream the handler has no
+// client for.
+var errNoClient = errors.New("no client for upstream")
+
 type Handler struct {
-	cfg    *config.Config
-	logger *zap.SugaredLogger
+	cfg     *config.Config
+	logger  *zap.SugaredLogger
+	clients map[string]*upstream.Client
+	// probeClient is used for readiness probes of the upstreams
+	probeClient *http.Client
 }
 
 func NewHandler(cfg *config.Config, logger *zap.SugaredLogger) *Handler {
 	return &Handler{
-		cfg:    cfg,
-		logger: logger,
+		cfg:         cfg,
+		logger:      logger,
+		clients:     upstream.NewClients(cfg, logger),
+		probeClient: &http.Client{},
+	}
+}
+
+// WithConfig returns a handler for cfg. It shares h's clients of the
+// upstreams whose URL and client settings are unchanged, so a reload keeps
+// their connections, breakers and instance health, and builds new clients
+// for the others. The new clients must be started with StartUpstreams, and
+// the clients only one of the handlers uses closed with CloseUpstreams
+// once that handler is no longer used.
+func (h *Handler) WithConfig(cfg *config.Config) *Handler {
+	clone := *h
+	clone.cfg = cfg
+	clone.clients = make(map[string]*upstream.Client, len(cfg.UpstreamClients))
+	oldURLs, newURLs := h.cfg.Upstreams(), cfg.Upstreams()
+	for name, clientCfg := range cfg.UpstreamClients {
+		if client, ok := h.clients[name]; ok && oldURLs[name] == newURLs[name] && reflect.DeepEqual(client.Config(), clientCfg) {
+			clone.clients[name] = client
+			continue
+		}
+		clone.clients[name] = upstream.NewClient(name, clientCfg, h.logger)
+	}
+	return &clone
+}
+
+// StartUpstreams runs the discovery and health checks of the upstreams'
+// instances until ctx is cancelled. Clients already started are left as
+// they are.
+func (h *Handler) StartUpstreams(ctx context.Context) {
+	for _, client := range h.clients {
+		client.Start(ctx)
+	}
+}
+
+// CloseUpstreams closes the clients of h that other does not share.
+func (h *Handler) CloseUpstreams(other *Handler) {
+	for name, client := range h.clients {
+		if other != nil && other.clients[name] == client {
+			continue
+		}
+		if err := client.Close(); err != nil {
+			h.logger.Warnw("Failed to close upstream client", "upstream", name, "error", err)
+		}
+	}
+}
+
+// do sends req through the client of the named upstream.
+func (h *Handler) do(name string, req *http.Request) (*http.Response, error) {
+	client := h.clients[name]
+	if client == nil {
+		return nil, errNoClient
 	}
+	return client.Do(req)
 }
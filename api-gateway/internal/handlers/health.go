@@ -1,20 +1,138 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
 )
 
 type HealthResponse struct {
-	Status string `json:"status"`
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks,omitempty"`
+}
+
+type DependencyStatus struct {
+	Status         string  `json:"status"`
+	LatencyMS      float64 `json:"latency_ms"`
+	CircuitBreaker string  `json:"circuit_breaker,omitempty"`
+	// Instances and AvailableInstances describe the pool of an upstream
+	// with several instances
+	Instances          int    `json:"instances,omitempty"`
+	AvailableInstances *int   `json:"available_instances,omitempty"`
+	Error              string `json:"error,omitempty"`
 }
 
-func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	response := HealthResponse{
-		Status: "ok",
+// Livez reports that the gateway process is up. It never contacts an
+// upstream, so a struggling dependency cannot get the gateway restarted.
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz queries the health endpoint of every upstream concurrently and
+// reports each of them. It answers 503 only if one of the critical
+// upstreams is unavailable: the gateway still serves the routes of the
+// others, so an outage of one of those is reported as degraded with a 200
+// rather than taking every gateway instance out of service. An upstream
+// with a pool of instances, which are health checked already, is
+// available while any of its instances is.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.ReadinessTimeout)
+	defer cancel()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	critical := make(map[string]bool, len(h.cfg.CriticalUpstreams))
+	for _, name := range h.cfg.CriticalUpstreams {
+		critical[name] = true
+	}
+	response := HealthResponse{Status: "ok", Checks: make(map[string]DependencyStatus)}
+	for name, baseURL := range h.cfg.Upstreams() {
+		wg.Add(1)
+		go func(name, baseURL string) {
+			defer wg.Done()
+
+			client := h.clients[name]
+			var status DependencyStatus
+			if client != nil && client.Pool() != nil {
+				status = checkPool(client.Pool())
+			} else {
+				status = h.checkUpstream(ctx, baseURL+h.cfg.UpstreamHealthPath)
+			}
+			if client != nil {
+				status.CircuitBreaker = client.BreakerState().String()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[name] = status
+			switch {
+			case status.Status == "ok":
+			case critical[name]:
+				response.Status = "unavailable"
+			case response.Status == "ok":
+				response.Status = "degraded"
+			}
+		}(name, baseURL)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if response.Status == "unavailable" {
+		code = http.StatusServiceUnavailable
 	}
+	writeHealth(w, code, response)
+}
+
+// checkUpstream probes a health URL directly rather than through the
+// upstream client, so probes neither retry nor count towards the breaker.
+func (h *Handler) checkUpstream(ctx context.Context, url string) DependencyStatus {
+	start := time.Now()
+	status := DependencyStatus{Status: "ok"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = h.probeClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("health endpoint returned %d", resp.StatusCode)
+			}
+		}
+	}
+
+	status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		status.Status = "unavailable"
+		status.Error = err.Error()
+		h.logger.Warnw("Upstream readiness check failed", "url", url, "error", err)
+	}
+	return status
+}
+
+// checkPool reports the state of an upstream's instances.
+func checkPool(pool *upstream.Pool) DependencyStatus {
+	available, total := pool.Available()
+	status := DependencyStatus{Status: "ok", Instances: total, AvailableInstances: &available}
+	if available == 0 {
+		status.Status = "unavailable"
+		status.Error = "no available instance"
+	}
+	return status
+}
 
+func writeHealth(w http.ResponseWriter, code int, response HealthResponse) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestReadyz(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	tests := []struct {
+		name     string
+		down     []string
+		critical []string
+		status   int
+		want     string
+	}{
+		{"all up", nil, []string{"identity"}, http.StatusOK, "ok"},
+		{"non-critical down", []string{"payment", "inventory"}, []string{"identity", "product"}, http.StatusOK, "degraded"},
+		{"nothing critical", []string{"identity"}, nil, http.StatusOK, "degraded"},
+		{"critical down", []string{"payment", "product"}, []string{"identity", "product"}, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			down := make(map[string]bool)
+			for _, name := range tt.down {
+				down[name] = true
+			}
+			url := func(name string) string {
+				if down[name] {
+					return failing.URL
+				}
+				return healthy.URL
+			}
+			cfg := &config.Config{
+				IdentityServiceURL:  url("identity"),
+				ProductServiceURL:   url("product"),
+				CartServiceURL:      url("cart"),
+				OrderServiceURL:     url("order"),
+				InventoryServiceURL: url("inventory"),
+				PaymentServiceURL:   url("payment"),
+				ReadinessTimeout:    time.Second,
+				UpstreamHealthPath:  "/readyz",
+				CriticalUpstreams:   tt.critical,
+			}
+			h := NewHandler(cfg, zap.NewNop().Sugar())
+
+			rec := httptest.NewRecorder()
+			h.Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+			var response HealthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != tt.want {
+				t.Errorf("got %q, want %q", response.Status, tt.want)
+			}
+			// Every upstream is reported, whether it is critical or not
+			for name := range cfg.Upstreams() {
+				want := "ok"
+				if down[name] {
+					want = "unavailable"
+				}
+				if got := response.Checks[name].Status; got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
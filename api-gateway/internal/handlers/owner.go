@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+)
+
+// checkOwner fetches the resource named by the route's owner section and
+// reports whether it belongs to the caller. If it does not, checkOwner
+// writes the response: a 404 whether the resource is missing or belongs
+// to someone else, so the IDs of other users' resources cannot be probed.
+func (h *Handler) checkOwner(w http.ResponseWriter, r *http.Request, pr *proxyRoute, claims *middleware.UserClaims) bool {
+	owner := pr.Owner
+	service := owner.Upstream + " service"
+
+	resp, err := h.get(r, owner.Upstream, pr.expand(owner.Path, r, claims, url.PathEscape))
+	if err != nil {
+		h.upstreamError(w, r, service, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	var ownerID string
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var doc map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+			h.logger.Errorw("Failed to decode response from "+service, "error", err)
+			problem.Error(w, r, http.StatusBadGateway, problem.CodeUpstreamDown, "Invalid response from "+service)
+			return false
+		}
+		ownerID, _ = doc[owner.Field].(string)
+	case http.StatusNotFound:
+	default:
+		io.Copy(io.Discard, resp.Body)
+		h.logger.Errorw("Unexpected response to ownership check", "route", pr.Name, "upstream", owner.Upstream, "status", resp.StatusCode)
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeUpstreamDown, "Failed to verify ownership with "+service)
+		return false
+	}
+
+	if ownerID != "" && ownerID == claims.UserID {
+		return true
+	}
+	if ownerID != "" {
+		h.logger.Warnw("Ownership check failed",
+			"audit", true,
+			"route", pr.Name,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"user_id", claims.UserID,
+			"owner_id", ownerID,
+			"remote_addr", r.RemoteAddr,
+			"request_id", chimiddleware.GetReqID(r.Context()),
+		)
+	}
+	problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "No resource matches "+r.URL.Path)
+	return false
+}
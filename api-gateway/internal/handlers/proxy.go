@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/accesslog"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// placeholderPattern matches {name} and chi's {name:regexp} placeholders.
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?::[^}]*)?\}`)
+
+// claimPlaceholders resolves template placeholders from the caller's JWT
+// claims. Claims take precedence over URL parameters of the same name so a
+// client can never substitute another user's ID.
+var claimPlaceholders = map[string]func(*middleware.UserClaims) string{
+	"user_id": func(c *middleware.UserClaims) string { return c.UserID },
+	"email":   func(c *middleware.UserClaims) string { return c.Email },
+	"role":    func(c *middleware.UserClaims) string { return c.Role },
+}
+
+// userHeader carries the caller's user ID to the services.
+const userHeader = "X-User-Id"
+
+// hopHeaders are connection-level headers that must not be forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// proxyRoute is a route from the route table with its templates parsed.
+type proxyRoute struct {
+	config.Route
+	baseURL   string
+	path      string
+	query     url.Values
+	service   string
+	useClaims bool
+}
+
+// RegisterRoutes mounts every route in the route table on r, once for
+// every API version serving it, wrapped in the middleware built for it by
+// mws. The first of mws is the outermost.
+func (h *Handler) RegisterRoutes(r chi.Router, mws ...middleware.RouteMiddleware) error {
+	upstreams := h.cfg.Upstreams()
+	for _, route := range h.cfg.Routes {
+		pr, err := compileRoute(route, upstreams[route.Upstream])
+		if err != nil {
+			return fmt.Errorf("route %s %s: %w", route.Method, route.Path, err)
+		}
+
+		for _, versioned := range h.cfg.VersionRoutes(route) {
+			r.Method(versioned.Method, versioned.Path, wrapRoute(versioned, h.proxy(pr), mws))
+		}
+	}
+	return nil
+}
+
+// wrapRoute wraps handler in the middleware built for route by mws.
+func wrapRoute(route config.Route, handler http.Handler, mws []middleware.RouteMiddleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mw := mws[i](route); mw != nil {
+			handler = mw(handler)
+		}
+	}
+	return handler
+}
+
+func compileRoute(route config.Route, baseURL string) (*proxyRoute, error) {
+	pr := &proxyRoute{
+		Route:   route,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		service: route.Upstream + " service",
+	}
+
+	path, rawQuery, _ := strings.Cut(route.UpstreamPath, "?")
+	pr.path = path
+	if rawQuery != "" {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream query: %w", err)
+		}
+		pr.query = query
+	}
+
+	params := make(map[string]bool)
+	for _, m := range placeholderPattern.FindAllStringSubmatch(route.Path, -1) {
+		params[m[1]] = true
+	}
+
+	templates := []string{route.UpstreamPath}
+	for _, v := range route.InjectBody {
+		templates = append(templates, v)
+	}
+	for _, tmpl := range templates {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+			name := m[1]
+			if _, ok := claimPlaceholders[name]; ok {
+				if !route.Auth {
+					return nil, fmt.Errorf("placeholder {%s} requires auth", name)
+				}
+				pr.useClaims = true
+				continue
+			}
+			if !params[name] {
+				return nil, fmt.Errorf("placeholder {%s} is not a claim or a parameter of %s", name, route.Path)
+			}
+		}
+	}
+
+	// The owner check compares the resource with the caller's claims
+	if route.Owner != nil {
+		pr.useClaims = true
+	}
+
+	return pr, nil
+}
+
+// expand substitutes the placeholders in tmpl, escaping each value with
+// escape.
+func (pr *proxyRoute) expand(tmpl string, r *http.Request, claims *middleware.UserClaims, escape func(string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		if claim, ok := claimPlaceholders[name]; ok && claims != nil {
+			return escape(claim(claims))
+		}
+		return escape(chi.URLParam(r, name))
+	})
+}
+
+// targetURL builds the upstream URL for r. Query parameters fixed by the
+// route template override those sent by the client.
+func (pr *proxyRoute) targetURL(r *http.Request, claims *middleware.UserClaims) string {
+	target := pr.baseURL + pr.expand(pr.path, r, claims, url.PathEscape)
+
+	query := r.URL.Query()
+	for key, values := range pr.query {
+		query.Del(key)
+		for _, v := range values {
+			query.Add(key, pr.expand(v, r, claims, func(s string) string { return s }))
+		}
+	}
+	if encoded := query.Encode(); encoded != "" {
+		target += "?" + encoded
+	}
+	return target
+}
+
+// injectBody decodes the JSON request body and sets the route's
+// inject_body fields on it.
+func (pr *proxyRoute) injectBody(r *http.Request, claims *middleware.UserClaims) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for key, tmpl := range pr.InjectBody {
+		fields[key] = pr.expand(tmpl, r, claims, func(s string) string { return s })
+	}
+	return json.Marshal(fields)
+}
+
+func (h *Handler) proxy(pr *proxyRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("api-gateway").Start(r.Context(), pr.Name)
+		defer span.End()
+
+		claims, _ := r.Context().Value(middleware.UserKey).(*middleware.UserClaims)
+		if pr.useClaims && claims == nil {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+			return
+		}
+
+		if pr.Owner != nil && !h.checkOwner(w, r.WithContext(ctx), pr, claims) {
+			return
+		}
+
+		var body io.Reader = r.Body
+		contentLength := r.ContentLength
+		if len(pr.InjectBody) > 0 {
+			injected, err := pr.injectBody(r, claims)
+			if err != nil {
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+				return
+			}
+			body = bytes.NewReader(injected)
+			contentLength = int64(len(injected))
+		}
+
+		req, err := http.NewRequestWithContext(ctx, pr.Method, pr.targetURL(r, claims), body)
+		if err != nil {
+			h.logger.Errorw("Failed to create request to "+pr.service, "error", err)
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+			return
+		}
+		req.ContentLength = contentLength
+
+		copyHeaders(req.Header, r.Header)
+		if len(pr.InjectBody) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		// Lets the services attach the same request ID to their problem documents
+		req.Header.Set("X-Request-Id", chimiddleware.GetReqID(ctx))
+		setUser(req)
+
+		accesslog.SetUpstream(ctx, pr.Upstream)
+		resp, err := h.do(pr.Upstream, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "request to "+pr.service+" failed")
+			h.upstreamError(w, r, pr.service, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		// Upstream responses, problem documents included, are passed
+		// through unchanged.
+		copyHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			h.logger.Errorw("Failed to stream response from "+pr.service, "error", err)
+		}
+	}
+}
+
+// upstreamError writes the problem for a request to service that got no
+// response.
+func (h *Handler) upstreamError(w http.ResponseWriter, r *http.Request, service string, err error) {
+	var openErr *upstream.OpenError
+	if errors.As(err, &openErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(openErr.RetryAfter.Seconds())))))
+		problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUpstreamDown, service+" is unavailable")
+		return
+	}
+	var noInstanceErr *upstream.NoInstanceError
+	if errors.As(err, &noInstanceErr) {
+		problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUpstreamDown, service+" has no available instance")
+		return
+	}
+	if errors.Is(err, errNoClient) {
+		h.logger.Errorw("No upstream client", "upstream", service, "path", r.URL.Path)
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeUpstreamDown, service+" is not configured")
+		return
+	}
+	h.logger.Errorw("Failed to send request to "+service, "error", err)
+	if isTimeout(err) {
+		problem.Error(w, r, http.StatusGatewayTimeout, problem.CodeUpstreamTimeout, "Timed out waiting for "+service)
+		return
+	}
+	problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUpstreamDown, "Failed to communicate with "+service)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// setUser tells the upstream which user req is made for, replacing any
+// user header sent by the client. The services only log it; the token is
+// what authenticates the caller.
+func setUser(req *http.Request) {
+	req.Header.Del(userHeader)
+	if claims, _ := req.Context().Value(middleware.UserKey).(*middleware.UserClaims); claims != nil {
+		req.Header.Set(userHeader, claims.UserID)
+	}
+}
+
+// copyHeaders adds the end-to-end headers in src to dst.
+func copyHeaders(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+	for _, field := range strings.Split(src.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			dst.Del(field)
+		}
+	}
+	for _, key := range hopHeaders {
+		dst.Del(key)
+	}
+}
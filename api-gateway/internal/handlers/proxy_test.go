@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// upstreamRequest is what a fake upstream saw of a proxied request.
+type upstreamRequest struct {
+	Upstream string `json:"upstream"`
+	Method   string `json:"method"`
+	URI      string `json:"uri"`
+	User     string `json:"user"`
+	Body     string `json:"body"`
+}
+
+// newUpstream starts a server that answers every request with what it saw
+// of it.
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(upstreamRequest{
+			Upstream: name,
+			Method:   r.Method,
+			URI:      r.URL.RequestURI(),
+			User:     r.Header.Get(userHeader),
+			Body:     string(body),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testAuth accepts the user named in the Authorization header.
+func testAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if user == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claims := &middleware.UserClaims{UserID: user, Role: "customer"}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.UserKey, claims)))
+	})
+}
+
+func testClientConfig() config.UpstreamClientConfig {
+	return config.UpstreamClientConfig{
+		DialTimeout:     time.Second,
+		ResponseTimeout: time.Second,
+		Breaker: config.BreakerConfig{
+			FailureRate:    0.5,
+			MinRequests:    10,
+			Window:         time.Minute,
+			ProbeInterval:  time.Second,
+			HalfOpenProbes: 1,
+		},
+	}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	product := newUpstream(t, "product")
+	cart := newUpstream(t, "cart")
+	order := newUpstream(t, "order")
+	cfg := &config.Config{
+		ProductServiceURL: product.URL,
+		CartServiceURL:    cart.URL,
+		OrderServiceURL:   order.URL,
+		// No client is built for payment
+		PaymentServiceURL: "http://payment.invalid",
+		UpstreamClients: map[string]config.UpstreamClientConfig{
+			"product": testClientConfig(),
+			"cart":    testClientConfig(),
+			"order":   testClientConfig(),
+		},
+		Routes: []config.Route{
+			{Name: "ListProducts", Method: http.MethodGet, Path: "/api/products", Upstream: "product", UpstreamPath: "/api/products"},
+			{Name: "GetProduct", Method: http.MethodGet, Path: "/api/products/{id}", Upstream: "product", UpstreamPath: "/api/products/{id}"},
+			{Name: "DeleteProduct", Method: http.MethodDelete, Path: "/api/products/{id}", Upstream: "product", UpstreamPath: "/api/products/{id}", Auth: true},
+			{Name: "GetCart", Method: http.MethodGet, Path: "/api/cart", Upstream: "cart", UpstreamPath: "/api/carts/{user_id}", Auth: true},
+			{Name: "RemoveFromCart", Method: http.MethodDelete, Path: "/api/cart/items/{id}", Upstream: "cart", UpstreamPath: "/api/carts/{user_id}/items/{id}", Auth: true},
+			{Name: "GetOrders", Method: http.MethodGet, Path: "/api/orders", Upstream: "order", UpstreamPath: "/api/orders?user_id={user_id}", Auth: true},
+			{Name: "GetPayment", Method: http.MethodGet, Path: "/api/payments/{id}", Upstream: "payment", UpstreamPath: "/api/payments/{id}"},
+			{Name: "CreateOrder", Method: http.MethodPost, Path: "/api/orders", Upstream: "order", UpstreamPath: "/api/orders", Auth: true, InjectBody: map[string]string{"user_id": "{user_id}"}},
+		},
+	}
+	h := NewHandler(cfg, zap.NewNop().Sugar())
+	r := chi.NewRouter()
+	if err := h.RegisterRoutes(r, middleware.RouteAuth(testAuth)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		body   string
+		status int
+		// want is the request the upstream should see, if any
+		want *upstreamRequest
+	}{
+		{
+			name: "static path", method: http.MethodGet, path: "/api/products",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "product", Method: http.MethodGet, URI: "/api/products"},
+		},
+		{
+			name: "client query is forwarded", method: http.MethodGet, path: "/api/products?limit=5",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "product", Method: http.MethodGet, URI: "/api/products?limit=5"},
+		},
+		{
+			name: "URL parameter", method: http.MethodGet, path: "/api/products/42",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "product", Method: http.MethodGet, URI: "/api/products/42"},
+		},
+		{
+			name: "URL parameter is escaped", method: http.MethodGet, path: "/api/products/a%20b",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "product", Method: http.MethodGet, URI: "/api/products/a%20b"},
+		},
+		{
+			name: "method selects the route", method: http.MethodDelete, path: "/api/products/42", user: "u1",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "product", Method: http.MethodDelete, URI: "/api/products/42", User: "u1"},
+		},
+		{
+			name: "method without a route", method: http.MethodPut, path: "/api/products/42",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "path without a route", method: http.MethodGet, path: "/api/unknown",
+			status: http.StatusNotFound,
+		},
+		{
+			name: "claim placeholder", method: http.MethodGet, path: "/api/cart", user: "u1",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "cart", Method: http.MethodGet, URI: "/api/carts/u1", User: "u1"},
+		},
+		{
+			name: "claim and URL parameter", method: http.MethodDelete, path: "/api/cart/items/7", user: "u1",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "cart", Method: http.MethodDelete, URI: "/api/carts/u1/items/7", User: "u1"},
+		},
+		{
+			name: "template query overrides the client's", method: http.MethodGet, path: "/api/orders?user_id=u2&status=open", user: "u1",
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "order", Method: http.MethodGet, URI: "/api/orders?status=open&user_id=u1", User: "u1"},
+		},
+		{
+			name: "injected body field", method: http.MethodPost, path: "/api/orders", user: "u1", body: `{"user_id":"u2"}`,
+			status: http.StatusOK,
+			want:   &upstreamRequest{Upstream: "order", Method: http.MethodPost, URI: "/api/orders", User: "u1", Body: `{"user_id":"u1"}`},
+		},
+		{
+			name: "upstream without a client", method: http.MethodGet, path: "/api/payments/1",
+			status: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.user != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.user)
+			}
+			// Clients cannot choose the user the upstream sees
+			req.Header.Set(userHeader, "spoofed")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+			var got upstreamRequest
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+			}
+			if got != *tt.want {
+				t.Errorf("upstream got %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   config.Route
+		wantErr string
+	}{
+		{
+			name:  "URL parameter",
+			route: config.Route{Path: "/api/products/{id}", UpstreamPath: "/api/products/{id}"},
+		},
+		{
+			name:  "URL parameter with a pattern",
+			route: config.Route{Path: "/api/products/{id:[0-9]+}", UpstreamPath: "/api/products/{id}"},
+		},
+		{
+			name:  "claim",
+			route: config.Route{Path: "/api/cart", UpstreamPath: "/api/carts/{user_id}", Auth: true},
+		},
+		{
+			name:    "claim without auth",
+			route:   config.Route{Path: "/api/cart", UpstreamPath: "/api/carts/{user_id}"},
+			wantErr: "placeholder {user_id} requires auth",
+		},
+		{
+			name:    "unknown placeholder",
+			route:   config.Route{Path: "/api/products", UpstreamPath: "/api/products/{id}"},
+			wantErr: "placeholder {id} is not a claim or a parameter of /api/products",
+		},
+		{
+			name:    "unknown placeholder in the body",
+			route:   config.Route{Path: "/api/orders", UpstreamPath: "/api/orders", Auth: true, InjectBody: map[string]string{"cart": "{cart_id}"}},
+			wantErr: "placeholder {cart_id} is not a claim or a parameter of /api/orders",
+		},
+		{
+			name:    "invalid query",
+			route:   config.Route{Path: "/api/orders", UpstreamPath: "/api/orders?a=%zz"},
+			wantErr: "invalid upstream query",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRoute(tt.route, "http://upstream")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/upstream"
+	"go.opentelemetry.io/otel"
+)
+
+// storefrontConcurrency bounds the upstream lookups in flight for one
+// storefront request.
+const storefrontConcurrency = 8
+
+// storefrontRoutes are the composition endpoints served by the gateway
+// itself. They go through the same route middleware as the route table,
+// including the transforms to the API versions they differ in; their
+// responses carry the product fields in the product service's shape.
+var storefrontRoutes = []config.Route{
+	{
+		Name: "StorefrontCart", Method: http.MethodGet, Path: "/api/storefront/cart", Upstream: "cart", Auth: true,
+		Transforms: map[string]config.RouteTransform{
+			"v2": {Response: config.BodyTransform{Rename: map[string]string{"items.image_url": "image"}}},
+		},
+	},
+}
+
+// CartView is the cart page: the caller's cart with the current price,
+// image and availability of every item.
+type CartView struct {
+	UserID string         `json:"user_id"`
+	Items  []CartViewItem `json:"items"`
+	Total  float64        `json:"total"`
+	// Degraded is set when any item has warnings
+	Degraded bool `json:"degraded"`
+}
+
+type CartViewItem struct {
+	ProductID string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+	// CartPrice is the price when the item was added to the cart
+	CartPrice    float64       `json:"cart_price"`
+	PriceChanged bool          `json:"price_changed"`
+	ImageURL     string        `json:"image_url"`
+	LineTotal    float64       `json:"line_total"`
+	Availability *Availability `json:"availability"`
+	Warnings     []ItemWarning `json:"warnings,omitempty"`
+}
+
+// Availability is an item's stock as reported by the inventory service.
+type Availability struct {
+	Status    string `json:"status"`
+	Available int    `json:"available"`
+	// Sufficient reports whether the cart quantity can be ordered
+	Sufficient bool `json:"sufficient"`
+}
+
+// ItemWarning explains why part of an item is missing or stale. Source is
+// the upstream that failed; Code is a problem code.
+type ItemWarning struct {
+	Source  string `json:"source"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type upstreamCart struct {
+	UserID string `json:"user_id"`
+	Items  []struct {
+		ProductID   string  `json:"product_id"`
+		ProductName string  `json:"product_name"`
+		Quantity    int     `json:"quantity"`
+		Price       float64 `json:"price"`
+		ImageURL    string  `json:"image_url"`
+	} `json:"items"`
+}
+
+type upstreamProduct struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	ImageURL string  `json:"image_url"`
+}
+
+type upstreamInventory struct {
+	Item struct {
+		Available int    `json:"available"`
+		Status    string `json:"status"`
+	} `json:"item"`
+}
+
+// itemLookup is the outcome of the product and inventory lookups for one
+// product ID.
+type itemLookup struct {
+	product   *upstreamProduct
+	inventory *upstreamInventory
+	warnings  []ItemWarning
+}
+
+// RegisterStorefront mounts the composition endpoints on r, wrapped in the
+// middleware built for them by mws.
+func (h *Handler) RegisterStorefront(r chi.Router, mws ...middleware.RouteMiddleware) {
+	handlers := map[string]http.HandlerFunc{
+		"StorefrontCart": h.StorefrontCart,
+	}
+	for _, route := range storefrontRoutes {
+		for _, versioned := range h.cfg.VersionRoutes(h.cfg.WithDefaults(route)) {
+			r.Method(versioned.Method, versioned.Path, wrapRoute(versioned, handlers[route.Name], mws))
+		}
+	}
+}
+
+// StorefrontCart fetches the caller's cart, then looks up every product in
+// it at the product and inventory services concurrently. Only a failure to
+// fetch the cart fails the request; a failed lookup leaves the item with
+// what the cart recorded and a warning.
+func (h *Handler) StorefrontCart(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("api-gateway").Start(r.Context(), "StorefrontCart")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	claims, _ := ctx.Value(middleware.UserKey).(*middleware.UserClaims)
+	if claims == nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+		return
+	}
+
+	resp, err := h.get(r, "cart", "/api/carts/"+url.PathEscape(claims.UserID))
+	if err != nil {
+		h.upstreamError(w, r, "cart service", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Problem documents from the cart service are passed through.
+		copyHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	var cart upstreamCart
+	if err := json.NewDecoder(resp.Body).Decode(&cart); err != nil {
+		h.logger.Errorw("Failed to decode response from cart service", "error", err)
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeUpstreamDown, "Invalid response from cart service")
+		return
+	}
+
+	var productIDs []string
+	for _, item := range cart.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	lookups := h.lookupItems(r, productIDs)
+
+	view := CartView{UserID: cart.UserID, Items: make([]CartViewItem, 0, len(cart.Items))}
+	for _, item := range cart.Items {
+		lookup := lookups[item.ProductID]
+		vi := CartViewItem{
+			ProductID: item.ProductID,
+			Name:      item.ProductName,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+			CartPrice: item.Price,
+			ImageURL:  item.ImageURL,
+			Warnings:  lookup.warnings,
+		}
+		if p := lookup.product; p != nil {
+			vi.Name, vi.Price, vi.ImageURL = p.Name, p.Price, p.ImageURL
+			vi.PriceChanged = p.Price != item.Price
+		}
+		if inv := lookup.inventory; inv != nil {
+			vi.Availability = &Availability{
+				Status:     inv.Item.Status,
+				Available:  inv.Item.Available,
+				Sufficient: inv.Item.Available >= item.Quantity && inv.Item.Status != "discontinued",
+			}
+		}
+		vi.LineTotal = roundCents(vi.Price * float64(item.Quantity))
+		view.Total += vi.LineTotal
+		view.Degraded = view.Degraded || len(vi.Warnings) > 0
+		view.Items = append(view.Items, vi)
+	}
+	view.Total = roundCents(view.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(view)
+}
+
+// lookupItems fetches every distinct product ID from the product and
+// inventory services, with at most storefrontConcurrency requests in
+// flight and all of them bounded by StorefrontFanoutTimeout.
+func (h *Handler) lookupItems(r *http.Request, productIDs []string) map[string]*itemLookup {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.StorefrontFanoutTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, storefrontConcurrency)
+	)
+	lookups := make(map[string]*itemLookup)
+	for _, id := range productIDs {
+		if _, ok := lookups[id]; ok {
+			continue
+		}
+		lookup := &itemLookup{}
+		lookups[id] = lookup
+
+		path := "/" + url.PathEscape(id)
+		fetches := []struct {
+			upstream string
+			path     string
+			out      interface{}
+		}{
+			{"product", "/api/products" + path, &upstreamProduct{}},
+			{"inventory", "/api/inventory" + path, &upstreamInventory{}},
+		}
+		for _, f := range fetches {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				warning := h.getJSON(r, f.upstream, f.path, f.out)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if warning != nil {
+					h.logger.Warnw("Storefront lookup failed",
+						"upstream", f.upstream,
+						"product_id", id,
+						"code", warning.Code,
+						"message", warning.Message,
+						"request_id", chimiddleware.GetReqID(ctx),
+					)
+					lookup.warnings = append(lookup.warnings, *warning)
+					return
+				}
+				switch out := f.out.(type) {
+				case *upstreamProduct:
+					lookup.product = out
+				case *upstreamInventory:
+					lookup.inventory = out
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return lookups
+}
+
+// getJSON decodes the 200 response to a GET for path into out, or returns
+// a warning describing why it could not.
+func (h *Handler) getJSON(r *http.Request, name, path string, out interface{}) *ItemWarning {
+	service := name + " service"
+	resp, err := h.get(r, name, path)
+	if err != nil {
+		var openErr *upstream.OpenError
+		switch {
+		case errors.As(err, &openErr):
+			return &ItemWarning{Source: name, Code: problem.CodeUpstreamDown, Message: service + " is unavailable"}
+		case isTimeout(err):
+			return &ItemWarning{Source: name, Code: problem.CodeUpstreamTimeout, Message: "Timed out waiting for " + service}
+		}
+		return &ItemWarning{Source: name, Code: problem.CodeUpstreamDown, Message: "Failed to communicate with " + service}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return &ItemWarning{Source: name, Code: problem.CodeNotFound, Message: "Product not found by " + service}
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return &ItemWarning{Source: name, Code: problem.CodeUpstreamDown, Message: fmt.Sprintf("%s returned %d", service, resp.StatusCode)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &ItemWarning{Source: name, Code: problem.CodeUpstreamDown, Message: "Invalid response from " + service}
+	}
+	return nil
+}
+
+// get sends a GET for path to the named upstream on behalf of r, with the
+// caller's credentials, request ID and trace context.
+func (h *Handler) get(r *http.Request, name, path string) (*http.Response, error) {
+	ctx := r.Context()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(h.cfg.Upstreams()[name], "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	req.Header.Set("X-Request-Id", chimiddleware.GetReqID(ctx))
+	setUser(req)
+	return h.do(name, req)
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"go.uber.org/zap"
+)
+
+// storefrontCart is the cart the fake cart service holds for every user.
+const storefrontCart = `{"user_id":"u1","items":[` +
+	`{"product_id":"p1","product_name":"Mug","quantity":2,"price":9.5,"image_url":"https://example.com/mug-old.jpg"},` +
+	`{"product_id":"p2","product_name":"Tea","quantity":1,"price":4,"image_url":"https://example.com/tea.jpg"}]}`
+
+// serveJSON answers every request with body.
+func serveJSON(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+// catalog answers like the product and inventory services for p1 and p2.
+var (
+	catalogProducts = serveJSON(`{"id":"p1","name":"Mug","price":10,"image_url":"https://example.com/mug.jpg"}`)
+	catalogStock    = serveJSON(`{"item":{"product_id":"p1","available":5,"status":"in_stock"}}`)
+)
+
+// newStorefront serves the storefront endpoints in v1 and v2 from a cart
+// service holding storefrontCart and the given product and inventory
+// services.
+func newStorefront(t *testing.T, product, inventory http.Handler, fanoutTimeout time.Duration) http.Handler {
+	t.Helper()
+	servers := make(map[string]string)
+	for name, handler := range map[string]http.Handler{"cart": serveJSON(storefrontCart), "product": product, "inventory": inventory} {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		servers[name] = srv.URL
+	}
+	cfg := &config.Config{
+		CartServiceURL:          servers["cart"],
+		ProductServiceURL:       servers["product"],
+		InventoryServiceURL:     servers["inventory"],
+		StorefrontFanoutTimeout: fanoutTimeout,
+		APIVersions:             map[string]config.APIVersion{"v1": {}, "v2": {}},
+		APIVersionOrder:         []string{"v1", "v2"},
+		UnversionedAPI:          "v1",
+		UpstreamClients: map[string]config.UpstreamClientConfig{
+			"cart":      testClientConfig(),
+			"product":   testClientConfig(),
+			"inventory": testClientConfig(),
+		},
+	}
+	h := NewHandler(cfg, zap.NewNop().Sugar())
+	r := chi.NewRouter()
+	h.RegisterStorefront(r, middleware.RouteAuth(testAuth), middleware.RouteTransform())
+	return r
+}
+
+// getCartView fetches path for u1 and decodes the items of the cart view
+// loosely, to see the fields as sent.
+func getCartView(t *testing.T, h http.Handler, path string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer u1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var view map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("got %d %q: %v", rec.Code, rec.Body.String(), err)
+	}
+	return rec.Code, view
+}
+
+func TestStorefrontCartVersions(t *testing.T) {
+	h := newStorefront(t, catalogProducts, catalogStock, time.Second)
+	tests := []struct {
+		path  string
+		image string
+	}{
+		{"/api/storefront/cart", "image_url"},
+		{"/api/v1/storefront/cart", "image_url"},
+		{"/api/v2/storefront/cart", "image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			status, view := getCartView(t, h, tt.path)
+			if status != http.StatusOK {
+				t.Fatalf("got status %d", status)
+			}
+			for _, item := range view["items"].([]interface{}) {
+				item := item.(map[string]interface{})
+				for _, field := range []string{"image", "image_url"} {
+					if _, ok := item[field]; ok != (field == tt.image) {
+						t.Errorf("item %v: got %s present %v", item["product_id"], field, ok)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStorefrontCartDegraded(t *testing.T) {
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	// hanging answers only once the gateway gives up on the request
+	hanging := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	tests := []struct {
+		name               string
+		product, inventory http.Handler
+		// warning is the source and code every item is warned with
+		warning []string
+	}{
+		{"all up", catalogProducts, catalogStock, nil},
+		{"product fails", failing, catalogStock, []string{"product", problem.CodeUpstreamDown}},
+		{"product times out", hanging, catalogStock, []string{"product", problem.CodeUpstreamTimeout}},
+		{"inventory fails", catalogProducts, failing, []string{"inventory", problem.CodeUpstreamDown}},
+		{"inventory times out", catalogProducts, hanging, []string{"inventory", problem.CodeUpstreamTimeout}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStorefront(t, tt.product, tt.inventory, 50*time.Millisecond)
+			status, view := getCartView(t, h, "/api/storefront/cart")
+			if status != http.StatusOK {
+				t.Fatalf("got status %d, want the cart despite the failure", status)
+			}
+			if degraded := view["degraded"].(bool); degraded != (tt.warning != nil) {
+				t.Errorf("got degraded %v", degraded)
+			}
+
+			items := view["items"].([]interface{})
+			if len(items) != 2 {
+				t.Fatalf("got %d items, want both from the cart", len(items))
+			}
+			for _, item := range items {
+				item := item.(map[string]interface{})
+				warnings, _ := item["warnings"].([]interface{})
+				if tt.warning == nil {
+					if len(warnings) != 0 {
+						t.Errorf("item %v: got warnings %v", item["product_id"], warnings)
+					}
+					continue
+				}
+				if len(warnings) != 1 {
+					t.Fatalf("item %v: got warnings %v, want one", item["product_id"], warnings)
+				}
+				warning := warnings[0].(map[string]interface{})
+				if warning["source"] != tt.warning[0] || warning["code"] != tt.warning[1] {
+					t.Errorf("item %v: got warning %v, want %v", item["product_id"], warning, tt.warning)
+				}
+
+				switch tt.warning[0] {
+				case "product":
+					// The item keeps what the cart recorded
+					if item["name"] == "" || item["price"] != item["cart_price"] || item["price_changed"] != false {
+						t.Errorf("item %v: got %v, want the cart's name and price", item["product_id"], item)
+					}
+					if item["availability"] == nil {
+						t.Errorf("item %v: lost its availability", item["product_id"])
+					}
+				case "inventory":
+					if item["availability"] != nil {
+						t.Errorf("item %v: got availability %v, want none", item["product_id"], item["availability"])
+					}
+				}
+			}
+		})
+	}
+}
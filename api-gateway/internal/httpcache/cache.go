@@ -0,0 +1,139 @@
+// Package httpcache stores upstream responses for the cacheable routes of
+// the route table. Entries live in a bounded in-memory LRU and, when a
+// Shared tier is configured, in Redis so every gateway instance sees them.
+//
+// Invalidation is tag based: every entry records the version of each of
+// its tags when it was stored, and bumping a tag's version makes all
+// entries stored under an older version stale at once. With a Shared tier
+// the versions live there too, so an invalidation by one instance applies
+// to the local entries of all of them.
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"go.uber.org/zap"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	ETag     string      `json:"etag"`
+	Expires  time.Time   `json:"expires"`
+	Versions []uint64    `json:"versions"`
+}
+
+// Fresh reports whether e has not expired and none of its tags has been
+// invalidated since it was stored.
+func (e *Entry) Fresh(now time.Time, versions []uint64) bool {
+	return now.Before(e.Expires) && slices.Equal(e.Versions, versions)
+}
+
+// Shared is a cache tier shared between gateway instances.
+type Shared interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	Versions(ctx context.Context, tags []string) ([]uint64, error)
+	Invalidate(ctx context.Context, tags []string) error
+}
+
+// Cache is the two-tier response cache.
+type Cache struct {
+	local  *lru.Cache[string, *Entry]
+	shared Shared
+	logger *zap.SugaredLogger
+
+	// versions holds the tag versions when there is no shared tier
+	mu       sync.Mutex
+	versions map[string]uint64
+}
+
+// New creates a cache holding at most maxEntries responses in memory.
+// shared may be nil.
+func New(maxEntries int, shared Shared, logger *zap.SugaredLogger) (*Cache, error) {
+	local, err := lru.New[string, *Entry](maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		local:    local,
+		shared:   shared,
+		logger:   logger,
+		versions: make(map[string]uint64),
+	}, nil
+}
+
+// Lookup returns the fresh entry stored under key, if any, along with the
+// current versions of tags to store a new entry with. ok is false when the
+// versions are unknown because the shared tier failed; the response must
+// then neither be served from nor stored in the cache.
+func (c *Cache) Lookup(ctx context.Context, key string, tags []string) (entry *Entry, versions []uint64, ok bool) {
+	versions, err := c.tagVersions(ctx, tags)
+	if err != nil {
+		c.logger.Warnw("Failed to read cache tag versions", "error", err)
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	if e, found := c.local.Get(key); found {
+		if e.Fresh(now, versions) {
+			return e, versions, true
+		}
+		c.local.Remove(key)
+	}
+
+	if c.shared != nil {
+		e, err := c.shared.Get(ctx, key)
+		if err != nil {
+			c.logger.Warnw("Failed to read shared cache", "key", key, "error", err)
+		} else if e != nil && e.Fresh(now, versions) {
+			c.local.Add(key, e)
+			return e, versions, true
+		}
+	}
+	return nil, versions, true
+}
+
+// Store caches entry under key until entry.Expires.
+func (c *Cache) Store(ctx context.Context, key string, entry *Entry) {
+	c.local.Add(key, entry)
+	if c.shared != nil {
+		if err := c.shared.Set(ctx, key, entry, time.Until(entry.Expires)); err != nil {
+			c.logger.Warnw("Failed to write shared cache", "key", key, "error", err)
+		}
+	}
+}
+
+// Invalidate makes every entry tagged with one of tags stale.
+func (c *Cache) Invalidate(ctx context.Context, tags []string) error {
+	if c.shared != nil {
+		return c.shared.Invalidate(ctx, tags)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		c.versions[tag]++
+	}
+	return nil
+}
+
+func (c *Cache) tagVersions(ctx context.Context, tags []string) ([]uint64, error) {
+	if c.shared != nil {
+		return c.shared.Versions(ctx, tags)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := make([]uint64, len(tags))
+	for i, tag := range tags {
+		versions[i] = c.versions[tag]
+	}
+	return versions, nil
+}
@@ -0,0 +1,181 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryShared is a Shared tier in memory, standing in for Redis.
+type memoryShared struct {
+	mu       sync.Mutex
+	entries  map[string]*Entry
+	versions map[string]uint64
+	err      error
+}
+
+func newMemoryShared() *memoryShared {
+	return &memoryShared{entries: make(map[string]*Entry), versions: make(map[string]uint64)}
+}
+
+func (s *memoryShared) Get(_ context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key], s.err
+}
+
+func (s *memoryShared) Set(_ context.Context, key string, entry *Entry, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return s.err
+}
+
+func (s *memoryShared) Versions(_ context.Context, tags []string) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := make([]uint64, len(tags))
+	for i, tag := range tags {
+		versions[i] = s.versions[tag]
+	}
+	return versions, s.err
+}
+
+func (s *memoryShared) Invalidate(_ context.Context, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		s.versions[tag]++
+	}
+	return s.err
+}
+
+func newTestCache(t *testing.T, shared Shared) *Cache {
+	t.Helper()
+	cache, err := New(16, shared, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// store caches a response to key under the current versions of tags.
+func store(t *testing.T, cache *Cache, key string, tags []string, ttl time.Duration) {
+	t.Helper()
+	_, versions, ok := cache.Lookup(context.Background(), key, tags)
+	if !ok {
+		t.Fatalf("%s: lookup failed", key)
+	}
+	cache.Store(context.Background(), key, &Entry{
+		Body:     []byte(key),
+		ETag:     `"` + key + `"`,
+		Expires:  time.Now().Add(ttl),
+		Versions: versions,
+	})
+}
+
+func cached(t *testing.T, cache *Cache, key string, tags []string) bool {
+	t.Helper()
+	entry, _, ok := cache.Lookup(context.Background(), key, tags)
+	if !ok {
+		t.Fatalf("%s: lookup failed", key)
+	}
+	return entry != nil
+}
+
+func TestInvalidateStalesTaggedEntries(t *testing.T) {
+	entries := map[string][]string{
+		"GET /api/products":   {"products"},
+		"GET /api/products/1": {"product:1"},
+		"GET /api/products/2": {"product:2"},
+	}
+	tests := []struct {
+		name        string
+		invalidate  []string
+		stillCached []string
+	}{
+		{"nothing", nil, []string{"GET /api/products", "GET /api/products/1", "GET /api/products/2"}},
+		{"one product", []string{"products", "product:1"}, []string{"GET /api/products/2"}},
+		{"unknown tag", []string{"orders"}, []string{"GET /api/products", "GET /api/products/1", "GET /api/products/2"}},
+	}
+	for _, shared := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if shared {
+				name += " shared"
+			}
+			t.Run(name, func(t *testing.T) {
+				var tier Shared
+				if shared {
+					tier = newMemoryShared()
+				}
+				cache := newTestCache(t, tier)
+				for key, tags := range entries {
+					store(t, cache, key, tags, time.Minute)
+				}
+				if err := cache.Invalidate(context.Background(), tt.invalidate); err != nil {
+					t.Fatal(err)
+				}
+
+				for key, tags := range entries {
+					want := false
+					for _, k := range tt.stillCached {
+						want = want || k == key
+					}
+					if got := cached(t, cache, key, tags); got != want {
+						t.Errorf("%s: got cached %v, want %v", key, got, want)
+					}
+				}
+
+				// A response stored after the invalidation is served again
+				store(t, cache, "GET /api/products", entries["GET /api/products"], time.Minute)
+				if !cached(t, cache, "GET /api/products", entries["GET /api/products"]) {
+					t.Error("entry stored after the invalidation is not served")
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidationReachesOtherInstances(t *testing.T) {
+	shared := newMemoryShared()
+	a, b := newTestCache(t, shared), newTestCache(t, shared)
+	tags := []string{"product:1"}
+	store(t, a, "GET /api/products/1", tags, time.Minute)
+	if !cached(t, b, "GET /api/products/1", tags) {
+		t.Fatal("entry stored by one instance is not seen by the other")
+	}
+
+	if err := a.Invalidate(context.Background(), tags); err != nil {
+		t.Fatal(err)
+	}
+	// b holds the entry in its local tier too
+	if cached(t, b, "GET /api/products/1", tags) {
+		t.Error("entry invalidated by one instance is served by the other")
+	}
+}
+
+func TestExpiredEntriesAreNotServed(t *testing.T) {
+	cache := newTestCache(t, nil)
+	store(t, cache, "GET /api/products", nil, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if cached(t, cache, "GET /api/products", nil) {
+		t.Error("expired entry served")
+	}
+}
+
+func TestLookupFailsWithoutTagVersions(t *testing.T) {
+	shared := newMemoryShared()
+	cache := newTestCache(t, shared)
+	store(t, cache, "GET /api/products", []string{"products"}, time.Minute)
+
+	shared.err = errors.New("connection refused")
+	entry, _, ok := cache.Lookup(context.Background(), "GET /api/products", []string{"products"})
+	if ok || entry != nil {
+		t.Errorf("got entry %v and ok %v, want neither when versions are unknown", entry, ok)
+	}
+}
@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	entryKeyPrefix = "httpcache:entry:"
+	tagKeyPrefix   = "httpcache:tag:"
+)
+
+// Redis is the shared cache tier.
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := r.client.Get(ctx, entryKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, entryKeyPrefix+key, data, ttl).Err()
+}
+
+// Versions reads the tag versions; a tag that was never invalidated is at
+// version 0.
+func (r *Redis) Versions(ctx context.Context, tags []string) ([]uint64, error) {
+	versions := make([]uint64, len(tags))
+	if len(tags) == 0 {
+		return versions, nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKeyPrefix + tag
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if versions[i], err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func (r *Redis) Invalidate(ctx context.Context, tags []string) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			pipe.Incr(ctx, tagKeyPrefix+tag)
+		}
+		return nil
+	})
+	return err
+}
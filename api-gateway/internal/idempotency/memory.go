@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired records are dropped from memory.
+const sweepInterval = time.Minute
+
+type memoryRecord struct {
+	record  Record
+	expires time.Time
+}
+
+// Memory is a Store local to this gateway instance.
+type Memory struct {
+	mu        sync.Mutex
+	records   map[string]*memoryRecord
+	nextSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		records:   make(map[string]*memoryRecord),
+		nextSweep: time.Now().Add(sweepInterval),
+	}
+}
+
+func (m *Memory) Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*Record, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.After(m.nextSweep) {
+		for k, r := range m.records {
+			if now.After(r.expires) {
+				delete(m.records, k)
+			}
+		}
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	if r, ok := m.records[key]; ok && now.Before(r.expires) {
+		record := r.record
+		record.Token = ""
+		return &record, "", nil
+	}
+	m.records[key] = &memoryRecord{
+		record:  Record{Fingerprint: fingerprint, Token: token},
+		expires: now.Add(lockTTL),
+	}
+	return nil, token, nil
+}
+
+func (m *Memory) Complete(ctx context.Context, key, token string, record *Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.claimed(key, token) {
+		return ErrClaimLost
+	}
+	m.records[key] = &memoryRecord{record: *record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Release(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.claimed(key, token) {
+		delete(m.records, key)
+	}
+	return nil
+}
+
+// claimed reports whether key is still held by the claim with token.
+func (m *Memory) claimed(key, token string) bool {
+	r, ok := m.records[key]
+	return ok && time.Now().Before(r.expires) && r.record.Token == token
+}
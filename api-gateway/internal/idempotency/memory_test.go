@@ -0,0 +1,86 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryClaim(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	record, token, err := m.Claim(ctx, "u1:k", "fp", time.Minute)
+	if err != nil || record != nil || token == "" {
+		t.Fatalf("first claim got %+v, %q, %v; want the key claimed", record, token, err)
+	}
+	// Claimed but not completed: the first request is in flight
+	record, other, err := m.Claim(ctx, "u1:k", "other", time.Minute)
+	if err != nil || record == nil || record.Done || record.Fingerprint != "fp" || other != "" {
+		t.Fatalf("second claim got %+v, %q, %v; want the in-flight record of fp", record, other, err)
+	}
+	if record.Token != "" {
+		t.Errorf("the token of the claim was handed out to a repeat")
+	}
+
+	done := &Record{Fingerprint: "fp", Done: true, Status: http.StatusCreated, Body: []byte(`{"id":"o1"}`)}
+	if err := m.Complete(ctx, "u1:k", token, done, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	record, _, err = m.Claim(ctx, "u1:k", "fp", time.Minute)
+	if err != nil || record == nil || !record.Done || record.Status != http.StatusCreated || string(record.Body) != `{"id":"o1"}` {
+		t.Fatalf("claim after completion got %+v, %v; want the stored response", record, err)
+	}
+
+	// Keys of other users are separate
+	if record, _, err := m.Claim(ctx, "u2:k", "fp", time.Minute); err != nil || record != nil {
+		t.Fatalf("other key got %+v, %v", record, err)
+	}
+}
+
+func TestMemoryReleaseAndExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, token, _ := m.Claim(ctx, "released", "fp", time.Minute)
+	if err := m.Release(ctx, "released", token); err != nil {
+		t.Fatal(err)
+	}
+	if record, _, _ := m.Claim(ctx, "released", "fp", time.Minute); record != nil {
+		t.Errorf("released key still held: %+v", record)
+	}
+
+	m.Claim(ctx, "expired", "fp", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if record, _, _ := m.Claim(ctx, "expired", "fp", time.Minute); record != nil {
+		t.Errorf("expired claim still held: %+v", record)
+	}
+}
+
+func TestMemoryLapsedClaim(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, stale, _ := m.Claim(ctx, "k", "fp", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	// The lock timed out and a retry took the key over
+	_, current, _ := m.Claim(ctx, "k", "fp", time.Minute)
+
+	done := &Record{Fingerprint: "fp", Done: true, Status: http.StatusCreated}
+	if err := m.Complete(ctx, "k", stale, done, time.Minute); !errors.Is(err, ErrClaimLost) {
+		t.Errorf("completing a lapsed claim got %v, want ErrClaimLost", err)
+	}
+	if err := m.Release(ctx, "k", stale); err != nil {
+		t.Fatal(err)
+	}
+	record, _, _ := m.Claim(ctx, "k", "fp", time.Minute)
+	if record == nil || record.Done {
+		t.Fatalf("got %+v, want the retry still holding the key", record)
+	}
+
+	if err := m.Complete(ctx, "k", current, done, time.Minute); err != nil {
+		t.Errorf("completing the current claim: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "idempotency:"
+
+var (
+	// completeScript replaces the record claimed with token ARGV[1] by the
+	// finished record ARGV[2], kept for ARGV[3] milliseconds
+	completeScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current or cjson.decode(current).token ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+	// releaseScript deletes the record claimed with token ARGV[1]
+	releaseScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current or cjson.decode(current).token ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])
+`)
+)
+
+// Redis is a Store shared by every gateway replica.
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*Record, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := json.Marshal(Record{Fingerprint: fingerprint, Token: token})
+	if err != nil {
+		return nil, "", err
+	}
+	claimed, err := r.client.SetNX(ctx, keyPrefix+key, data, lockTTL).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if claimed {
+		return nil, token, nil
+	}
+
+	existing, err := r.client.Get(ctx, keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The record expired in between; claim the key again
+		return r.Claim(ctx, key, fingerprint, lockTTL)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record Record
+	if err := json.Unmarshal(existing, &record); err != nil {
+		return nil, "", err
+	}
+	// The token is only for the request holding the claim
+	record.Token = ""
+	return &record, "", nil
+}
+
+func (r *Redis) Complete(ctx context.Context, key, token string, record *Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	stored, err := completeScript.Run(ctx, r.client, []string{keyPrefix + key}, token, data, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+func (r *Redis) Release(ctx context.Context, key, token string) error {
+	return releaseScript.Run(ctx, r.client, []string{keyPrefix + key}, token).Err()
+}
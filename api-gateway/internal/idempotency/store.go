@@ -0,0 +1,65 @@
+// Package idempotency remembers the responses to requests sent with an
+// Idempotency-Key, so that a client retrying a request it never got the
+// answer to is sent the first response again instead of, say, creating a
+// second order.
+//
+// A key is claimed for the duration of the first request. The record then
+// holds a fingerprint of that request, and once it has completed, its
+// response. Records live in memory or, when Redis is configured, in Redis
+// so that a retry reaching another gateway instance is recognised too.
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrClaimLost is returned by Complete when the claim on a key lapsed and
+// the key was released or claimed again in the meantime.
+var ErrClaimLost = errors.New("idempotency: claim on key lost")
+
+// Record is what is known about the first request sent with a key.
+type Record struct {
+	// Fingerprint identifies the request, so that reusing the key for a
+	// different request can be told apart from a retry
+	Fingerprint string `json:"fingerprint"`
+	// Token identifies the claim on an unfinished record, so that only
+	// the request holding it completes or releases the key
+	Token string `json:"token,omitempty"`
+	// Done is set once the response below has been stored; until then the
+	// first request is still being processed
+	Done   bool        `json:"done"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Store keeps the records of idempotency keys.
+type Store interface {
+	// Claim records that the request with fingerprint is being processed
+	// under key and returns the token of the claim. If key is already in
+	// use it returns the existing record instead and claims nothing. An
+	// unfinished claim lapses after lockTTL, in case the instance
+	// processing it went away.
+	Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (record *Record, token string, err error)
+	// Complete stores the finished record of the key claimed with token
+	// for ttl. It returns ErrClaimLost, storing nothing, if the claim is
+	// no longer held.
+	Complete(ctx context.Context, key, token string, record *Record, ttl time.Duration) error
+	// Release forgets the key claimed with token, so that the request can
+	// be retried. A claim no longer held is left alone.
+	Release(ctx context.Context, key, token string) error
+}
+
+// newToken returns a random claim token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
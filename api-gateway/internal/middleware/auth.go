@@ -6,56 +6,116 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/accesslog"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/revocation"
+	"go.uber.org/zap"
 )
 
 type UserClaims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// Scope is a space-separated list of scopes (RFC 8693)
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type contextKey string
 
 const UserKey contextKey = "user"
-func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
+
+// AuthConfig configures how AuthMiddleware verifies access tokens.
+type AuthConfig struct {
+	// Keyfunc returns the key that verifies a token, usually JWKS.Keyfunc
+	Keyfunc jwt.Keyfunc
+	// Algorithms is the allowlist of signing algorithms
+	Algorithms []string
+	// Issuer and Audience are checked against iss and aud when set
+	Issuer   string
+	Audience string
+	// Revocations, when set, rejects tokens revoked through the identity
+	// service. A failed check is logged to Logger and lets the token through.
+	Revocations revocation.Checker
+	Logger      *zap.SugaredLogger
+}
+
+// HS256Keyfunc verifies tokens with a shared secret. It is meant for
+// development only; production tokens are verified against the JWKS.
+func HS256Keyfunc(secret string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}
+}
+
+func AuthMiddleware(cfg AuthConfig) func(http.Handler) http.Handler {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	parser := jwt.NewParser(opts...)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				unauthorized(w, r, "Authorization header required")
 				return
 			}
 
 			headerParts := strings.Split(authHeader, " ")
 			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-				http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+				unauthorized(w, r, "Invalid Authorization header format")
 				return
 			}
 
 			tokenString := headerParts[1]
 
-			token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(jwtSecret), nil
-			})
+			token, err := parser.ParseWithClaims(tokenString, &UserClaims{}, cfg.Keyfunc)
 
 			if err != nil {
-				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				unauthorized(w, r, "Invalid token: "+err.Error())
 				return
 			}
 
 			if !token.Valid {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				unauthorized(w, r, "Invalid token")
 				return
 			}
 			claims, ok := token.Claims.(*UserClaims)
 			if !ok {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+				unauthorized(w, r, "Invalid token claims")
 				return
 			}
 
+			if cfg.Revocations != nil {
+				tok := revocation.Token{ID: claims.ID, UserID: claims.UserID}
+				if claims.IssuedAt != nil {
+					tok.IssuedAt = claims.IssuedAt.Time
+				}
+				revoked, err := cfg.Revocations.Revoked(r.Context(), tok)
+				if err != nil {
+					cfg.Logger.Errorw("Token revocation check failed", "user_id", claims.UserID, "error", err)
+				} else if revoked {
+					unauthorized(w, r, "Token has been revoked")
+					return
+				}
+			}
+
+			accesslog.SetUser(r.Context(), claims.UserID)
 			ctx := context.WithValue(r.Context(), UserKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+func unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, detail)
+}
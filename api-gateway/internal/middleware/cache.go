@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/httpcache"
+	"go.uber.org/zap"
+)
+
+// cachedHeaders are the upstream response headers stored with an entry.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Last-Modified"}
+
+// tagParamPattern matches the {name} URL parameters in a cache tag.
+var tagParamPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// RouteCache caches the responses of routes with a cache section and
+// invalidates the tags listed by routes with invalidates.
+func RouteCache(cache *httpcache.Cache, maxEntryBytes int64, logger *zap.SugaredLogger) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		switch {
+		case route.Cache != nil:
+			return CacheMiddleware(cache, *route.Cache, maxEntryBytes)
+		case len(route.Invalidates) > 0:
+			return InvalidateMiddleware(cache, route.Invalidates, logger)
+		}
+		return nil
+	}
+}
+
+// CacheMiddleware serves 200 responses from cache with a strong ETag,
+// answering a matching If-None-Match with 304. Responses larger than
+// maxEntryBytes, marked private or no-store, or setting cookies are not
+// cached. Requests other than GET and requests carrying credentials
+// bypass the cache, as their responses may differ per caller. X-Cache
+// tells whether the response was a HIT, a MISS or a BYPASS.
+func CacheMiddleware(cache *httpcache.Cache, cfg config.RouteCache, maxEntryBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || authenticated(r) {
+				w.Header().Set("X-Cache", "BYPASS")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tags := expandTags(cfg.Tags, r)
+			key := r.Method + " " + r.URL.Path
+			if query := r.URL.Query(); len(query) > 0 {
+				key += "?" + query.Encode()
+			}
+
+			entry, versions, ok := cache.Lookup(r.Context(), key, tags)
+			if !ok {
+				w.Header().Set("X-Cache", "BYPASS")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if entry != nil && !noCache(r) {
+				w.Header().Set("X-Cache", "HIT")
+				writeEntry(w, r, entry, cfg.MaxAge)
+				return
+			}
+
+			// Entries are stored decoded; the upstream transport negotiates
+			// compression on its own when the client's preference is dropped.
+			r.Header.Del("Accept-Encoding")
+			w.Header().Set("X-Cache", "MISS")
+
+			cw := &captureWriter{w: w, limit: maxEntryBytes}
+			next.ServeHTTP(cw, r)
+			if cw.passthrough {
+				return
+			}
+			if cw.status != http.StatusOK || !cacheable(w.Header()) {
+				cw.flush()
+				return
+			}
+
+			body := bytes.Clone(cw.buf.Bytes())
+			sum := sha256.Sum256(body)
+			entry = &httpcache.Entry{
+				Header:   make(http.Header),
+				Body:     body,
+				ETag:     `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`,
+				Expires:  time.Now().Add(cfg.TTL),
+				Versions: versions,
+			}
+			for _, name := range cachedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					entry.Header[name] = slices.Clone(values)
+				}
+			}
+			cache.Store(r.Context(), key, entry)
+			writeEntry(w, r, entry, cfg.MaxAge)
+		})
+	}
+}
+
+// InvalidateMiddleware invalidates tags once the request has succeeded,
+// before the response is sent, so the caller's next read sees the change.
+func InvalidateMiddleware(cache *httpcache.Cache, tags []string, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			iw := &invalidatingWriter{ResponseWriter: w, onStatus: func(status int) {
+				if status < 200 || status >= 300 {
+					return
+				}
+				expanded := expandTags(tags, r)
+				if err := cache.Invalidate(r.Context(), expanded); err != nil {
+					logger.Errorw("Failed to invalidate cached responses", "tags", expanded, "error", err)
+				}
+			}}
+			next.ServeHTTP(iw, r)
+		})
+	}
+}
+
+func writeEntry(w http.ResponseWriter, r *http.Request, entry *httpcache.Entry, maxAge time.Duration) {
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = slices.Clone(values)
+	}
+	header.Set("ETag", entry.ETag)
+	if maxAge > 0 {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	} else {
+		header.Set("Cache-Control", "public, no-cache")
+	}
+
+	if etagMatches(r.Header.Get("If-None-Match"), entry.ETag) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.Body)
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func cacheable(header http.Header) bool {
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		switch strings.TrimSpace(directive) {
+		case "no-store", "private":
+			return false
+		}
+	}
+	return true
+}
+
+// authenticated reports whether r carries a token or was authenticated by
+// an earlier middleware.
+func authenticated(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Context().Value(UserKey) != nil
+}
+
+func noCache(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") ||
+		r.Header.Get("Pragma") == "no-cache"
+}
+
+func expandTags(tags []string, r *http.Request) []string {
+	expanded := make([]string, len(tags))
+	for i, tag := range tags {
+		expanded[i] = tagParamPattern.ReplaceAllStringFunc(tag, func(m string) string {
+			return chi.URLParam(r, m[1:len(m)-1])
+		})
+	}
+	return expanded
+}
+
+// captureWriter buffers a response so it can be cached. Anything other
+// than a 200, or a body over limit, is passed straight through instead.
+type captureWriter struct {
+	w           http.ResponseWriter
+	limit       int64
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (c *captureWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = status
+	if status != http.StatusOK {
+		c.passthrough = true
+		c.w.WriteHeader(status)
+	}
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.passthrough {
+		return c.w.Write(p)
+	}
+	if int64(c.buf.Len()+len(p)) > c.limit {
+		c.passthrough = true
+		c.w.WriteHeader(c.status)
+		if _, err := c.w.Write(c.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		c.buf.Reset()
+		return c.w.Write(p)
+	}
+	return c.buf.Write(p)
+}
+
+// flush sends a buffered response that is not being cached.
+func (c *captureWriter) flush() {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.w.WriteHeader(c.status)
+	c.w.Write(c.buf.Bytes())
+}
+
+// invalidatingWriter calls onStatus with the response status before the
+// header is written.
+type invalidatingWriter struct {
+	http.ResponseWriter
+	onStatus    func(status int)
+	wroteHeader bool
+}
+
+func (w *invalidatingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.onStatus(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *invalidatingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/httpcache"
+	"go.uber.org/zap"
+)
+
+// chain applies the route middleware to h the way the router does: the
+// first one is the outermost.
+func chain(route config.Route, h http.Handler, mws ...RouteMiddleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mw := mws[i](route); mw != nil {
+			h = mw(h)
+		}
+	}
+	return h
+}
+
+// catalog serves the product routes of routes.yaml from a counter that
+// writes bump, so every response tells which version it saw.
+type catalog struct {
+	reads   atomic.Int32
+	version atomic.Int32
+	handler http.Handler
+}
+
+func newCatalog(t *testing.T) *catalog {
+	t.Helper()
+	cache, err := httpcache.New(16, nil, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &catalog{}
+	read := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.reads.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":%q,"version":%d}`, r.URL.Path, c.version.Load())
+	})
+	write := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.version.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	routes := []struct {
+		route   config.Route
+		handler http.Handler
+	}{
+		{config.Route{Method: http.MethodGet, Path: "/api/products", Cache: &config.RouteCache{TTL: time.Minute, Tags: []string{"products"}}}, read},
+		{config.Route{Method: http.MethodGet, Path: "/api/products/{id}", Cache: &config.RouteCache{TTL: time.Minute, MaxAge: 10 * time.Second, Tags: []string{"product:{id}"}}}, read},
+		{config.Route{Method: http.MethodPut, Path: "/api/products/{id}", Auth: true, Invalidates: []string{"products", "product:{id}"}}, write},
+	}
+	r := chi.NewRouter()
+	for _, route := range routes {
+		handler := chain(route.route, route.handler, RouteCache(cache, 1<<20, zap.NewNop().Sugar()))
+		r.Method(route.route.Method, route.route.Path, handler)
+		if route.route.Method == http.MethodGet {
+			// The cache is only attached to GET routes, but HEAD must pass too
+			r.Method(http.MethodHead, route.route.Path, handler)
+		}
+	}
+	c.handler = r
+	return c
+}
+
+func (c *catalog) do(method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCacheETag(t *testing.T) {
+	c := newCatalog(t)
+	first := c.do(http.MethodGet, "/api/products/1", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("got %d %s with ETag %q, want a 200 MISS with an ETag", first.Code, first.Header().Get("X-Cache"), etag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "public, max-age=10" {
+		t.Errorf("got Cache-Control %q", got)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"no validator", "", http.StatusOK},
+		{"matching", etag, http.StatusNotModified},
+		{"weak", "W/" + etag, http.StatusNotModified},
+		{"in a list", `"other", ` + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"stale", `"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.ifNoneMatch != "" {
+				header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := c.do(http.MethodGet, "/api/products/1", header)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Header().Get("X-Cache") != "HIT" || rec.Header().Get("ETag") != etag {
+				t.Errorf("got %s with ETag %q, want a HIT with %q", rec.Header().Get("X-Cache"), rec.Header().Get("ETag"), etag)
+			}
+			switch tt.status {
+			case http.StatusNotModified:
+				if rec.Body.Len() != 0 {
+					t.Errorf("304 with body %q", rec.Body.String())
+				}
+			case http.StatusOK:
+				if rec.Body.String() != first.Body.String() {
+					t.Errorf("got body %q, want %q", rec.Body.String(), first.Body.String())
+				}
+			}
+		})
+	}
+	if got := c.reads.Load(); got != 1 {
+		t.Errorf("upstream read %d times, want 1", got)
+	}
+}
+
+func TestCacheInvalidatedByWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		// write is the path of the PUT
+		write string
+		// stale are the paths read again from the upstream after it
+		stale []string
+	}{
+		{"product", "/api/products/1", []string{"/api/products", "/api/products/1"}},
+		{"other product", "/api/products/2", []string{"/api/products"}},
+		{"failed write", "/api/products/1?fail=1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCatalog(t)
+			paths := []string{"/api/products", "/api/products/1"}
+			for _, path := range paths {
+				c.do(http.MethodGet, path, nil)
+			}
+			c.do(http.MethodPut, tt.write, nil)
+
+			for _, path := range paths {
+				stale := false
+				for _, p := range tt.stale {
+					stale = stale || p == path
+				}
+				want, version := "HIT", "0"
+				if stale {
+					want, version = "MISS", "1"
+				}
+				rec := c.do(http.MethodGet, path, nil)
+				if got := rec.Header().Get("X-Cache"); got != want {
+					t.Errorf("%s: got %s, want %s", path, got, want)
+				}
+				if wantBody := fmt.Sprintf(`{"path":%q,"version":%s}`, path, version); rec.Body.String() != wantBody {
+					t.Errorf("%s: got body %s, want %s", path, rec.Body.String(), wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		claims *UserClaims
+	}{
+		{"token", http.MethodGet, http.Header{"Authorization": {"Bearer token"}}, nil},
+		{"authenticated", http.MethodGet, nil, &UserClaims{UserID: "u1"}},
+		{"HEAD", http.MethodHead, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCatalog(t)
+			// Cached by an anonymous GET first
+			c.do(http.MethodGet, "/api/products", nil)
+
+			for range 2 {
+				req := httptest.NewRequest(tt.method, "/api/products", nil)
+				for name, values := range tt.header {
+					req.Header[name] = values
+				}
+				if tt.claims != nil {
+					req = req.WithContext(context.WithValue(req.Context(), UserKey, tt.claims))
+				}
+				rec := httptest.NewRecorder()
+				c.handler.ServeHTTP(rec, req)
+				if got := rec.Header().Get("X-Cache"); got != "BYPASS" {
+					t.Errorf("got X-Cache %q, want BYPASS", got)
+				}
+			}
+			if got := c.reads.Load(); got != 3 {
+				t.Errorf("upstream read %d times, want 3", got)
+			}
+			// Nothing the bypassing requests got was stored over the entry
+			if rec := c.do(http.MethodGet, "/api/products", nil); rec.Header().Get("X-Cache") != "HIT" {
+				t.Errorf("got %s after the bypass, want a HIT", rec.Header().Get("X-Cache"))
+			}
+		})
+	}
+}
+
+func TestCacheSkipsPrivateResponses(t *testing.T) {
+	cache, err := httpcache.New(16, nil, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"private", "Cache-Control", "private, max-age=60"},
+		{"no-store", "Cache-Control", "no-store"},
+		{"cookie", "Set-Cookie", "session=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reads int
+			h := CacheMiddleware(cache, config.RouteCache{TTL: time.Minute}, 1<<20)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reads++
+				w.Header().Set(tt.header, tt.value)
+				w.Write([]byte(`{}`))
+			}))
+			for range 2 {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/"+tt.name, nil))
+				if rec.Header().Get("X-Cache") != "MISS" {
+					t.Errorf("got X-Cache %q, want MISS", rec.Header().Get("X-Cache"))
+				}
+			}
+			if reads != 2 {
+				t.Errorf("upstream read %d times, want 2", reads)
+			}
+		})
+	}
+}
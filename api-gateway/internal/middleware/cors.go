@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/cors"
+)
+
+// CORSConfig configures cross-origin requests from browsers.
+type CORSConfig struct {
+	// AllowedOrigins are origin patterns; see NewOriginAllowlist
+	AllowedOrigins   []string
+	AllowCredentials bool
+	AllowedHeaders   []string
+	MaxAge           int
+}
+
+// CORS builds the CORS middleware for cfg. Origins are matched against the
+// allowlist and echoed back only when they match. A "*" entry allows any
+// origin but never with credentials, since reflecting every origin with
+// credentials would let any site make requests as the user.
+func CORS(cfg CORSConfig) (func(http.Handler) http.Handler, error) {
+	allowlist, err := NewOriginAllowlist(cfg.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := cors.Options{
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: cfg.AllowedHeaders,
+		ExposedHeaders: []string{"Link", IdempotentReplayedHeader},
+		MaxAge:         cfg.MaxAge,
+	}
+	if allowlist.any {
+		opts.AllowedOrigins = []string{"*"}
+	} else {
+		opts.AllowOriginFunc = func(r *http.Request, origin string) bool {
+			return allowlist.Allowed(origin)
+		}
+		opts.AllowCredentials = cfg.AllowCredentials
+	}
+	return cors.Handler(opts), nil
+}
+
+// OriginAllowlist matches request origins against a list of patterns.
+type OriginAllowlist struct {
+	any      bool
+	patterns []originPattern
+}
+
+type originPattern struct {
+	scheme string
+	// host is the exact host, or the suffix after the * of a wildcard
+	// subdomain pattern, dot included
+	host     string
+	wildcard bool
+	// port is empty for the scheme's default port or * for any port
+	port string
+}
+
+// NewOriginAllowlist parses origin patterns of the form
+// scheme://host[:port]. The host may start with "*." to match any
+// subdomain, but not the domain itself, and the port may be "*" to match
+// any port. The pattern "*" matches every origin.
+func NewOriginAllowlist(patterns []string) (*OriginAllowlist, error) {
+	allowlist := &OriginAllowlist{}
+	for _, raw := range patterns {
+		if raw == "*" {
+			allowlist.any = true
+			continue
+		}
+
+		scheme, hostport, ok := strings.Cut(strings.ToLower(raw), "://")
+		if !ok || (scheme != "http" && scheme != "https") {
+			return nil, fmt.Errorf("origin %q: scheme must be http or https", raw)
+		}
+		if hostport == "" || strings.ContainsAny(hostport, "/?#@") {
+			return nil, fmt.Errorf("origin %q: must be scheme://host[:port] only", raw)
+		}
+
+		p := originPattern{scheme: scheme, host: hostport}
+		if host, port, ok := strings.Cut(hostport, ":"); ok {
+			if port == "" || (port != "*" && strings.Trim(port, "0123456789") != "") {
+				return nil, fmt.Errorf("origin %q: invalid port", raw)
+			}
+			p.host, p.port = host, port
+		}
+		if rest, ok := strings.CutPrefix(p.host, "*"); ok {
+			if !strings.HasPrefix(rest, ".") || len(rest) < 2 {
+				return nil, fmt.Errorf("origin %q: a wildcard must be a whole leading label, as in *.example.com", raw)
+			}
+			p.host, p.wildcard = rest, true
+		}
+		if p.host == "" || strings.Contains(p.host, "*") {
+			return nil, fmt.Errorf("origin %q: invalid host", raw)
+		}
+		allowlist.patterns = append(allowlist.patterns, p)
+	}
+	return allowlist, nil
+}
+
+// Allowed reports whether origin matches one of the patterns.
+func (a *OriginAllowlist) Allowed(origin string) bool {
+	if a.any {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") {
+		return false
+	}
+	host, port := u.Hostname(), u.Port()
+
+	for _, p := range a.patterns {
+		if p.scheme != u.Scheme {
+			continue
+		}
+		if p.port != "*" && p.port != port {
+			continue
+		}
+		if p.wildcard {
+			label := strings.TrimSuffix(host, p.host)
+			if label != host && label != "" && !strings.HasSuffix(label, ".") {
+				return true
+			}
+			continue
+		}
+		if host == p.host {
+			return true
+		}
+	}
+	return false
+}
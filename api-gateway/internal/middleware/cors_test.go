@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowlist(t *testing.T) {
+	allowlist, err := NewOriginAllowlist([]string{
+		"https://shop.example.com",
+		"https://*.example.org",
+		"http://localhost:*",
+		"http://127.0.0.1:3000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://shop.example.com", true},
+		{"https://SHOP.example.com", true},
+		{"https://shop.example.com/", true},
+		{"http://shop.example.com", false},
+		{"https://shop.example.com:8443", false},
+		{"https://shop.example.com.evil.com", false},
+		{"https://evilshop.example.com", false},
+		{"https://admin.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://localhost:5173", true},
+		{"http://localhost", true},
+		{"https://localhost:5173", false},
+		{"http://127.0.0.1:3000", true},
+		{"http://127.0.0.1:3001", false},
+		{"https://user@shop.example.com", false},
+		{"https://shop.example.com/path", false},
+		{"null", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.Allowed(tt.origin); got != tt.allowed {
+			t.Errorf("%q: got allowed %v, want %v", tt.origin, got, tt.allowed)
+		}
+	}
+}
+
+func TestNewOriginAllowlistRejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"shop.example.com",
+		"ftp://shop.example.com",
+		"https://shop.example.com/app",
+		"https://user@shop.example.com",
+		"https://shop.example.com:",
+		"https://shop.example.com:80a",
+		"https://*example.com",
+		"https://shop.*.example.com",
+		"https://*",
+	} {
+		if _, err := NewOriginAllowlist([]string{pattern}); err == nil {
+			t.Errorf("%q: got no error", pattern)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		preflight   bool
+		// requestHeaders is Access-Control-Request-Headers of a preflight
+		requestHeaders string
+		// allowOrigin is the Access-Control-Allow-Origin wanted, if any
+		allowOrigin  string
+		credentialed bool
+	}{
+		{name: "allowed", origins: []string{"https://shop.example.com"}, credentials: true,
+			origin: "https://shop.example.com", allowOrigin: "https://shop.example.com", credentialed: true},
+		{name: "not allowed", origins: []string{"https://shop.example.com"}, credentials: true,
+			origin: "https://evil.example.com"},
+		{name: "no credentials", origins: []string{"https://shop.example.com"},
+			origin: "https://shop.example.com", allowOrigin: "https://shop.example.com"},
+		{name: "wildcard subdomain", origins: []string{"https://*.example.com"}, credentials: true,
+			origin: "https://admin.example.com", allowOrigin: "https://admin.example.com", credentialed: true},
+		// Reflecting any origin with credentials would let every site act
+		// as the user: * stays * and credentials are dropped
+		{name: "any origin", origins: []string{"*"}, credentials: true,
+			origin: "https://evil.example.com", allowOrigin: "*"},
+		{name: "preflight", origins: []string{"https://shop.example.com"}, credentials: true,
+			origin: "https://shop.example.com", preflight: true, requestHeaders: "authorization, idempotency-key",
+			allowOrigin: "https://shop.example.com", credentialed: true},
+		{name: "preflight not allowed", origins: []string{"https://shop.example.com"}, credentials: true,
+			origin: "https://evil.example.com", preflight: true, requestHeaders: "authorization"},
+		{name: "preflight unknown header", origins: []string{"https://shop.example.com"}, credentials: true,
+			origin: "https://shop.example.com", preflight: true, requestHeaders: "x-internal-user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cors, err := CORS(CORSConfig{
+				AllowedOrigins:   tt.origins,
+				AllowCredentials: tt.credentials,
+				AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", IdempotencyKeyHeader, "X-CSRF-Token"},
+				MaxAge:           600,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reached := false
+			h := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/products", nil)
+			if tt.preflight {
+				req = httptest.NewRequest(http.MethodOptions, "/api/orders", nil)
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", tt.requestHeaders)
+			}
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			header := rec.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentialed {
+				t.Errorf("got Access-Control-Allow-Credentials %q, want credentials %v", header.Get("Access-Control-Allow-Credentials"), tt.credentialed)
+			}
+			if tt.allowOrigin != "" && tt.allowOrigin != "*" && header.Get("Vary") == "" {
+				t.Error("a reflected origin must be sent with Vary")
+			}
+
+			if !tt.preflight {
+				if !reached {
+					t.Error("request not passed on")
+				}
+				return
+			}
+			// Preflights are answered by the gateway, never forwarded
+			if reached {
+				t.Error("preflight passed on")
+			}
+			if rec.Code >= 300 {
+				t.Errorf("got preflight status %d", rec.Code)
+			}
+			if tt.allowOrigin == "" {
+				return
+			}
+			if got := header.Get("Access-Control-Allow-Methods"); got != http.MethodPost {
+				t.Errorf("got Access-Control-Allow-Methods %q", got)
+			}
+			if got := header.Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("got Access-Control-Max-Age %q", got)
+			}
+			if got := header.Get("Access-Control-Allow-Headers"); got == "" {
+				t.Errorf("got no Access-Control-Allow-Headers, want %s requested", tt.requestHeaders)
+			}
+		})
+	}
+}
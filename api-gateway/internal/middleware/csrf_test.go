@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"go.uber.org/zap"
+)
+
+func TestCSRF(t *testing.T) {
+	session := &http.Cookie{Name: "session", Value: "s1"}
+	token := &http.Cookie{Name: "csrf_token", Value: "t1"}
+	tests := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		header  string
+		// bearer authenticates the request with an Authorization header
+		bearer bool
+		status int
+		// issued is whether a new token cookie is set
+		issued bool
+	}{
+		{name: "safe without token", method: http.MethodGet, cookies: []*http.Cookie{session}, status: http.StatusOK, issued: true},
+		{name: "safe with token", method: http.MethodGet, cookies: []*http.Cookie{session, token}, status: http.StatusOK},
+		{name: "head", method: http.MethodHead, cookies: []*http.Cookie{session, token}, status: http.StatusOK},
+		{name: "options", method: http.MethodOptions, cookies: []*http.Cookie{session}, status: http.StatusOK, issued: true},
+		{name: "matching", method: http.MethodPost, cookies: []*http.Cookie{session, token}, header: "t1", status: http.StatusOK},
+		{name: "mismatch", method: http.MethodPost, cookies: []*http.Cookie{session, token}, header: "t2", status: http.StatusForbidden},
+		{name: "missing header", method: http.MethodDelete, cookies: []*http.Cookie{session, token}, status: http.StatusForbidden},
+		{name: "missing cookie", method: http.MethodPut, cookies: []*http.Cookie{session}, header: "t1", status: http.StatusForbidden},
+		{name: "empty header and cookie", method: http.MethodPatch, cookies: []*http.Cookie{session, {Name: "csrf_token", Value: ""}}, status: http.StatusForbidden},
+		// Without a session cookie a browser cannot be tricked into
+		// sending credentials
+		{name: "no session", method: http.MethodPost, status: http.StatusOK},
+		{name: "bearer token only", method: http.MethodPost, bearer: true, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := CSRF(CSRFConfig{
+				CookieName:     "csrf_token",
+				HeaderName:     "X-CSRF-Token",
+				SessionCookies: []string{"session"},
+				Secure:         true,
+				Logger:         zap.NewNop().Sugar(),
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			req := httptest.NewRequest(tt.method, "/api/orders", nil)
+			for _, c := range tt.cookies {
+				req.AddCookie(c)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-CSRF-Token", tt.header)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer abc")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if reached != (tt.status == http.StatusOK) {
+				t.Errorf("got handler reached %v", reached)
+			}
+			if tt.status == http.StatusForbidden {
+				var p problem.Problem
+				if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Code != problem.CodeCSRF {
+					t.Errorf("got %s, want a %s problem", rec.Body.String(), problem.CodeCSRF)
+				}
+			}
+
+			var issued *http.Cookie
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == "csrf_token" {
+					issued = c
+				}
+			}
+			if (issued != nil) != tt.issued {
+				t.Fatalf("got token cookie %v, want issued %v", issued, tt.issued)
+			}
+			if issued != nil {
+				// Scripts must read it, but other sites' requests never carry it
+				if issued.Value == "" || issued.HttpOnly || !issued.Secure || issued.SameSite != http.SameSiteLaxMode || issued.Path != "/" {
+					t.Errorf("got token cookie %+v", issued)
+				}
+			}
+		})
+	}
+}
+
+func TestCSRFTokensAreRandom(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 100 {
+		token := newCSRFToken()
+		if len(token) < 40 || seen[token] {
+			t.Fatalf("got token %q, want a new one of 32 random bytes", token)
+		}
+		seen[token] = true
+	}
+}
@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/idempotency"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"go.uber.org/zap"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client's key for a request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a replayed response
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+)
+
+// storedHeaders are the response headers replayed with a stored response.
+var storedHeaders = []string{"Content-Type", "Content-Language", "Location", "ETag", "Last-Modified", "Cache-Control"}
+
+// IdempotencyConfig tunes the idempotency middleware.
+type IdempotencyConfig struct {
+	// TTL is how long a response is replayed for
+	TTL time.Duration
+	// LockTimeout is how long a request being processed holds its key
+	LockTimeout time.Duration
+	// MaxResponseBytes bounds the responses that are stored
+	MaxResponseBytes int64
+	Logger           *zap.SugaredLogger
+}
+
+// RouteIdempotency applies the idempotency middleware to the routes that
+// enable it. It must run inside the auth middleware, as keys are per
+// user, and after validation, so that rejected bodies are not fingerprinted.
+func RouteIdempotency(store idempotency.Store, cfg IdempotencyConfig) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		if !route.Idempotency {
+			return nil
+		}
+		return IdempotencyMiddleware(store, cfg)
+	}
+}
+
+// IdempotencyMiddleware makes requests sent with an Idempotency-Key safe
+// to retry. The response to the first request with a key is stored and
+// replayed, marked with Idempotent-Replayed, for every repeat from the
+// same user. A repeat with a different method, path or body gets a 422,
+// and one that arrives while the first is still being processed a 409.
+// Server errors are not stored, so such requests can be retried. Requests
+// without the header are not affected, and if the store fails they are
+// let through.
+func IdempotencyMiddleware(store idempotency.Store, cfg IdempotencyConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validIdempotencyKey(key) {
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeIdempotencyKeyInvalid,
+					"Idempotency-Key must be 1 to "+strconv.Itoa(maxIdempotencyKeyLength)+" printable ASCII characters")
+				return
+			}
+			claims, _ := r.Context().Value(UserKey).(*UserClaims)
+			if claims == nil {
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					bodyTooLarge(w, r)
+					return
+				}
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			storeKey := claims.UserID + ":" + key
+			fingerprint := requestFingerprint(r, body)
+			record, token, err := store.Claim(r.Context(), storeKey, fingerprint, cfg.LockTimeout)
+			if err != nil {
+				cfg.Logger.Errorw("Idempotency store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if record != nil {
+				replay(w, r, record, fingerprint)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w, limit: cfg.MaxResponseBytes}
+			completed := false
+			defer func() {
+				if !completed {
+					// Also reached when the handler panics; the claim must
+					// not block retries until it times out
+					release(cfg.Logger, store, storeKey, token)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError || rec.overflow {
+				return
+			}
+			record = &idempotency.Record{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      status,
+				Header:      make(http.Header),
+				Body:        rec.body.Bytes(),
+			}
+			for _, name := range storedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					record.Header[name] = values
+				}
+			}
+			// Stored even if the client went away: its retry is what this is for
+			err = store.Complete(context.WithoutCancel(r.Context()), storeKey, token, record, cfg.TTL)
+			if errors.Is(err, idempotency.ErrClaimLost) {
+				// The request outlasted LockTimeout and the key may have
+				// been claimed again; the newer claim is left alone
+				cfg.Logger.Warnw("Idempotency key lock timed out before the response was stored", "timeout", cfg.LockTimeout)
+				return
+			}
+			if err != nil {
+				cfg.Logger.Errorw("Failed to store idempotent response", "error", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// replay answers a request whose key is already in use.
+func replay(w http.ResponseWriter, r *http.Request, record *idempotency.Record, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		problem.Error(w, r, http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused,
+			"Idempotency-Key was already used for a different request")
+	case !record.Done:
+		w.Header().Set("Retry-After", "1")
+		problem.Error(w, r, http.StatusConflict, problem.CodeIdempotencyKeyInUse,
+			"A request with this Idempotency-Key is still being processed")
+	default:
+		for name, values := range record.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+func release(logger *zap.SugaredLogger, store idempotency.Store, key, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := store.Release(ctx, key, token); err != nil {
+		logger.Errorw("Failed to release idempotency key", "error", err)
+	}
+}
+
+// requestFingerprint hashes what makes a request the same request.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// recordingWriter copies the response into a buffer of up to limit bytes
+// while writing it.
+type recordingWriter struct {
+	http.ResponseWriter
+	limit    int64
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.overflow {
+		if int64(rw.body.Len()+len(p)) > rw.limit {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(p)
+		}
+	}
+	return rw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/idempotency"
+	"go.uber.org/zap"
+)
+
+// idempotencyRequest is a request sent through the middleware.
+type idempotencyRequest struct {
+	user string
+	path string
+	key  string
+	body string
+}
+
+func (ir idempotencyRequest) send(h http.Handler) *httptest.ResponseRecorder {
+	path := ir.path
+	if path == "" {
+		path = "/api/orders"
+	}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(ir.body))
+	req.Header.Set("Content-Type", "application/json")
+	if ir.key != "" {
+		req.Header.Set(IdempotencyKeyHeader, ir.key)
+	}
+	user := ir.user
+	if user == "" {
+		user = "u1"
+	}
+	req = req.WithContext(context.WithValue(req.Context(), UserKey, &UserClaims{UserID: user}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func newIdempotencyHandler(next http.Handler) http.Handler {
+	return IdempotencyMiddleware(idempotency.NewMemory(), IdempotencyConfig{
+		TTL:              time.Minute,
+		LockTimeout:      time.Minute,
+		MaxResponseBytes: 1024,
+		Logger:           zap.NewNop().Sugar(),
+	})(next)
+}
+
+func TestIdempotency(t *testing.T) {
+	first := idempotencyRequest{key: "k1", body: `{"sku":"a"}`}
+	tests := []struct {
+		name     string
+		repeat   idempotencyRequest
+		status   int
+		replayed bool
+		// calls is how often the upstream is reached for both requests
+		calls int32
+	}{
+		{"same request", first, http.StatusCreated, true, 1},
+		{"different body", idempotencyRequest{key: "k1", body: `{"sku":"b"}`}, http.StatusUnprocessableEntity, false, 1},
+		{"different path", idempotencyRequest{key: "k1", path: "/api/orders/o1/cancel", body: `{"sku":"a"}`}, http.StatusUnprocessableEntity, false, 1},
+		{"different key", idempotencyRequest{key: "k2", body: `{"sku":"a"}`}, http.StatusCreated, false, 2},
+		{"other user", idempotencyRequest{user: "u2", key: "k1", body: `{"sku":"a"}`}, http.StatusCreated, false, 2},
+		{"no key", idempotencyRequest{body: `{"sku":"a"}`}, http.StatusCreated, false, 2},
+		{"invalid key", idempotencyRequest{key: "k\x01", body: `{"sku":"a"}`}, http.StatusBadRequest, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			h := newIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Location", fmt.Sprintf("/api/orders/o%d", n))
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"id":"o%d"}`, n)
+			}))
+
+			original := first.send(h)
+			if original.Code != http.StatusCreated {
+				t.Fatalf("first request got %d", original.Code)
+			}
+			rec := tt.repeat.send(h)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := rec.Header().Get(IdempotentReplayedHeader) == "true"; got != tt.replayed {
+				t.Errorf("got %s %q, want replayed %v", IdempotentReplayedHeader, rec.Header().Get(IdempotentReplayedHeader), tt.replayed)
+			}
+			if tt.replayed {
+				if rec.Body.String() != original.Body.String() || rec.Header().Get("Location") != original.Header().Get("Location") {
+					t.Errorf("replayed %q at %q, want %q at %q", rec.Body.String(), rec.Header().Get("Location"),
+						original.Body.String(), original.Header().Get("Location"))
+				}
+			}
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("upstream reached %d times, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := newIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusCreated)
+	}))
+	req := idempotencyRequest{key: "k1", body: `{"sku":"a"}`}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- req.send(h)
+	}()
+	<-started
+
+	rec := req.send(h)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d with Retry-After %q, want a 409 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	close(finish)
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Errorf("first request got %d", rec.Code)
+	}
+	if rec := req.send(h); rec.Code != http.StatusCreated || rec.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("got %d, want the replayed 201 once the first request is done", rec.Code)
+	}
+}
+
+func TestIdempotencyDoesNotStoreServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	h := newIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	req := idempotencyRequest{key: "k1", body: `{"sku":"a"}`}
+
+	if rec := req.send(h); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first request got %d", rec.Code)
+	}
+	// The key was released, so the retry is processed
+	if rec := req.send(h); rec.Code != http.StatusCreated || rec.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("retry got %d, replayed %q", rec.Code, rec.Header().Get(IdempotentReplayedHeader))
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upstream reached %d times, want 2", got)
+	}
+}
+
+func TestIdempotencyLapsedLockKeepsNewerClaim(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := IdempotencyMiddleware(idempotency.NewMemory(), IdempotencyConfig{
+		TTL:              time.Minute,
+		LockTimeout:      10 * time.Millisecond,
+		MaxResponseBytes: 1024,
+		Logger:           zap.NewNop().Sugar(),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			close(started)
+			<-finish
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":"o%d"}`, n)
+	}))
+	req := idempotencyRequest{key: "k1", body: `{"sku":"a"}`}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- req.send(h)
+	}()
+	<-started
+	time.Sleep(20 * time.Millisecond)
+
+	// The first request outlasted its lock, so the retry is processed
+	if rec := req.send(h); rec.Body.String() != `{"id":"o2"}` {
+		t.Fatalf("retry got %d %q, want it processed", rec.Code, rec.Body.String())
+	}
+	close(finish)
+	<-first
+
+	// The late first response neither replaced nor released the retry's
+	if rec := req.send(h); rec.Header().Get(IdempotentReplayedHeader) != "true" || rec.Body.String() != `{"id":"o2"}` {
+		t.Errorf("got %d %q, want the retry's response replayed", rec.Code, rec.Body.String())
+	}
+}
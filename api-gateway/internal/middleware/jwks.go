@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// JWKS caches the signing keys published by the identity service. Keys are
+// refreshed in the background, and on demand when a token names a key ID
+// that is not cached yet, so rotated keys are picked up without a restart.
+type JWKS struct {
+	url             string
+	refreshInterval time.Duration
+	minRefresh      time.Duration
+	client          *http.Client
+	logger          *zap.SugaredLogger
+
+	mu   sync.RWMutex
+	keys map[string]jwksKey
+
+	// refreshing serializes fetches; lastAttempt is guarded by it
+	refreshing  sync.Mutex
+	lastAttempt time.Time
+}
+
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+type rawJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewJWKS(url string, refreshInterval, minRefresh time.Duration, logger *zap.SugaredLogger) *JWKS {
+	return &JWKS{
+		url:             url,
+		refreshInterval: refreshInterval,
+		minRefresh:      minRefresh,
+		client:          &http.Client{Timeout: 5 * time.Second},
+		logger:          logger,
+		keys:            make(map[string]jwksKey),
+	}
+}
+
+// Start fetches the key set and keeps refreshing it until ctx is done. A
+// failed initial fetch is logged rather than returned, since the identity
+// service may simply not be up yet.
+func (j *JWKS) Start(ctx context.Context) {
+	if err := j.Refresh(ctx); err != nil {
+		j.logger.Warnw("Failed to fetch JWKS", "url", j.url, "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(j.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := j.Refresh(ctx); err != nil {
+					j.logger.Warnw("Failed to refresh JWKS", "url", j.url, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Refresh replaces the cached keys with the ones currently published.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshing.Lock()
+	defer j.refreshing.Unlock()
+	return j.fetch(ctx)
+}
+
+// refreshIfStale refreshes the keys unless they were fetched, or a fetch
+// was attempted, within the last minRefresh.
+func (j *JWKS) refreshIfStale(ctx context.Context) {
+	j.refreshing.Lock()
+	defer j.refreshing.Unlock()
+
+	if time.Since(j.lastAttempt) < j.minRefresh {
+		return
+	}
+	if err := j.fetch(ctx); err != nil {
+		j.logger.Warnw("Failed to refresh JWKS", "url", j.url, "error", err)
+	}
+}
+
+func (j *JWKS) fetch(ctx context.Context) error {
+	j.lastAttempt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []rawJWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]jwksKey, len(set.Keys))
+	for _, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(raw)
+		if err != nil {
+			j.logger.Warnw("Skipping invalid JWK", "kid", raw.Kid, "error", err)
+			continue
+		}
+		keys[raw.Kid] = jwksKey{alg: raw.Alg, key: key}
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// Keyfunc selects the verification key for token by its kid header. The
+// allowed algorithms are enforced by the parser; Keyfunc additionally
+// rejects a token whose alg differs from the one pinned on its key.
+func (j *JWKS) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	key, ok := j.lookup(kid)
+	if !ok {
+		j.refreshIfStale(context.Background())
+		key, ok = j.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("signing key %q does not allow %s", kid, token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+func (j *JWKS) lookup(kid string) (jwksKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func parseJWK(raw rawJWK) (interface{}, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+
+	switch raw.Kty {
+	case "RSA":
+		n, err := b64(raw.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(raw.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve ecdh.Curve
+		switch raw.Crv {
+		case "P-256":
+			curve = ecdh.P256()
+		case "P-384":
+			curve = ecdh.P384()
+		case "P-521":
+			curve = ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err := b64(raw.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(raw.Y)
+		if err != nil {
+			return nil, err
+		}
+		// Round-trip through crypto/ecdh and PKIX so the point is validated.
+		point, err := curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKIXPublicKey(point)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(*ecdsa.PublicKey); !ok {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+
+	case "OKP":
+		if raw.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err := b64(raw.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", raw.Kty)
+}
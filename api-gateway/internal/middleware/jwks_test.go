@@ -0,0 +1,310 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// testKeys are the signing keys of the fake identity service.
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+	ed  ed25519.PrivateKey
+}
+
+var (
+	keysOnce sync.Once
+	keys     testKeys
+)
+
+func signingKeys(t *testing.T) testKeys {
+	t.Helper()
+	keysOnce.Do(func() {
+		var err error
+		if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if keys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+		if _, keys.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return keys
+}
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+func rsaJWK(kid, alg string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": alg,
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string, key *ecdsa.PrivateKey) map[string]string {
+	t.Helper()
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The uncompressed point: 0x04, then X and Y
+	point := pub.Bytes()
+	size := (len(point) - 1) / 2
+	return map[string]string{
+		"kty": "EC", "kid": kid, "alg": "ES256", "crv": "P-256",
+		"x": b64(point[1 : 1+size]), "y": b64(point[1+size:]),
+	}
+}
+
+func okpJWK(kid string, key ed25519.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "OKP", "kid": kid, "alg": "EdDSA", "crv": "Ed25519",
+		"x": b64(key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// jwksServer publishes a key set that tests can replace, and counts the
+// fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// testClaims are valid claims for the gateway, expiring in an hour.
+func testClaims() *UserClaims {
+	return &UserClaims{
+		UserID: "u1",
+		Role:   "customer",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "identity-service",
+			Audience:  jwt.ClaimStrings{"api-gateway"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.Claims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// authenticate sends token through the auth middleware verifying against
+// jwks and returns the status.
+func authenticate(jwks *JWKS, token string) int {
+	h := AuthMiddleware(AuthConfig{
+		Keyfunc:    jwks.Keyfunc,
+		Algorithms: []string{"RS256", "ES256", "EdDSA"},
+		Issuer:     "identity-service",
+		Audience:   "api-gateway",
+		Logger:     zap.NewNop().Sugar(),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newTestJWKS(t *testing.T, url string, minRefresh time.Duration) *JWKS {
+	t.Helper()
+	jwks := NewJWKS(url, time.Hour, minRefresh, zap.NewNop().Sugar())
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return jwks
+}
+
+func TestJWKSVerifiesTokens(t *testing.T) {
+	k := signingKeys(t)
+	srv := newJWKSServer(t, rsaJWK("rsa", "RS256", k.rsa), ecJWK(t, "ec", k.ec), okpJWK("ed", k.ed))
+	jwks := newTestJWKS(t, srv.URL, time.Hour)
+
+	wrongIssuer := testClaims()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := testClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"payment-service"}
+	expired := testClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := testClaims()
+	noExpiry.ExpiresAt = nil
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RSA", sign(t, jwt.SigningMethodRS256, "rsa", testClaims(), k.rsa), http.StatusOK},
+		{"EC", sign(t, jwt.SigningMethodES256, "ec", testClaims(), k.ec), http.StatusOK},
+		{"OKP", sign(t, jwt.SigningMethodEdDSA, "ed", testClaims(), k.ed), http.StatusOK},
+
+		{"alg none", sign(t, jwt.SigningMethodNone, "rsa", testClaims(), jwt.UnsafeAllowNoneSignatureType), http.StatusUnauthorized},
+		// The public key used as an HMAC secret
+		{"HS256 with the RSA key", sign(t, jwt.SigningMethodHS256, "rsa", testClaims(), rsaPublic), http.StatusUnauthorized},
+		// An allowed algorithm, but not the one pinned on the key
+		{"ES256 with the RSA kid", sign(t, jwt.SigningMethodES256, "rsa", testClaims(), k.ec), http.StatusUnauthorized},
+		{"RS384 with the RSA key", sign(t, jwt.SigningMethodRS384, "rsa", testClaims(), k.rsa), http.StatusUnauthorized},
+		{"signed by another key", sign(t, jwt.SigningMethodRS256, "rsa", testClaims(), otherRSA), http.StatusUnauthorized},
+		{"no kid", sign(t, jwt.SigningMethodRS256, "", testClaims(), k.rsa), http.StatusUnauthorized},
+
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", wrongIssuer, k.rsa), http.StatusUnauthorized},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", wrongAudience, k.rsa), http.StatusUnauthorized},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", expired, k.rsa), http.StatusUnauthorized},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, "rsa", noExpiry, k.rsa), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authenticate(jwks, tt.token); got != tt.status {
+				t.Errorf("got status %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestJWKSKeyWithoutAlgStillChecksKeyType(t *testing.T) {
+	k := signingKeys(t)
+	// Without an alg the key is not pinned, so only its type protects it
+	srv := newJWKSServer(t, rsaJWK("rsa", "", k.rsa))
+	jwks := newTestJWKS(t, srv.URL, time.Hour)
+
+	if got := authenticate(jwks, sign(t, jwt.SigningMethodRS256, "rsa", testClaims(), k.rsa)); got != http.StatusOK {
+		t.Errorf("RS256: got status %d", got)
+	}
+	if got := authenticate(jwks, sign(t, jwt.SigningMethodES256, "rsa", testClaims(), k.ec)); got != http.StatusUnauthorized {
+		t.Errorf("ES256 against an RSA key: got status %d", got)
+	}
+}
+
+func TestJWKSRefetchesUnknownKeys(t *testing.T) {
+	k := signingKeys(t)
+	srv := newJWKSServer(t, rsaJWK("old", "RS256", k.rsa))
+	const minRefresh = 20 * time.Millisecond
+	jwks := newTestJWKS(t, srv.URL, minRefresh)
+	time.Sleep(minRefresh)
+
+	// The identity service rotates to a new key
+	srv.publish(rsaJWK("old", "RS256", k.rsa), ecJWK(t, "new", k.ec))
+	if got := authenticate(jwks, sign(t, jwt.SigningMethodES256, "new", testClaims(), k.ec)); got != http.StatusOK {
+		t.Fatalf("token signed with the rotated key got status %d", got)
+	}
+	if got := srv.fetches.Load(); got != 2 {
+		t.Errorf("got %d fetches, want the unknown kid to trigger one", got)
+	}
+
+	// Known keys are served from the cache
+	time.Sleep(minRefresh)
+	for range 3 {
+		authenticate(jwks, sign(t, jwt.SigningMethodRS256, "old", testClaims(), k.rsa))
+	}
+	if got := srv.fetches.Load(); got != 2 {
+		t.Errorf("got %d fetches, want none for known keys", got)
+	}
+}
+
+func TestJWKSRefetchIsRateLimited(t *testing.T) {
+	k := signingKeys(t)
+	srv := newJWKSServer(t, rsaJWK("rsa", "RS256", k.rsa))
+	const minRefresh = 50 * time.Millisecond
+	jwks := newTestJWKS(t, srv.URL, minRefresh)
+	time.Sleep(minRefresh)
+
+	// Tokens with made-up kids must not turn into a flood of fetches
+	unknown := sign(t, jwt.SigningMethodRS256, "made-up", testClaims(), k.rsa)
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := authenticate(jwks, unknown); got != http.StatusUnauthorized {
+				t.Errorf("unknown kid got status %d", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := srv.fetches.Load(); got != 2 {
+		t.Errorf("got %d fetches, want one refetch for all the unknown kids", got)
+	}
+
+	time.Sleep(minRefresh)
+	authenticate(jwks, unknown)
+	if got := srv.fetches.Load(); got != 3 {
+		t.Errorf("got %d fetches, want another refetch after minRefresh", got)
+	}
+}
+
+func TestJWKSSkipsUnusableKeys(t *testing.T) {
+	k := signingKeys(t)
+	encryption := rsaJWK("enc", "RSA-OAEP", k.rsa)
+	encryption["use"] = "enc"
+	badCurve := ecJWK(t, "bad-curve", k.ec)
+	badCurve["crv"] = "P-192"
+	offCurve := ecJWK(t, "off-curve", k.ec)
+	offCurve["y"] = offCurve["x"]
+	srv := newJWKSServer(t, encryption, badCurve, offCurve, okpJWK("ed", k.ed))
+	jwks := newTestJWKS(t, srv.URL, time.Hour)
+
+	for _, kid := range []string{"enc", "bad-curve", "off-curve"} {
+		if _, ok := jwks.lookup(kid); ok {
+			t.Errorf("key %s was loaded", kid)
+		}
+	}
+	if _, ok := jwks.lookup("ed"); !ok {
+		t.Error("the valid key next to them was not loaded")
+	}
+}
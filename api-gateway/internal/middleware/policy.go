@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"go.uber.org/zap"
+)
+
+// RoutePolicy enforces each route's authorization policy. It must run
+// inside the auth middleware, which puts the caller's claims on the
+// request context.
+func RoutePolicy(policies map[string]config.Policy, roleScopes map[string][]string, logger *zap.SugaredLogger) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		policy, ok := policies[route.Policy]
+		if !ok {
+			return nil
+		}
+		return PolicyMiddleware(route.Name, route.Policy, policy, roleScopes, logger)
+	}
+}
+
+// PolicyMiddleware rejects callers that do not satisfy policy with a 403
+// problem naming the policy and the missing scopes, and writes an audit
+// log line for every denial.
+func PolicyMiddleware(routeName, name string, policy config.Policy, roleScopes map[string][]string, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value(UserKey).(*UserClaims)
+
+			var userID, role string
+			var roleAllowed bool
+			var missing []string
+			if claims != nil {
+				userID, role = claims.UserID, claims.Role
+				roleAllowed = len(policy.Roles) == 0 || slices.ContainsFunc(policy.Roles, func(r string) bool {
+					return strings.EqualFold(r, role)
+				})
+				missing = missingScopes(policy.Scopes, grantedScopes(claims, roleScopes))
+			} else {
+				missing = policy.Scopes
+			}
+
+			if claims != nil && roleAllowed && len(missing) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Warnw("Authorization denied",
+				"audit", true,
+				"route", routeName,
+				"policy", name,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"user_id", userID,
+				"role", role,
+				"required_roles", policy.Roles,
+				"missing_scopes", missing,
+				"remote_addr", r.RemoteAddr,
+				"request_id", middleware.GetReqID(r.Context()),
+			)
+
+			p := problem.New(http.StatusForbidden, problem.CodeForbidden, "You are not allowed to perform this action")
+			p.Extensions = map[string]interface{}{"policy": name}
+			if len(policy.Roles) > 0 {
+				p.Extensions["required_roles"] = policy.Roles
+			}
+			if len(policy.Scopes) > 0 {
+				p.Extensions["required_scopes"] = policy.Scopes
+			}
+			if len(missing) > 0 {
+				p.Extensions["missing_scopes"] = missing
+			}
+			problem.Write(w, r, p)
+		})
+	}
+}
+
+// grantedScopes returns the scopes in the token's scope claim plus those
+// granted to the caller's role. Role names are matched case-insensitively
+// since the route table's keys are lowercased when it is loaded.
+func grantedScopes(claims *UserClaims, roleScopes map[string][]string) map[string]bool {
+	granted := make(map[string]bool)
+	for _, scope := range strings.Fields(claims.Scope) {
+		granted[scope] = true
+	}
+	for _, scope := range roleScopes[strings.ToLower(claims.Role)] {
+		granted[scope] = true
+	}
+	return granted
+}
+
+func missingScopes(required []string, granted map[string]bool) []string {
+	var missing []string
+	for _, scope := range required {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
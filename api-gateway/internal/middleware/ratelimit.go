@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// RouteClientIPRateLimit applies the client_ip rate limit policy, if the
+// route table has one, to every route by client IP. It must run before the
+// auth middleware, so that requests with missing, invalid or revoked
+// tokens are limited too; RouteRateLimit then limits each user.
+func RouteClientIPRateLimit(limiter ratelimit.Limiter, policies map[string]config.RateLimitPolicy, logger *zap.SugaredLogger) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		policy, ok := policies[config.ClientIPRateLimit]
+		if !ok {
+			return nil
+		}
+		return rateLimit(limiter, config.ClientIPRateLimit, policy, clientIPKey, logger)
+	}
+}
+
+// RouteRateLimit applies each route's rate limit policy. Authenticated
+// callers are limited by user ID, everyone else by client IP, so it must
+// run inside the auth middleware.
+func RouteRateLimit(limiter ratelimit.Limiter, policies map[string]config.RateLimitPolicy, logger *zap.SugaredLogger) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		policy, ok := policies[route.RateLimit]
+		if !ok {
+			return nil
+		}
+		return RateLimitMiddleware(limiter, route.RateLimit, policy, logger)
+	}
+}
+
+// RateLimitMiddleware limits requests under policy and sets the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Rejected requests get a 429 with Retry-After. If the limiter fails the
+// request is let through.
+func RateLimitMiddleware(limiter ratelimit.Limiter, name string, policy config.RateLimitPolicy, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return rateLimit(limiter, name, policy, rateLimitKey, logger)
+}
+
+// rateLimit limits requests under policy, counting them per key(r).
+func rateLimit(limiter ratelimit.Limiter, name string, policy config.RateLimitPolicy, key func(*http.Request) string, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := limiter.Allow(r.Context(), name+":"+key(r), policy)
+			if err != nil {
+				logger.Errorw("Rate limiter failed", "policy", name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+				problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the caller: the user ID from the JWT claims if
+// there are any, otherwise the client IP resolved by chi's RealIP.
+func rateLimitKey(r *http.Request) string {
+	if claims, ok := r.Context().Value(UserKey).(*UserClaims); ok && claims.UserID != "" {
+		return "user:" + claims.UserID
+	}
+	return clientIPKey(r)
+}
+
+// clientIPKey identifies the caller by the client IP resolved by chi's
+// RealIP.
+func clientIPKey(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return "ip:" + ip
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+func TestClientIPRateLimitRunsBeforeAuth(t *testing.T) {
+	policies := map[string]config.RateLimitPolicy{
+		config.ClientIPRateLimit: {Requests: 2, Window: time.Minute, Burst: 2},
+		"default":                {Requests: 100, Window: time.Minute, Burst: 100},
+	}
+	rejectAll := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	route := config.Route{Auth: true, RateLimit: "default"}
+	limiter := ratelimit.NewMemory()
+	logger := zap.NewNop().Sugar()
+	h := chain(route, http.NotFoundHandler(),
+		RouteClientIPRateLimit(limiter, policies, logger),
+		RouteAuth(rejectAll),
+		RouteRateLimit(limiter, policies, logger),
+	)
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"192.0.2.1:1000", http.StatusUnauthorized},
+		{"192.0.2.1:1001", http.StatusUnauthorized},
+		{"192.0.2.1:1002", http.StatusTooManyRequests},
+		// Other clients have their own budget
+		{"192.0.2.2:1000", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("Authorization", "Bearer invalid")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.remoteAddr, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *UserClaims
+		want   string
+	}{
+		{"anonymous", nil, "ip:192.0.2.1"},
+		{"authenticated", &UserClaims{UserID: "u1"}, "user:u1"},
+		{"no user ID", &UserClaims{}, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), UserKey, tt.claims))
+			}
+			if got := rateLimitKey(req); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := clientIPKey(req); got != "ip:192.0.2.1" {
+				t.Errorf("client IP key: got %q", got)
+			}
+		})
+	}
+}
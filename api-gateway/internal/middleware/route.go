@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+)
+
+// RouteMiddleware builds the middleware for a single route of the route
+// table. It returns nil when the route needs none.
+type RouteMiddleware func(route config.Route) func(http.Handler) http.Handler
+
+// RouteAuth applies auth to the routes that require authentication.
+func RouteAuth(auth func(http.Handler) http.Handler) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		if !route.Auth {
+			return nil
+		}
+		return auth
+	}
+}
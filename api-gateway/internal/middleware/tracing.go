@@ -1,37 +1,65 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/accesslog"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// TracingMiddleware starts the server span of every request, continuing
+// the caller's trace when the request carries one. The span is named
+// after the method and the chi route pattern once the request has been
+// routed, which keeps the number of span names bounded; requests that
+// match no route keep the method alone. The response status is recorded,
+// and server errors and panics mark the span as failed.
 func TracingMiddleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer("api-gateway")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tracer := otel.Tracer("api-gateway")
-
-		propagator := otel.GetTextMapPropagator()
-		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
 		ctx, span := tracer.Start(
 			ctx,
-			r.Method+" "+r.URL.Path,
+			r.Method,
 			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("server.address", r.Host),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
 		)
 		defer span.End()
 
-		span.SetAttributes(
-			attribute.String("http.method", r.Method),
-			attribute.String("http.url", r.URL.String()),
-			attribute.String("http.host", r.Host),
-			attribute.String("http.user_agent", r.UserAgent()),
-		)
-
-		r = r.WithContext(ctx)
+		accesslog.SetSpan(ctx, span.SpanContext())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			if p := recover(); p != nil {
+				span.RecordError(fmt.Errorf("panic: %v", p), trace.WithStackTrace(true))
+				span.SetStatus(codes.Error, "panic")
+				// Left to the Recoverer to answer
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(ww, r.WithContext(ctx))
 
-		next.ServeHTTP(w, r)
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
 	})
 }
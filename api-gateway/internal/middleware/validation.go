@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/validation"
+)
+
+// RouteValidation limits the request body of every route to its
+// MaxBodyBytes and validates it against the route's schema, if it names
+// one. It should be the innermost route middleware so only authorized
+// requests are read.
+func RouteValidation(validator *validation.Validator) RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		return ValidationMiddleware(validator, route.Schema, route.MaxBodyBytes)
+	}
+}
+
+// ValidationMiddleware rejects bodies over maxBytes with a 413. When schema
+// is set the body must be JSON matching it; otherwise the request gets a
+// 400 problem listing the invalid fields.
+func ValidationMiddleware(validator *validation.Validator, schema string, maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				bodyTooLarge(w, r)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+			if schema == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !isJSON(r.Header.Get("Content-Type")) {
+				problem.Error(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, "Request body must be application/json")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					bodyTooLarge(w, r)
+					return
+				}
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Failed to read request body")
+				return
+			}
+
+			fieldErrs, err := validator.Validate(schema, body)
+			if err != nil {
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid JSON: "+err.Error())
+				return
+			}
+			if len(fieldErrs) > 0 {
+				problem.Write(w, r, problem.Validation(fieldErrs...))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "Request body is too large")
+}
+
+// isJSON reports whether contentType is application/json or a +json type.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
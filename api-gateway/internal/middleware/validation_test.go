@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/validation"
+)
+
+func TestRouteValidation(t *testing.T) {
+	validator, err := validation.NewValidator("", []string{"order"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := config.Route{Method: http.MethodPost, Path: "/api/orders", Schema: "order", MaxBodyBytes: 256}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        string
+		// errors are the field and code of the expected field errors
+		errors [][2]string
+	}{
+		{"valid", "application/json", `{"shipping_address":"1 Main St","payment_method":"card"}`, http.StatusOK, "", nil},
+		{"invalid fields", "application/json", `{"shipping_address":"","user_id":"u2"}`, http.StatusBadRequest, problem.CodeValidation,
+			[][2]string{{"payment_method", problem.FieldRequired}, {"shipping_address", problem.FieldInvalid}, {"user_id", problem.FieldUnknown}}},
+		{"wrong type", "application/json; charset=utf-8", `{"shipping_address":1,"payment_method":"card"}`, http.StatusBadRequest, problem.CodeValidation,
+			[][2]string{{"shipping_address", problem.FieldInvalid}}},
+		{"invalid JSON", "application/json", `{"shipping_address":`, http.StatusBadRequest, problem.CodeInvalidBody, nil},
+		{"not JSON", "application/x-www-form-urlencoded", `shipping_address=x`, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, nil},
+		{"too large", "application/json", `{"shipping_address":"` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwarded string
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				forwarded = string(body)
+			})
+			h := chain(route, upstream, RouteValidation(validator))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				if forwarded != tt.body {
+					t.Errorf("forwarded %q, want the body unchanged", forwarded)
+				}
+				return
+			}
+			if forwarded != "" {
+				t.Errorf("rejected body forwarded: %q", forwarded)
+			}
+
+			// An RFC 7807 problem document, with the field errors as errors
+			if ct := rec.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("got Content-Type %q, want %q", ct, problem.ContentType)
+			}
+			var p struct {
+				Type   string `json:"type"`
+				Title  string `json:"title"`
+				Status int    `json:"status"`
+				Code   string `json:"code"`
+				Errors []struct {
+					Field   string `json:"field"`
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != "about:blank" || p.Title != http.StatusText(tt.status) || p.Status != tt.status || p.Code != tt.code {
+				t.Errorf("got problem %+v, want %d %s", p, tt.status, tt.code)
+			}
+			if len(p.Errors) != len(tt.errors) {
+				t.Fatalf("got errors %+v, want %v", p.Errors, tt.errors)
+			}
+			for i, e := range p.Errors {
+				if e.Field != tt.errors[i][0] || e.Code != tt.errors[i][1] || e.Message == "" {
+					t.Errorf("error %d: got %+v, want %v with a message", i, e, tt.errors[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	versionRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_api_version_requests_total",
+		Help: "Requests to versioned routes, by API version, route name and whether the unversioned path was used.",
+	}, []string{"version", "route", "unversioned"})
+
+	versionLastRequest = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_api_version_last_request_timestamp_seconds",
+		Help: "Time of the last request to an API version.",
+	}, []string{"version"})
+)
+
+// RouteVersion counts the requests to each API version and announces the
+// deprecation of a version in the response headers: Deprecation (RFC
+// 9745), Sunset (RFC 8594) and Link to the migration guide and to the
+// route in the newest version. It should be the outermost route
+// middleware so that rejected requests are counted and told too.
+func RouteVersion() RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		version := route.Version
+		if version == nil {
+			return nil
+		}
+		requests := versionRequests.WithLabelValues(version.Name, route.Name, strconv.FormatBool(version.Unversioned))
+		lastRequest := versionLastRequest.WithLabelValues(version.Name)
+
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Inc()
+				lastRequest.SetToCurrentTime()
+
+				if !version.Deprecated.IsZero() {
+					header := w.Header()
+					header.Set("Deprecation", "@"+strconv.FormatInt(version.Deprecated.Unix(), 10))
+					if !version.Sunset.IsZero() {
+						header.Set("Sunset", version.Sunset.UTC().Format(http.TimeFormat))
+					}
+					if version.Link != "" {
+						header.Add("Link", "<"+version.Link+`>; rel="deprecation"; type="text/html"`)
+					}
+					if version.Successor != "" {
+						successor := version.Successor + strings.TrimPrefix(r.URL.EscapedPath(), version.Prefix)
+						header.Add("Link", "<"+successor+`>; rel="successor-version"`)
+					}
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+}
+
+// RouteTransform rewrites the JSON request and response bodies of the
+// routes whose API version differs from the upstream, and strips the
+// responses of routes with response_fields down to those fields. Requests
+// are rewritten after validation, which checks them in the version's own
+// shape, so it should come after RouteValidation; only 2xx JSON responses
+// are rewritten, problem documents are passed through.
+func RouteTransform() RouteMiddleware {
+	return func(route config.Route) func(http.Handler) http.Handler {
+		var transform config.RouteTransform
+		if route.Version != nil {
+			transform = route.Version.Transform
+		}
+		fields := route.ResponseFields
+		if transform.Request.IsZero() && transform.Response.IsZero() && len(fields) == 0 {
+			return nil
+		}
+
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !transform.Request.IsZero() && r.Body != nil && isJSON(r.Header.Get("Content-Type")) {
+					body, err := transformBody(r.Body, func(v interface{}) {
+						applyTransform(transform.Request, v)
+					})
+					if err != nil {
+						problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid JSON: "+err.Error())
+						return
+					}
+					r.Body = io.NopCloser(bytes.NewReader(body))
+					r.ContentLength = int64(len(body))
+				}
+
+				if transform.Response.IsZero() && len(fields) == 0 {
+					next.ServeHTTP(w, r)
+					return
+				}
+				// The allowlist is in the upstream's shape, so it applies
+				// before the version's transform
+				tw := &transformWriter{w: w, strict: len(fields) > 0}
+				next.ServeHTTP(tw, r)
+				tw.finish(r, func(v interface{}) {
+					keepFields(fields, v)
+					applyTransform(transform.Response, v)
+				})
+			})
+		}
+	}
+}
+
+// transformBody decodes the JSON in body and returns it rewritten by fn.
+func transformBody(body io.Reader, fn func(v interface{})) ([]byte, error) {
+	decoder := json.NewDecoder(body)
+	// Keeps numbers exactly as sent
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	fn(v)
+	return json.Marshal(v)
+}
+
+// keepFields drops every field of the decoded JSON value v that is not
+// named by, or on the way to, one of paths. Without paths v is unchanged.
+func keepFields(paths []string, v interface{}) {
+	if len(paths) == 0 {
+		return
+	}
+	tree := make(fieldTree)
+	for _, path := range paths {
+		node := tree
+		for _, field := range strings.Split(path, ".") {
+			next, ok := node[field]
+			if !ok {
+				next = make(fieldTree)
+				node[field] = next
+			}
+			node = next
+		}
+	}
+	tree.prune(v)
+}
+
+// fieldTree holds the fields kept by keepFields. A field with no children
+// is kept whole.
+type fieldTree map[string]fieldTree
+
+func (t fieldTree) prune(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			t.prune(elem)
+		}
+	case map[string]interface{}:
+		for field, value := range v {
+			children, ok := t[field]
+			switch {
+			case !ok:
+				delete(v, field)
+			case len(children) > 0:
+				children.prune(value)
+			}
+		}
+	}
+}
+
+// applyTransform rewrites the decoded JSON value v in place.
+func applyTransform(t config.BodyTransform, v interface{}) {
+	for path, name := range t.Rename {
+		parents, field := splitPath(path)
+		eachObject(v, parents, func(obj map[string]interface{}) {
+			if value, ok := obj[field]; ok {
+				delete(obj, field)
+				obj[name] = value
+			}
+		})
+	}
+	for _, path := range t.Remove {
+		parents, field := splitPath(path)
+		eachObject(v, parents, func(obj map[string]interface{}) {
+			delete(obj, field)
+		})
+	}
+	for path, value := range t.Defaults {
+		parents, field := splitPath(path)
+		eachObject(v, parents, func(obj map[string]interface{}) {
+			if _, ok := obj[field]; !ok {
+				obj[field] = value
+			}
+		})
+	}
+}
+
+func splitPath(path string) ([]string, string) {
+	fields := strings.Split(path, ".")
+	return fields[:len(fields)-1], fields[len(fields)-1]
+}
+
+// eachObject calls fn with every object found by following the fields in
+// path from v, descending into each element of the arrays on the way.
+func eachObject(v interface{}, path []string, fn func(map[string]interface{})) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			eachObject(elem, path, fn)
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			fn(v)
+			return
+		}
+		eachObject(v[path[0]], path[1:], fn)
+	}
+}
+
+// transformWriter buffers a 2xx JSON response so it can be rewritten.
+// Other responses are passed straight through, except, when strict, 2xx
+// responses that are not JSON: those are buffered to be refused.
+type transformWriter struct {
+	w           http.ResponseWriter
+	strict      bool
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (t *transformWriter) Header() http.Header {
+	return t.w.Header()
+}
+
+func (t *transformWriter) WriteHeader(status int) {
+	if t.status != 0 {
+		return
+	}
+	t.status = status
+	if status < 200 || status > 299 || status == http.StatusNoContent || !t.strict && !isJSON(t.w.Header().Get("Content-Type")) {
+		t.passthrough = true
+		t.w.WriteHeader(status)
+	}
+}
+
+func (t *transformWriter) Write(p []byte) (int, error) {
+	if t.status == 0 {
+		t.WriteHeader(http.StatusOK)
+	}
+	if t.passthrough {
+		return t.w.Write(p)
+	}
+	return t.buf.Write(p)
+}
+
+// finish writes the buffered response rewritten by fn. A body that is not
+// valid JSON is sent as it is, unless strict: what it would have leaked
+// cannot be told, so the client gets a 502 instead.
+func (t *transformWriter) finish(r *http.Request, fn func(v interface{})) {
+	if t.passthrough {
+		return
+	}
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+
+	body := t.buf.Bytes()
+	transformed, err := transformBody(bytes.NewReader(body), fn)
+	if t.strict && (err != nil || !isJSON(t.w.Header().Get("Content-Type"))) {
+		t.w.Header().Del("ETag")
+		t.w.Header().Del("Content-Length")
+		problem.Error(t.w, r, http.StatusBadGateway, problem.CodeUpstreamDown, "Invalid response from upstream")
+		return
+	}
+	if err == nil && transformed != nil {
+		body = transformed
+		// The upstream's validators describe the body before the rewrite
+		t.w.Header().Del("ETag")
+	}
+	t.w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	t.w.WriteHeader(t.status)
+	t.w.Write(body)
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+)
+
+// productV2 is the v2 transform of the product routes in routes.yaml.
+var productV2 = config.RouteTransform{
+	Schema: "product-v2",
+	Request: config.BodyTransform{
+		Rename: map[string]string{"image": "image_url", "stock_quantity": "stock"},
+	},
+	Response: config.BodyTransform{
+		Rename: map[string]string{"image_url": "image", "stock": "stock_quantity"},
+	},
+}
+
+func TestRouteTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform config.RouteTransform
+		body      string
+		// upstreamBody is the request body the product service gets
+		upstreamBody map[string]interface{}
+		response     map[string]interface{}
+	}{
+		{
+			name: "v1",
+			body: `{"name":"Mug","price":9.5,"image_url":"https://example.com/mug.jpg","stock":3}`,
+			upstreamBody: map[string]interface{}{
+				"name": "Mug", "price": 9.5, "image_url": "https://example.com/mug.jpg", "stock": 3.0,
+			},
+			response: map[string]interface{}{
+				"id": "p1", "name": "Mug", "price": 9.5, "image_url": "https://example.com/mug.jpg", "stock": 3.0,
+			},
+		},
+		{
+			name:      "v2",
+			transform: productV2,
+			body:      `{"name":"Mug","price":9.5,"image":"https://example.com/mug.jpg","stock_quantity":3}`,
+			upstreamBody: map[string]interface{}{
+				"name": "Mug", "price": 9.5, "image_url": "https://example.com/mug.jpg", "stock": 3.0,
+			},
+			response: map[string]interface{}{
+				"id": "p1", "name": "Mug", "price": 9.5, "image": "https://example.com/mug.jpg", "stock_quantity": 3.0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamBody map[string]interface{}
+			// Answers like the product service: the stored product, in its shape
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(data, &upstreamBody); err != nil {
+					t.Errorf("upstream got invalid JSON %q: %v", data, err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("ETag", `"upstream"`)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"id": "p1", "name": "Mug", "price": 9.5, "image_url": "https://example.com/mug.jpg", "stock": 3,
+				})
+			})
+			route := config.Route{
+				Method: http.MethodPut,
+				Path:   "/api/" + tt.name + "/products/{id}",
+				Version: &config.RouteVersion{
+					Name:      tt.name,
+					Prefix:    "/api/" + tt.name,
+					Transform: tt.transform,
+				},
+			}
+			h := chain(route, upstream, RouteTransform())
+
+			req := httptest.NewRequest(http.MethodPut, "/api/"+tt.name+"/products/p1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d", rec.Code)
+			}
+			if !reflect.DeepEqual(upstreamBody, tt.upstreamBody) {
+				t.Errorf("upstream got %v, want %v", upstreamBody, tt.upstreamBody)
+			}
+			var response map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+			}
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("got response %v, want %v", response, tt.response)
+			}
+			// A rewritten body no longer matches the upstream's ETag
+			if etag := rec.Header().Get("ETag"); (etag == "") != (tt.name == "v2") {
+				t.Errorf("got ETag %q", etag)
+			}
+		})
+	}
+}
+
+func TestRouteTransformPassesProblemsThrough(t *testing.T) {
+	problemBody := `{"code":"not_found","stock":0}`
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, problemBody)
+	})
+	route := config.Route{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/products/{id}",
+		Version: &config.RouteVersion{Name: "v2", Prefix: "/api/v2", Transform: config.RouteTransform{Response: productV2.Response}},
+	}
+	h := chain(route, upstream, RouteTransform())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/products/p1", nil))
+
+	if rec.Code != http.StatusNotFound || rec.Body.String() != problemBody {
+		t.Errorf("got %d %q, want the upstream's problem unchanged", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouteResponseFields(t *testing.T) {
+	// The inventory service's record of a product
+	record := `{"item":{"id":"i1","product_id":"p1","quantity":7,"reserved":2,"available":5,"location":"berlin","status":"in_stock"},"success":true,"message":null}`
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		wantStatus  int
+		want        string
+	}{
+		{"record", "application/json", http.StatusOK, record, http.StatusOK, `{"item":{"product_id":"p1","status":"in_stock"}}`},
+		{"array", "application/json", http.StatusOK, `[` + record + `]`, http.StatusOK, `[{"item":{"product_id":"p1","status":"in_stock"}}]`},
+		{"not found", "application/json", http.StatusNotFound, `{"error":"Inventory item not found","code":"inventory_not_found"}`,
+			http.StatusNotFound, `{"error":"Inventory item not found","code":"inventory_not_found"}`},
+		{"not JSON", "text/plain", http.StatusOK, "quantity=7", http.StatusBadGateway, ""},
+		{"invalid JSON", "application/json", http.StatusOK, `{"item":`, http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("ETag", `"abc"`)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			route := config.Route{
+				Method:         http.MethodGet,
+				Path:           "/api/inventory/{product_id}",
+				ResponseFields: []string{"item.product_id", "item.status"},
+			}
+			h := chain(route, upstream, RouteTransform())
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/inventory/p1", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.want != "" && rec.Body.String() != tt.want {
+				t.Errorf("got %s, want %s", rec.Body.String(), tt.want)
+			}
+			if tt.wantStatus == http.StatusBadGateway && strings.Contains(rec.Body.String(), "7") {
+				t.Errorf("the refused body leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouteResponseFieldsBeforeVersionTransform(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"p1","image_url":"https://example.com/mug.jpg","stock":3,"cost":2}`)
+	})
+	route := config.Route{
+		Method:         http.MethodGet,
+		Path:           "/api/v2/products/{id}",
+		ResponseFields: []string{"id", "image_url", "stock"},
+		Version:        &config.RouteVersion{Name: "v2", Prefix: "/api/v2", Transform: config.RouteTransform{Response: productV2.Response}},
+	}
+	h := chain(route, upstream, RouteTransform())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/products/p1", nil))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"id": "p1", "image": "https://example.com/mug.jpg", "stock_quantity": 3.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+// Package problem writes RFC 7807 problem details for errors raised by the
+// gateway itself. Every error response carries a stable machine-readable
+// code, the request ID and the trace ID, and validation failures list the
+// offending fields. Problem documents returned by the upstream services use
+// the same model and are passed through unchanged.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContentType is the media type of problem documents.
+const ContentType = "application/problem+json"
+
+// Error codes. Clients switch on these, so a code must never change
+// meaning once published.
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeValidation       = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeRateLimited      = "rate_limited"
+	CodeUpstreamDown     = "upstream_unavailable"
+	CodeUpstreamTimeout  = "upstream_timeout"
+	CodeCSRF             = "csrf_token_invalid"
+	CodeInternal         = "internal_error"
+
+	// A request's Idempotency-Key is malformed, was used for a different
+	// request, or is held by a request still in progress
+	CodeIdempotencyKeyInvalid = "idempotency_key_invalid"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse   = "idempotency_key_in_use"
+)
+
+// Field error codes.
+const (
+	FieldRequired = "required"
+	FieldInvalid  = "invalid"
+	FieldUnknown  = "unknown"
+)
+
+// FieldError describes a single invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Required reports a missing field.
+func Required(field string) FieldError {
+	return FieldError{Field: field, Code: FieldRequired, Message: field + " is required"}
+}
+
+// Invalid reports a field with an unacceptable value.
+func Invalid(field, message string) FieldError {
+	return FieldError{Field: field, Code: FieldInvalid, Message: message}
+}
+
+// Unknown reports a field the request must not contain.
+func Unknown(field string) FieldError {
+	return FieldError{Field: field, Code: FieldUnknown, Message: field + " is not allowed"}
+}
+
+// Problem is an RFC 7807 problem document. Type is always about:blank, so
+// Title is the HTTP status text and Code tells errors apart.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	TraceID   string       `json:"trace_id,omitempty"`
+	// Extensions are additional members written alongside the standard ones
+	Extensions map[string]interface{} `json:"-"`
+}
+
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Validation is a 400 listing the invalid fields of a request.
+func Validation(errs ...FieldError) *Problem {
+	p := New(http.StatusBadRequest, CodeValidation, "The request has invalid fields")
+	p.Errors = errs
+	return p
+}
+
+func (p *Problem) Error() string {
+	return p.Code + ": " + p.Detail
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type plain Problem
+	data, err := json.Marshal((*plain)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+
+	members := make(map[string]interface{}, len(p.Extensions))
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	// Standard members win over extensions of the same name
+	var standard map[string]interface{}
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return nil, err
+	}
+	for k, v := range standard {
+		members[k] = v
+	}
+	return json.Marshal(members)
+}
+
+// Write sends p, filling in the instance, request ID and trace ID from r.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = requestID(r)
+	}
+	if p.TraceID == "" {
+		p.TraceID = traceID(r)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error is the problem equivalent of http.Error.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Write(w, r, New(status, code, detail))
+}
+
+// requestID returns the ID assigned by chi's RequestID middleware.
+func requestID(r *http.Request) string {
+	return middleware.GetReqID(r.Context())
+}
+
+// traceID returns the trace ID of the active span, or of the caller's
+// traceparent header when the request is not traced locally.
+func traceID(r *http.Request) string {
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	// traceparent: version-traceid-parentid-flags
+	parts := strings.Split(r.Header.Get("Traceparent"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 {
+		if id, err := trace.TraceIDFromHex(parts[1]); err == nil {
+			return id.String()
+		}
+	}
+	return ""
+}
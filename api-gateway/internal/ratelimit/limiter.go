@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Limit is the capacity of the bucket
+	Limit int
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available when the
+	// request was not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token from the bucket identified by key.
+type Limiter interface {
+	Allow(ctx context.Context, key string, policy config.RateLimitPolicy) (Result, error)
+}
+
+// bucket computes the state of a token bucket holding tokens, after one
+// token has been requested.
+func bucket(policy config.RateLimitPolicy, tokens float64) Result {
+	rate := float64(policy.Requests) / float64(policy.Window)
+	result := Result{Limit: policy.Burst}
+
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((float64(policy.Burst) - tokens) / rate)
+	return result
+}
+
+// fallbackLogInterval bounds how often Fallback logs while primary is down.
+const fallbackLogInterval = 10 * time.Second
+
+// Fallback uses primary and switches to secondary for any request that
+// primary fails to answer.
+type Fallback struct {
+	primary   Limiter
+	secondary Limiter
+	logger    *zap.SugaredLogger
+	lastLog   atomic.Int64
+}
+
+func NewFallback(primary, secondary Limiter, logger *zap.SugaredLogger) *Fallback {
+	return &Fallback{
+		primary:   primary,
+		secondary: secondary,
+		logger:    logger,
+	}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, policy config.RateLimitPolicy) (Result, error) {
+	result, err := f.primary.Allow(ctx, key, policy)
+	if err == nil {
+		return result, nil
+	}
+
+	now := time.Now().UnixNano()
+	if last := f.lastLog.Load(); now-last > int64(fallbackLogInterval) && f.lastLog.CompareAndSwap(last, now) {
+		f.logger.Warnw("Rate limiter unavailable, using in-memory fallback", "error", err)
+	}
+	return f.secondary.Allow(ctx, key, policy)
+}
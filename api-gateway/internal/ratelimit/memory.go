@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+)
+
+// sweepInterval is how often idle buckets are dropped from memory.
+const sweepInterval = time.Minute
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled, after which it can be
+	// dropped without changing any decision
+	full time.Time
+}
+
+// Memory is a token bucket limiter local to this gateway instance.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	nextSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets:   make(map[string]*memoryBucket),
+		nextSweep: time.Now().Add(sweepInterval),
+	}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, policy config.RateLimitPolicy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.After(m.nextSweep) {
+		for k, b := range m.buckets {
+			if now.After(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	rate := float64(policy.Requests) / float64(policy.Window)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(policy.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+
+	result := bucket(policy, b.tokens)
+	if result.Allowed {
+		b.tokens--
+	}
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// refillScript refills the bucket at KEYS[1] at ARGV[1] tokens per
+// microsecond up to ARGV[2] tokens and returns the level before this
+// request takes a token. It uses the Redis clock so that gateway replicas
+// with skewed clocks still share one bucket.
+var refillScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local level = tokens
+if tokens >= 1 then
+  tokens = tokens - 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate / 1000) + 1000)
+return tostring(level)
+`)
+
+// Redis is a token bucket limiter shared by every gateway replica.
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, policy config.RateLimitPolicy) (Result, error) {
+	rate := float64(policy.Requests) / float64(policy.Window/time.Microsecond)
+	level, err := refillScript.Run(ctx, r.client, []string{"ratelimit:" + key},
+		strconv.FormatFloat(rate, 'g', -1, 64),
+		policy.Burst,
+	).Text()
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, err := strconv.ParseFloat(level, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return bucket(policy, tokens), nil
+}
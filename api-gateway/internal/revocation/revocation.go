@@ -0,0 +1,127 @@
+// Package revocation checks access tokens against the revocations recorded
+// by the identity service. The key layout below must match
+// identity-service/internal/revocation.
+package revocation
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// tokenKeyPrefix + jti marks a single token as revoked
+	tokenKeyPrefix = "revoked:jti:"
+	// userKeyPrefix + user ID holds a Unix timestamp; tokens of that user
+	// issued at or before it are revoked
+	userKeyPrefix = "revoked:user:"
+)
+
+// sweepInterval is how often expired entries are dropped from the cache.
+const sweepInterval = time.Minute
+
+// Token identifies the access token being checked.
+type Token struct {
+	ID       string
+	UserID   string
+	IssuedAt time.Time
+}
+
+// Checker reports whether a token has been revoked.
+type Checker interface {
+	Revoked(ctx context.Context, token Token) (bool, error)
+}
+
+// Redis looks revocations up in Redis, both keys in one round trip.
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Revoked(ctx context.Context, token Token) (bool, error) {
+	values, err := r.client.MGet(ctx, tokenKeyPrefix+token.ID, userKeyPrefix+token.UserID).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if token.ID != "" && values[0] != nil {
+		return true, nil
+	}
+	if notBefore, ok := values[1].(string); ok {
+		ts, err := strconv.ParseInt(notBefore, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		// A token without iat cannot prove it was issued afterwards
+		return token.IssuedAt.IsZero() || token.IssuedAt.Unix() <= ts, nil
+	}
+	return false, nil
+}
+
+type cacheKey struct {
+	id       string
+	userID   string
+	issuedAt int64
+}
+
+type cachedResult struct {
+	revoked bool
+	expires time.Time
+}
+
+// Cached remembers the results of another Checker for ttl, so most
+// requests are checked without a round trip. A revocation made through
+// another gateway instance therefore takes up to ttl to apply.
+type Cached struct {
+	next Checker
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	results   map[cacheKey]cachedResult
+	nextSweep time.Time
+}
+
+func NewCached(next Checker, ttl time.Duration) *Cached {
+	return &Cached{
+		next:      next,
+		ttl:       ttl,
+		results:   make(map[cacheKey]cachedResult),
+		nextSweep: time.Now().Add(sweepInterval),
+	}
+}
+
+func (c *Cached) Revoked(ctx context.Context, token Token) (bool, error) {
+	now := time.Now()
+	key := cacheKey{id: token.ID, userID: token.UserID, issuedAt: token.IssuedAt.Unix()}
+
+	c.mu.Lock()
+	if now.After(c.nextSweep) {
+		for k, r := range c.results {
+			if now.After(r.expires) {
+				delete(c.results, k)
+			}
+		}
+		c.nextSweep = now.Add(sweepInterval)
+	}
+	result, ok := c.results[key]
+	c.mu.Unlock()
+	if ok && now.Before(result.expires) {
+		return result.revoked, nil
+	}
+
+	revoked, err := c.next.Revoked(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.results[key] = cachedResult{revoked: revoked, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return revoked, nil
+}
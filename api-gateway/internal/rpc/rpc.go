@@ -0,0 +1,89 @@
+// Package rpc calls the upstream services over gRPC. A Transport plugs into
+// the upstream HTTP client in place of the network: it transcodes each
+// REST request to a call of the generated gRPC client, using the HTTP
+// annotations of the service's proto, and the response back to JSON. The
+// route table, the route middleware and the breakers work the same
+// whichever protocol an upstream is reached with.
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cartv1 "github.com/nutcase/shop-ecommerce/proto/cart/v1"
+	identityv1 "github.com/nutcase/shop-ecommerce/proto/identity/v1"
+	orderv1 "github.com/nutcase/shop-ecommerce/proto/order/v1"
+	productv1 "github.com/nutcase/shop-ecommerce/proto/product/v1"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// register mounts the REST routes of each upstream with a gRPC API on a
+// transcoding mux, backed by its generated client.
+var register = map[string]func(*runtime.ServeMux, *grpc.ClientConn) error{
+	"identity": func(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		return identityv1.RegisterIdentityServiceHandlerClient(context.Background(), mux, identityv1.NewIdentityServiceClient(conn))
+	},
+	"product": func(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		return productv1.RegisterProductServiceHandlerClient(context.Background(), mux, productv1.NewProductServiceClient(conn))
+	},
+	"cart": func(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		return cartv1.RegisterCartServiceHandlerClient(context.Background(), mux, cartv1.NewCartServiceClient(conn))
+	},
+	"order": func(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		return orderv1.RegisterOrderServiceHandlerClient(context.Background(), mux, orderv1.NewOrderServiceClient(conn))
+	},
+}
+
+// Dial returns a connection to the gRPC server of an upstream at addr.
+// Calls carry the trace context of the request, and calls made without a
+// deadline get timeout.
+func Dial(addr string, timeout time.Duration) (*grpc.ClientConn, error) {
+	return grpc.NewClient(addr,
+		// Traffic inside the cluster is plain text, as with the HTTP upstreams
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithUnaryInterceptor(deadline(timeout)),
+	)
+}
+
+func deadline(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewTransport returns a Transport that sends the requests for the named
+// upstream to its gRPC server at addr.
+func NewTransport(name, addr string, timeout time.Duration) (*Transport, error) {
+	registerService, ok := register[name]
+	if !ok {
+		return nil, fmt.Errorf("upstream %s has no gRPC API", name)
+	}
+	conn, err := Dial(addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", name, err)
+	}
+
+	mux := newTranscoder(name)
+	if err := registerService(mux, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upstream %s: %w", name, err)
+	}
+	return &Transport{name: name, conn: conn, handler: mux}, nil
+}
+
+// HasAPI reports whether the named upstream has a gRPC API.
+func HasAPI(name string) bool {
+	_, ok := register[name]
+	return ok
+}
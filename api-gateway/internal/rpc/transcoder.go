@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpCodeHeader is the response metadata in which a service names the
+// HTTP status of a successful call, when its REST route does not answer
+// with 200.
+const httpCodeHeader = "x-http-code"
+
+// problemCodes maps gRPC status codes to the problem codes the services
+// use for the same errors over HTTP.
+var problemCodes = map[codes.Code]string{
+	codes.InvalidArgument:    problem.CodeValidation,
+	codes.NotFound:           problem.CodeNotFound,
+	codes.AlreadyExists:      problem.CodeConflict,
+	codes.Aborted:            problem.CodeConflict,
+	codes.FailedPrecondition: problem.CodeConflict,
+	codes.Unauthenticated:    problem.CodeUnauthorized,
+	codes.PermissionDenied:   problem.CodeForbidden,
+	codes.ResourceExhausted:  problem.CodeRateLimited,
+}
+
+// newTranscoder returns the mux that turns REST requests for the named
+// upstream into gRPC calls. Responses keep the JSON the services write:
+// snake_case names, zero values included and problem documents for errors.
+func newTranscoder(name string) *runtime.ServeMux {
+	return runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:   true,
+				EmitUnpopulated: true,
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{
+				// The HTTP handlers ignore unknown fields too
+				DiscardUnknown: true,
+			},
+		}),
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		// Response metadata is for the gateway, not the client
+		runtime.WithOutgoingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithOutgoingTrailerMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithForwardResponseOption(forwardStatus),
+		runtime.WithErrorHandler(func(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+			writeError(name, w, r, err)
+		}),
+		runtime.WithRoutingErrorHandler(func(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, code int) {
+			if code == http.StatusMethodNotAllowed {
+				problem.Error(w, r, code, problem.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+				return
+			}
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "No "+name+" gRPC method matches "+r.URL.Path)
+		}),
+	)
+}
+
+// incomingHeader forwards the request ID along with the headers forwarded
+// by default; the trace context is propagated by the client itself.
+func incomingHeader(key string) (string, bool) {
+	if key == "X-Request-Id" {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// forwardStatus writes the status the service asked for in its response
+// metadata.
+func forwardStatus(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.HeaderMD.Get(httpCodeHeader)
+	if len(values) == 0 {
+		return nil
+	}
+	if code, err := strconv.Atoi(values[0]); err == nil {
+		w.WriteHeader(code)
+	}
+	return nil
+}
+
+// writeError writes the problem document for a failed call. A call that
+// never reached the service is handed back to the upstream client as a
+// transport error instead, so it is retried and reported like a failed
+// HTTP request.
+func writeError(name string, w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	if rec, ok := w.(*recorder); ok {
+		switch st.Code() {
+		case codes.Unavailable:
+			rec.err = fmt.Errorf("%s: %s", name, st.Message())
+			return
+		case codes.DeadlineExceeded:
+			rec.err = fmt.Errorf("%s: %s: %w", name, st.Message(), context.DeadlineExceeded)
+			return
+		case codes.Canceled:
+			rec.err = fmt.Errorf("%s: %s: %w", name, st.Message(), context.Canceled)
+			return
+		}
+	}
+
+	code, ok := problemCodes[st.Code()]
+	if !ok {
+		code = problem.CodeInternal
+	}
+	p := problem.New(runtime.HTTPStatusFromCode(st.Code()), code, st.Message())
+	if st.Code() == codes.InvalidArgument {
+		p.Errors = fieldErrors(st)
+		if len(p.Errors) == 0 {
+			// The services report invalid fields with details, so this is
+			// a body or parameter the transcoder could not parse
+			p = problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		}
+	}
+	problem.Write(w, r, p)
+}
+
+// fieldErrors returns the invalid fields listed in the details of st.
+func fieldErrors(st *status.Status) []problem.FieldError {
+	var errs []problem.FieldError
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, violation := range badRequest.GetFieldViolations() {
+			errs = append(errs, problem.FieldError{
+				Field:   violation.GetField(),
+				Code:    violation.GetReason(),
+				Message: violation.GetDescription(),
+			})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// Transport is an http.RoundTripper that serves requests for one upstream
+// by calling its gRPC server.
+type Transport struct {
+	name    string
+	conn    *grpc.ClientConn
+	handler http.Handler
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &recorder{header: make(http.Header)}
+	t.handler.ServeHTTP(rec, req)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if rec.err != nil {
+		return nil, rec.err
+	}
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        http.StatusText(rec.status),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// Close closes the connection to the gRPC server.
+func (t *Transport) Close() error {
+	return t.conn.Close()
+}
+
+// recorder buffers the transcoded response.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// err is set instead of a response when the call failed in transit
+	err error
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status == http.StatusNoContent || r.status == http.StatusNotModified {
+		return 0, http.ErrBodyNotAllowed
+	}
+	return r.body.Write(p)
+}
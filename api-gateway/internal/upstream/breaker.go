@@ -0,0 +1,192 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Outcome is how a request let through by a breaker ended.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Abandoned requests, such as those cancelled by the caller, say
+	// nothing about the upstream: they free their slot without counting
+	// towards the failure rate or the half-open probes
+	Abandoned
+)
+
+// OpenError is returned when a request is rejected by an open breaker.
+type OpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", e.Upstream)
+}
+
+// Breaker is a failure-rate circuit breaker. While closed it counts
+// outcomes over a fixed window and opens once at least MinRequests were
+// seen and the failure rate reaches FailureRate. After ProbeInterval an open
+// breaker lets HalfOpenProbes requests through; it closes if they all
+// succeed and reopens on the first failure.
+type Breaker struct {
+	name          string
+	cfg           config.BreakerConfig
+	onStateChange func(ctx context.Context, from, to State)
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	requests   int
+	failures   int
+	inFlight   int
+	expiry     time.Time
+}
+
+func NewBreaker(name string, cfg config.BreakerConfig, onStateChange func(ctx context.Context, from, to State)) *Breaker {
+	b := &Breaker{
+		name:          name,
+		cfg:           cfg,
+		onStateChange: onStateChange,
+	}
+	b.toState(context.Background(), StateClosed, time.Now())
+	return b
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(context.Background(), time.Now())
+	return b.state
+}
+
+// Allow reports whether a request may proceed. On success the caller must
+// pass the outcome of the request to done.
+func (b *Breaker) Allow(ctx context.Context) (done func(Outcome), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.advance(ctx, now)
+
+	switch b.state {
+	case StateOpen:
+		return nil, &OpenError{Upstream: b.name, RetryAfter: b.expiry.Sub(now)}
+	case StateHalfOpen:
+		if b.inFlight >= b.cfg.HalfOpenProbes {
+			return nil, &OpenError{Upstream: b.name, RetryAfter: b.cfg.ProbeInterval}
+		}
+	}
+
+	b.inFlight++
+	generation := b.generation
+	return func(outcome Outcome) {
+		b.record(ctx, generation, outcome)
+	}, nil
+}
+
+func (b *Breaker) record(ctx context.Context, generation uint64, outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.advance(ctx, now)
+	if generation != b.generation {
+		return
+	}
+
+	b.inFlight--
+	if outcome == Abandoned {
+		return
+	}
+	b.requests++
+	if outcome == Failure {
+		b.failures++
+	}
+
+	switch b.state {
+	case StateClosed:
+		if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.FailureRate {
+			b.toState(ctx, StateOpen, now)
+		}
+	case StateHalfOpen:
+		if outcome == Failure {
+			b.toState(ctx, StateOpen, now)
+		} else if b.requests >= b.cfg.HalfOpenProbes {
+			b.toState(ctx, StateClosed, now)
+		}
+	}
+}
+
+// advance applies the time-based transitions: resetting the closed window
+// and moving from open to half-open once the probe interval has elapsed.
+func (b *Breaker) advance(ctx context.Context, now time.Time) {
+	switch b.state {
+	case StateClosed:
+		if !b.expiry.IsZero() && now.After(b.expiry) {
+			b.newGeneration(now)
+		}
+	case StateOpen:
+		if now.After(b.expiry) {
+			b.toState(ctx, StateHalfOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) toState(ctx context.Context, state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.newGeneration(now)
+
+	if from != state && b.onStateChange != nil {
+		b.onStateChange(ctx, from, state)
+	}
+}
+
+func (b *Breaker) newGeneration(now time.Time) {
+	b.generation++
+	b.requests = 0
+	b.failures = 0
+	b.inFlight = 0
+
+	switch b.state {
+	case StateClosed:
+		b.expiry = time.Time{}
+		if b.cfg.Window > 0 {
+			b.expiry = now.Add(b.cfg.Window)
+		}
+	case StateOpen:
+		b.expiry = now.Add(b.cfg.ProbeInterval)
+	case StateHalfOpen:
+		b.expiry = time.Time{}
+	}
+}
@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+)
+
+const testProbeInterval = 20 * time.Millisecond
+
+func newTestBreaker(transitions *[]string) *Breaker {
+	return NewBreaker("test", config.BreakerConfig{
+		FailureRate:    0.5,
+		MinRequests:    4,
+		Window:         time.Minute,
+		ProbeInterval:  testProbeInterval,
+		HalfOpenProbes: 2,
+	}, func(_ context.Context, from, to State) {
+		*transitions = append(*transitions, from.String()+"->"+to.String())
+	})
+}
+
+// send passes a request with the given outcome through b.
+func send(t *testing.T, b *Breaker, outcome Outcome) {
+	t.Helper()
+	done, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("request rejected in state %s: %v", b.State(), err)
+	}
+	done(outcome)
+}
+
+func expectState(t *testing.T, b *Breaker, want State) {
+	t.Helper()
+	if got := b.State(); got != want {
+		t.Fatalf("got state %s, want %s", got, want)
+	}
+}
+
+func expectRejected(t *testing.T, b *Breaker) {
+	t.Helper()
+	_, err := b.Allow(context.Background())
+	var openErr *OpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("got %v, want an *OpenError", err)
+	}
+	if openErr.RetryAfter <= 0 || openErr.RetryAfter > testProbeInterval {
+		t.Errorf("got Retry-After %v", openErr.RetryAfter)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	var transitions []string
+	b := newTestBreaker(&transitions)
+	expectState(t, b, StateClosed)
+
+	// Failures under MinRequests leave the breaker closed
+	send(t, b, Failure)
+	send(t, b, Success)
+	send(t, b, Failure)
+	expectState(t, b, StateClosed)
+	// The fourth request is enough to judge the failure rate of 0.5
+	send(t, b, Success)
+	expectState(t, b, StateOpen)
+	expectRejected(t, b)
+
+	time.Sleep(testProbeInterval + 5*time.Millisecond)
+	expectState(t, b, StateHalfOpen)
+	// Only HalfOpenProbes requests are let through at once
+	probe1, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe2, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, b)
+	probe1(Success)
+	expectState(t, b, StateHalfOpen)
+	probe2(Success)
+	expectState(t, b, StateClosed)
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("got transitions %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	var transitions []string
+	b := newTestBreaker(&transitions)
+	for range 4 {
+		send(t, b, Failure)
+	}
+	expectState(t, b, StateOpen)
+
+	time.Sleep(testProbeInterval + 5*time.Millisecond)
+	expectState(t, b, StateHalfOpen)
+	send(t, b, Success)
+	send(t, b, Failure)
+	expectState(t, b, StateOpen)
+	expectRejected(t, b)
+}
+
+func TestBreakerIgnoresOutcomesOfEarlierStates(t *testing.T) {
+	var transitions []string
+	b := newTestBreaker(&transitions)
+	// Sent while closed, finishing once the breaker has opened
+	late, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		send(t, b, Failure)
+	}
+	expectState(t, b, StateOpen)
+
+	time.Sleep(testProbeInterval + 5*time.Millisecond)
+	expectState(t, b, StateHalfOpen)
+	late(Failure)
+	expectState(t, b, StateHalfOpen)
+}
+
+func TestBreakerAbandonedRequestsDoNotCount(t *testing.T) {
+	var transitions []string
+	b := newTestBreaker(&transitions)
+	send(t, b, Failure)
+	for range 5 {
+		send(t, b, Abandoned)
+	}
+	send(t, b, Success)
+	send(t, b, Failure)
+	// Counted as successes, the abandoned requests would keep it closed
+	expectState(t, b, StateClosed)
+	send(t, b, Success)
+	expectState(t, b, StateOpen)
+}
+
+func TestBreakerAbandonedProbeFreesItsSlot(t *testing.T) {
+	var transitions []string
+	b := newTestBreaker(&transitions)
+	for range 4 {
+		send(t, b, Failure)
+	}
+	time.Sleep(testProbeInterval + 5*time.Millisecond)
+	expectState(t, b, StateHalfOpen)
+
+	for range 3 {
+		send(t, b, Abandoned)
+	}
+	// Neither closed by the abandoned probes nor short of probe slots
+	expectState(t, b, StateHalfOpen)
+	send(t, b, Success)
+	expectState(t, b, StateHalfOpen)
+	send(t, b, Success)
+	expectState(t, b, StateClosed)
+}
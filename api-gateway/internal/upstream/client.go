@@ -0,0 +1,359 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/accesslog"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/rpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Client is a pooled HTTP client for a single upstream service. It retries
+// idempotent requests that fail with a network error or a 502/503/504,
+// backing off with full jitter between attempts, and sends every attempt
+// through the upstream's circuit breaker. When the upstream has a pool of
+// instances each attempt goes to the instance the pool picks, a retry to
+// a different one if possible.
+type Client struct {
+	name    string
+	cfg     config.UpstreamClientConfig
+	http    *http.Client
+	breaker *Breaker
+	// pool is nil when requests go to the host of the upstream's URL
+	pool   *Pool
+	logger *zap.SugaredLogger
+
+	// httpTransport and grpcTransport hold the connections Close closes;
+	// grpcTransport is nil when requests are sent over HTTP
+	httpTransport *http.Transport
+	grpcTransport *rpc.Transport
+
+	mu sync.Mutex
+	// stop ends the background work started by Start
+	stop   context.CancelFunc
+	closed bool
+}
+
+func NewClient(name string, cfg config.UpstreamClientConfig, logger *zap.SugaredLogger) *Client {
+	httpTransport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.DialTimeout,
+		ResponseHeaderTimeout: cfg.ResponseTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	transport := newTracedTransport(name, httpTransport)
+	var grpcTransport *rpc.Transport
+	if cfg.GRPCAddr != "" {
+		var err error
+		grpcTransport, err = rpc.NewTransport(name, cfg.GRPCAddr, cfg.ResponseTimeout)
+		if err != nil {
+			logger.Errorw("Failed to set up gRPC client, falling back to HTTP", "upstream", name, "error", err)
+			grpcTransport = nil
+		} else {
+			transport = grpcTransport
+			logger.Infow("Calling upstream over gRPC", "upstream", name, "addr", cfg.GRPCAddr)
+		}
+	}
+
+	c := &Client{
+		name:          name,
+		cfg:           cfg,
+		http:          &http.Client{Transport: transport},
+		logger:        logger,
+		httpTransport: httpTransport,
+		grpcTransport: grpcTransport,
+	}
+	c.breaker = NewBreaker(name, cfg.Breaker, c.logStateChange)
+	switch {
+	case !cfg.Pool.Enabled():
+	case grpcTransport != nil:
+		// The gRPC client connects to GRPCAddr whatever the request's host
+		logger.Warnw("Upstream instances are not used over gRPC", "upstream", name)
+	default:
+		c.pool = NewPool(name, cfg.Pool, logger)
+	}
+	return c
+}
+
+// newTracedTransport records every attempt sent through t as a client
+// span of the request's trace, and sends the trace context along with it.
+// The gRPC transport is traced by its own client instead.
+func newTracedTransport(name string, t http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(t,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + name
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("peer.service", name))),
+	)
+}
+
+// NewClients builds a client for every upstream in cfg.
+func NewClients(cfg *config.Config, logger *zap.SugaredLogger) map[string]*Client {
+	clients := make(map[string]*Client, len(cfg.UpstreamClients))
+	for name, clientCfg := range cfg.UpstreamClients {
+		clients[name] = NewClient(name, clientCfg, logger)
+	}
+	return clients
+}
+
+// Name returns the upstream name the client was built for.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// BreakerState returns the state of the upstream's circuit breaker.
+func (c *Client) BreakerState() State {
+	return c.breaker.State()
+}
+
+// Pool returns the upstream's pool of instances, or nil if it has none.
+func (c *Client) Pool() *Pool {
+	return c.pool
+}
+
+// Config returns the settings the client was built with.
+func (c *Client) Config() config.UpstreamClientConfig {
+	return c.cfg
+}
+
+// Start runs the background work of the upstream's pool, if it has one,
+// until ctx is cancelled or the client is closed. Only the first call has
+// an effect.
+func (c *Client) Start(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil || c.closed {
+		return
+	}
+	ctx, c.stop = context.WithCancel(ctx)
+	if c.pool != nil {
+		c.pool.Start(ctx)
+	}
+}
+
+// Close stops the background work of the client and closes its idle
+// connections, and its gRPC connection if it has one. Requests still in
+// flight over gRPC fail, so a client should be closed once nothing sends
+// through it anymore.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.stop != nil {
+		c.stop()
+	}
+	c.httpTransport.CloseIdleConnections()
+	if c.grpcTransport != nil {
+		return c.grpcTransport.Close()
+	}
+	return nil
+}
+
+// Do sends req, retrying it when that is safe to do. It returns an
+// *OpenError without contacting the upstream while the breaker is open.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req.Method) || c.cfg.MaxRetries <= 0 {
+		resp, _, err := c.send(req, nil)
+		return resp, err
+	}
+	if !c.prepareReplay(req) {
+		resp, _, err := c.send(req, nil)
+		return resp, err
+	}
+
+	var instance *Instance
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		var err error
+		resp, instance, err = c.send(req, instance)
+		var openErr *OpenError
+		var noInstanceErr *NoInstanceError
+		if attempt >= c.cfg.MaxRetries || errors.As(err, &openErr) || errors.As(err, &noInstanceErr) || !shouldRetry(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		upstreamRetries.WithLabelValues(c.name).Inc()
+		wait := c.backoff(attempt)
+		c.logger.Warnw("Retrying upstream request",
+			"upstream", c.name,
+			"method", req.Method,
+			"url", req.URL.String(),
+			"attempt", attempt+1,
+			"backoff", wait,
+			"error", err,
+		)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// send performs a single attempt through the circuit breaker, at an
+// instance other than previous if the upstream has a pool. It returns the
+// instance the attempt went to.
+func (c *Client) send(req *http.Request, previous *Instance) (*http.Response, *Instance, error) {
+	var instance *Instance
+	if c.pool != nil {
+		var err error
+		instance, err = c.pool.Pick(previous)
+		if err != nil {
+			upstreamErrors.WithLabelValues(c.name, errorNoInstance).Inc()
+			return nil, nil, err
+		}
+		req.URL.Host = instance.Addr()
+	}
+
+	done, err := c.breaker.Allow(req.Context())
+	if err != nil {
+		if instance != nil {
+			c.pool.Cancel(instance)
+		}
+		trace.SpanFromContext(req.Context()).AddEvent("circuit_breaker.rejected", trace.WithAttributes(
+			attribute.String("upstream", c.name),
+		))
+		upstreamErrors.WithLabelValues(c.name, errorCircuitOpen).Inc()
+		return nil, instance, err
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	elapsed := time.Since(start)
+	c.observe(req, resp, err, elapsed)
+	accesslog.ObserveUpstream(req.Context(), elapsed)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		// A request cancelled by the client says nothing about the upstream.
+		done(Abandoned)
+		if instance != nil {
+			c.pool.Cancel(instance)
+		}
+	case err != nil:
+		done(Failure)
+		if instance != nil {
+			c.pool.Done(instance, false)
+		}
+	default:
+		success := resp.StatusCode < http.StatusInternalServerError
+		outcome := Success
+		if !success {
+			outcome = Failure
+		}
+		done(outcome)
+		if instance != nil {
+			c.pool.Done(instance, success)
+		}
+	}
+	return resp, instance, err
+}
+
+func (c *Client) logStateChange(ctx context.Context, from, to State) {
+	c.logger.Warnw("Circuit breaker state changed",
+		"upstream", c.name,
+		"from", from.String(),
+		"to", to.String(),
+	)
+	trace.SpanFromContext(ctx).AddEvent("circuit_breaker.state_change", trace.WithAttributes(
+		attribute.String("upstream", c.name),
+		attribute.String("from", from.String()),
+		attribute.String("to", to.String()),
+	))
+}
+
+// prepareReplay makes the request body readable more than once. Bodies
+// larger than MaxRetryBodyBytes are streamed instead and the request is
+// not retried.
+func (c *Client) prepareReplay(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, c.cfg.MaxRetryBodyBytes+1))
+	if err != nil || int64(len(buf)) > c.cfg.MaxRetryBodyBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return false
+	}
+
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true
+}
+
+// backoff returns a random delay in [0, min(RetryMaxBackoff, RetryBackoff*2^attempt)).
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.cfg.RetryBackoff << attempt
+	if ceiling <= 0 || ceiling > c.cfg.RetryMaxBackoff {
+		ceiling = c.cfg.RetryMaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+func newRetryingClient(breaker config.BreakerConfig) *Client {
+	return NewClient("test", config.UpstreamClientConfig{
+		DialTimeout:       time.Second,
+		ResponseTimeout:   time.Second,
+		MaxRetries:        2,
+		RetryBackoff:      time.Millisecond,
+		RetryMaxBackoff:   2 * time.Millisecond,
+		MaxRetryBodyBytes: 1024,
+		Breaker:           breaker,
+	}, zap.NewNop().Sugar())
+}
+
+// lenientBreaker never opens during a test.
+var lenientBreaker = config.BreakerConfig{
+	FailureRate:    1,
+	MinRequests:    1000,
+	ProbeInterval:  time.Second,
+	HalfOpenProbes: 1,
+}
+
+func TestClientRetriesIdempotentMethods(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		attempts int32
+	}{
+		{http.MethodGet, http.StatusServiceUnavailable, 3},
+		{http.MethodHead, http.StatusBadGateway, 3},
+		{http.MethodPut, http.StatusGatewayTimeout, 3},
+		{http.MethodDelete, http.StatusServiceUnavailable, 3},
+		{http.MethodPost, http.StatusServiceUnavailable, 1},
+		{http.MethodPatch, http.StatusServiceUnavailable, 1},
+		// Errors of the upstream's own are not retried
+		{http.MethodGet, http.StatusInternalServerError, 1},
+		{http.MethodGet, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+http.StatusText(tt.status), func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				// Every attempt carries the whole body
+				if body, _ := io.ReadAll(r.Body); r.Method != http.MethodGet && r.Method != http.MethodHead && string(body) != `{"n":1}` {
+					t.Errorf("attempt %d got body %q", attempts.Load(), body)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			client := newRetryingClient(lenientBreaker)
+
+			var body io.Reader
+			if tt.method != http.MethodGet && tt.method != http.MethodHead {
+				body = strings.NewReader(`{"n":1}`)
+			}
+			req, err := http.NewRequest(tt.method, srv.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("got %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestClientDoesNotRetryLargeBodies(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if body, _ := io.ReadAll(r.Body); len(body) != 2048 {
+			t.Errorf("got %d bytes of body, want 2048", len(body))
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := newRetryingClient(lenientBreaker)
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(strings.Repeat("x", 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hides the length so the body must be read to be replayed
+	req.GetBody = nil
+	req.Body = io.NopCloser(req.Body)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestClientOpenBreakerSkipsUpstream(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	client := newRetryingClient(config.BreakerConfig{
+		FailureRate:    0.5,
+		MinRequests:    2,
+		ProbeInterval:  time.Minute,
+		HalfOpenProbes: 1,
+	})
+
+	for i := range 3 {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if i < 2 {
+			if err != nil {
+				t.Fatalf("request %d: %v", i, err)
+			}
+			resp.Body.Close()
+			continue
+		}
+		var openErr *OpenError
+		if !errors.As(err, &openErr) {
+			t.Fatalf("got %v, want an *OpenError", err)
+		}
+	}
+	if client.BreakerState() != StateOpen {
+		t.Errorf("got breaker state %s", client.BreakerState())
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestClientCancelledProbeKeepsBreakerHalfOpen(t *testing.T) {
+	received := make(chan struct{}, 1)
+	var fail atomic.Bool
+	fail.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received <- struct{}{}
+		// Never answers before the caller gives up
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	client := newRetryingClient(config.BreakerConfig{
+		FailureRate:    0.5,
+		MinRequests:    1,
+		ProbeInterval:  20 * time.Millisecond,
+		HalfOpenProbes: 1,
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if client.BreakerState() != StateOpen {
+		t.Fatalf("got breaker state %s, want open", client.BreakerState())
+	}
+	time.Sleep(25 * time.Millisecond)
+	fail.Store(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want the cancellation", err)
+	}
+	// The upstream never answered the probe, so nothing is known yet
+	if client.BreakerState() != StateHalfOpen {
+		t.Errorf("got breaker state %s, want half-open", client.BreakerState())
+	}
+}
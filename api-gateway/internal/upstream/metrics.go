@@ -0,0 +1,68 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons an upstream attempt is counted as an error.
+const (
+	errorCircuitOpen = "circuit_open"
+	errorTimeout     = "timeout"
+	errorTransport   = "transport"
+	errorServer      = "server_error"
+	errorNoInstance  = "no_instance"
+)
+
+var (
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_upstream_request_duration_seconds",
+		Help:    "Time taken by each attempt to an upstream service, by upstream, method and status (\"error\" when no response was received).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "method", "status"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_errors_total",
+		Help: "Failed upstream attempts, by upstream and reason: circuit_open, no_instance, timeout, transport or server_error (a 5xx response).",
+	}, []string{"upstream", "reason"})
+
+	upstreamEjections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_instance_ejections_total",
+		Help: "Upstream instances taken out of rotation, by upstream and reason: health_check, consecutive_failures or failure_rate.",
+	}, []string{"upstream", "reason"})
+
+	upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_retries_total",
+		Help: "Upstream requests retried, by upstream.",
+	}, []string{"upstream"})
+)
+
+// observe records the outcome of a single attempt at req that took d.
+func (c *Client) observe(req *http.Request, resp *http.Response, err error, d time.Duration) {
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamDuration.WithLabelValues(c.name, req.Method, status).Observe(d.Seconds())
+
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		// Cancelled by the client; says nothing about the upstream.
+	case err != nil:
+		reason := errorTransport
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			reason = errorTimeout
+		}
+		upstreamErrors.WithLabelValues(c.name, reason).Inc()
+	case resp.StatusCode >= http.StatusInternalServerError:
+		upstreamErrors.WithLabelValues(c.name, errorServer).Inc()
+	}
+}
@@ -0,0 +1,546 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// Reasons an instance is taken out of rotation.
+const (
+	ejectHealthCheck         = "health_check"
+	ejectConsecutiveFailures = "consecutive_failures"
+	ejectFailureRate         = "failure_rate"
+)
+
+// NoInstanceError is returned when every instance of an upstream is
+// unhealthy or ejected, or none were discovered.
+type NoInstanceError struct {
+	Upstream string
+}
+
+func (e *NoInstanceError) Error() string {
+	return fmt.Sprintf("no available instance of %s", e.Upstream)
+}
+
+// Instance is an instance of an upstream in a pool. Its fields are
+// guarded by the pool's mutex.
+type Instance struct {
+	addr   string
+	weight int
+
+	// healthy is the verdict of the active health checks; instances are
+	// healthy until checked
+	healthy        bool
+	checkStreak    int
+	lastCheck      time.Time
+	lastCheckError string
+
+	// ejectedUntil is set by outlier detection
+	ejectedUntil time.Time
+	// ejectionStreak counts the ejections since the instance last went an
+	// interval without failing; it scales the ejection time
+	ejectionStreak      int
+	ejections           int
+	consecutiveFailures int
+	intervalRequests    int
+	intervalFailures    int
+
+	outstanding   int
+	requests      uint64
+	failures      uint64
+	currentWeight int
+}
+
+// Addr returns the host:port of the instance.
+func (i *Instance) Addr() string {
+	return i.addr
+}
+
+func (i *Instance) available(now time.Time) bool {
+	return i.healthy && !now.Before(i.ejectedUntil)
+}
+
+// Pool is the set of instances of an upstream and picks the one each
+// request is sent to. Static instances are fixed; DNS and SRV names are
+// looked up again every RefreshInterval, keeping the state of the
+// instances that remain. Instances failing their health checks, and those
+// outlier detection finds failing real requests, are left out until they
+// recover.
+type Pool struct {
+	name   string
+	cfg    config.PoolConfig
+	logger *zap.SugaredLogger
+	probe  *http.Client
+
+	mu        sync.Mutex
+	instances []*Instance
+	next      int
+	resolved  time.Time
+	// resolveError is the error of the last lookup, if it failed
+	resolveError string
+}
+
+func NewPool(name string, cfg config.PoolConfig, logger *zap.SugaredLogger) *Pool {
+	p := &Pool{
+		name:   name,
+		cfg:    cfg,
+		logger: logger,
+		probe:  &http.Client{Timeout: cfg.HealthCheck.Timeout},
+	}
+	if cfg.Discovery == config.DiscoveryStatic {
+		for _, instance := range cfg.Instances {
+			p.instances = append(p.instances, &Instance{addr: instance.Addr, weight: instance.Weight, healthy: true})
+		}
+		p.resolved = time.Now()
+	}
+	return p
+}
+
+// Start looks up the instances of a DNS-discovered pool and runs the
+// lookups, health checks and outlier detection in the background until
+// ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	if p.cfg.Discovery != config.DiscoveryStatic {
+		p.resolve(ctx)
+		go p.every(ctx, p.cfg.RefreshInterval, p.resolve)
+	}
+	if p.cfg.HealthCheck.Interval > 0 {
+		go p.every(ctx, p.cfg.HealthCheck.Interval, p.checkHealth)
+	}
+	if p.cfg.Outlier.FailureRate > 0 || p.cfg.Outlier.ConsecutiveFailures > 0 {
+		go p.every(ctx, p.cfg.Outlier.Interval, func(context.Context) { p.endInterval(time.Now()) })
+	}
+}
+
+func (p *Pool) every(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// Pick returns the instance to send a request to, other than avoid when
+// another one is available, and counts the request as outstanding on it.
+// The caller must pass the instance to Done or Cancel.
+func (p *Pool) Pick(avoid *Instance) (*Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	candidates := make([]*Instance, 0, len(p.instances))
+	for _, instance := range p.instances {
+		if instance.available(now) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) > 1 && avoid != nil {
+		if i := slices.Index(candidates, avoid); i >= 0 {
+			candidates = slices.Delete(candidates, i, i+1)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, &NoInstanceError{Upstream: p.name}
+	}
+
+	var picked *Instance
+	switch p.cfg.Strategy {
+	case config.BalanceLeastOutstanding:
+		picked = p.leastOutstanding(candidates)
+	case config.BalanceWeighted:
+		picked = p.weighted(candidates)
+	default:
+		picked = p.roundRobin(candidates)
+	}
+	picked.outstanding++
+	picked.requests++
+	return picked, nil
+}
+
+func (p *Pool) roundRobin(candidates []*Instance) *Instance {
+	p.next++
+	return candidates[p.next%len(candidates)]
+}
+
+// leastOutstanding picks the instance with the fewest requests in flight,
+// starting the scan at the next instance in turn so that ties are spread.
+func (p *Pool) leastOutstanding(candidates []*Instance) *Instance {
+	p.next++
+	var picked *Instance
+	for i := range candidates {
+		instance := candidates[(p.next+i)%len(candidates)]
+		if picked == nil || instance.outstanding < picked.outstanding {
+			picked = instance
+		}
+	}
+	return picked
+}
+
+// weighted is the smooth weighted round-robin of nginx: every instance
+// gains its weight on each pick and the one with the most is picked and
+// loses the total. Instances of weight zero only get requests when all
+// weights are zero.
+func (p *Pool) weighted(candidates []*Instance) *Instance {
+	total := 0
+	var picked *Instance
+	for _, instance := range candidates {
+		instance.currentWeight += instance.weight
+		total += instance.weight
+		if picked == nil || instance.currentWeight > picked.currentWeight {
+			picked = instance
+		}
+	}
+	if total == 0 {
+		return p.roundRobin(candidates)
+	}
+	picked.currentWeight -= total
+	return picked
+}
+
+// Cancel ends a request that got no outcome: it was never sent or was
+// cancelled by the client.
+func (p *Pool) Cancel(instance *Instance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	instance.outstanding--
+}
+
+// Done ends a request to instance and records its outcome for outlier
+// detection.
+func (p *Pool) Done(instance *Instance, success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instance.outstanding--
+	instance.intervalRequests++
+	if success {
+		instance.consecutiveFailures = 0
+		return
+	}
+	instance.failures++
+	instance.intervalFailures++
+	instance.consecutiveFailures++
+
+	threshold := p.cfg.Outlier.ConsecutiveFailures
+	if threshold > 0 && instance.consecutiveFailures >= threshold {
+		p.eject(instance, ejectConsecutiveFailures, time.Now())
+	}
+}
+
+// endInterval ejects the instances whose failure rate over the interval
+// reached the threshold and starts a new interval.
+func (p *Pool) endInterval(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	outlier := p.cfg.Outlier
+	for _, instance := range p.instances {
+		failing := outlier.FailureRate > 0 && instance.intervalRequests >= outlier.MinRequests &&
+			float64(instance.intervalFailures)/float64(instance.intervalRequests) >= outlier.FailureRate
+		switch {
+		case failing:
+			p.eject(instance, ejectFailureRate, now)
+		case instance.intervalFailures == 0 && !now.Before(instance.ejectedUntil) && instance.ejectionStreak > 0:
+			instance.ejectionStreak--
+		}
+		instance.intervalRequests = 0
+		instance.intervalFailures = 0
+	}
+}
+
+// eject takes instance out of rotation for the ejection time, unless that
+// would eject more than MaxEjectionPercent of the instances.
+func (p *Pool) eject(instance *Instance, reason string, now time.Time) {
+	if now.Before(instance.ejectedUntil) {
+		return
+	}
+	ejected := 1
+	for _, other := range p.instances {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if ejected*100 > len(p.instances)*p.cfg.Outlier.MaxEjectionPercent {
+		return
+	}
+
+	instance.ejectionStreak++
+	instance.ejections++
+	instance.consecutiveFailures = 0
+	duration := p.cfg.Outlier.EjectionTime * time.Duration(instance.ejectionStreak)
+	if limit := p.cfg.Outlier.MaxEjectionTime; limit > 0 && duration > limit {
+		duration = limit
+	}
+	instance.ejectedUntil = now.Add(duration)
+
+	upstreamEjections.WithLabelValues(p.name, reason).Inc()
+	p.logger.Warnw("Ejected upstream instance",
+		"upstream", p.name,
+		"instance", instance.addr,
+		"reason", reason,
+		"duration", duration,
+	)
+}
+
+// checkHealth probes every instance concurrently.
+func (p *Pool) checkHealth(ctx context.Context) {
+	p.mu.Lock()
+	instances := slices.Clone(p.instances)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.recordCheck(instance, p.check(ctx, instance))
+		}()
+	}
+	wg.Wait()
+}
+
+// check probes the health endpoint of instance, which must answer 200.
+func (p *Pool) check(ctx context.Context, instance *Instance) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Scheme+"://"+instance.addr+p.cfg.HealthCheck.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.probe.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordCheck updates instance with the result of a health check. A
+// streak of UnhealthyThreshold failures takes it out of rotation and one
+// of HealthyThreshold successes puts it back.
+func (p *Pool) recordCheck(instance *Instance, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instance.lastCheck = time.Now()
+	instance.lastCheckError = ""
+	if err != nil {
+		instance.lastCheckError = err.Error()
+	}
+
+	// checkStreak counts the checks in a row that disagree with the
+	// current verdict
+	if (err == nil) == instance.healthy {
+		instance.checkStreak = 0
+		return
+	}
+	instance.checkStreak++
+
+	switch {
+	case instance.healthy && instance.checkStreak >= p.cfg.HealthCheck.UnhealthyThreshold:
+		instance.healthy = false
+		instance.checkStreak = 0
+		upstreamEjections.WithLabelValues(p.name, ejectHealthCheck).Inc()
+		p.logger.Warnw("Upstream instance failed its health checks", "upstream", p.name, "instance", instance.addr, "error", err)
+	case !instance.healthy && instance.checkStreak >= p.cfg.HealthCheck.HealthyThreshold:
+		instance.healthy = true
+		instance.checkStreak = 0
+		p.logger.Infow("Upstream instance passed its health checks", "upstream", p.name, "instance", instance.addr)
+	}
+}
+
+// resolve looks up the instances of a DNS-discovered pool. On failure the
+// instances found last time are kept.
+func (p *Pool) resolve(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var found []config.InstanceConfig
+	var err error
+	switch p.cfg.Discovery {
+	case config.DiscoverySRV:
+		found, err = lookupSRV(ctx, p.cfg.DNSName)
+	default:
+		found, err = lookupHost(ctx, p.cfg.DNSName, p.cfg.Port)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.resolveError = err.Error()
+		p.logger.Errorw("Failed to look up upstream instances", "upstream", p.name, "name", p.cfg.DNSName, "error", err)
+		return
+	}
+	p.resolveError = ""
+	p.resolved = time.Now()
+
+	current := make(map[string]*Instance, len(p.instances))
+	for _, instance := range p.instances {
+		current[instance.addr] = instance
+	}
+	instances := make([]*Instance, 0, len(found))
+	for _, f := range found {
+		instance, ok := current[f.Addr]
+		if !ok {
+			instance = &Instance{addr: f.Addr, healthy: true}
+		}
+		instance.weight = f.Weight
+		instances = append(instances, instance)
+	}
+	if len(instances) != len(p.instances) {
+		p.logger.Infow("Upstream instances changed", "upstream", p.name, "instances", len(instances))
+	}
+	p.instances = instances
+}
+
+// lookupHost finds an instance at port for every address of name.
+func lookupHost(ctx context.Context, name, port string) ([]config.InstanceConfig, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	instances := make([]config.InstanceConfig, len(addrs))
+	for i, addr := range addrs {
+		instances[i] = config.InstanceConfig{Addr: net.JoinHostPort(addr, port), Weight: 1}
+	}
+	return instances, nil
+}
+
+// lookupSRV finds the instances in the SRV records of name with the
+// lowest priority, weighted as the records are.
+func lookupSRV(ctx context.Context, name string) ([]config.InstanceConfig, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no SRV records")
+	}
+	priority := records[0].Priority
+	var instances []config.InstanceConfig
+	for _, record := range records {
+		if record.Priority != priority {
+			// Sorted by priority: the rest are backups
+			break
+		}
+		instances = append(instances, config.InstanceConfig{
+			Addr:   net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			Weight: int(record.Weight),
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+	return instances, nil
+}
+
+// Available returns how many instances are in rotation, and how many
+// there are.
+func (p *Pool) Available() (available, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, instance := range p.instances {
+		if instance.available(now) {
+			available++
+		}
+	}
+	return available, len(p.instances)
+}
+
+// PoolStats describes a pool and its instances.
+type PoolStats struct {
+	Discovery    string          `json:"discovery"`
+	Strategy     string          `json:"strategy"`
+	DNSName      string          `json:"dns_name,omitempty"`
+	ResolvedAt   *time.Time      `json:"resolved_at,omitempty"`
+	ResolveError string          `json:"resolve_error,omitempty"`
+	Instances    []InstanceStats `json:"instances"`
+}
+
+// InstanceStats describes an instance. State is healthy, unhealthy (by
+// its health checks) or ejected (by outlier detection).
+type InstanceStats struct {
+	Addr                string     `json:"addr"`
+	Weight              int        `json:"weight"`
+	State               string     `json:"state"`
+	Outstanding         int        `json:"outstanding"`
+	Requests            uint64     `json:"requests"`
+	Failures            uint64     `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Ejections           int        `json:"ejections"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastCheckError      string     `json:"last_check_error,omitempty"`
+}
+
+// Stats returns a snapshot of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := PoolStats{
+		Discovery:    p.cfg.Discovery,
+		Strategy:     p.cfg.Strategy,
+		ResolveError: p.resolveError,
+		Instances:    make([]InstanceStats, len(p.instances)),
+	}
+	if p.cfg.Discovery != config.DiscoveryStatic {
+		stats.DNSName = p.cfg.DNSName
+	}
+	if !p.resolved.IsZero() {
+		stats.ResolvedAt = timePtr(p.resolved)
+	}
+	for i, instance := range p.instances {
+		s := InstanceStats{
+			Addr:                instance.addr,
+			Weight:              instance.weight,
+			State:               "healthy",
+			Outstanding:         instance.outstanding,
+			Requests:            instance.requests,
+			Failures:            instance.failures,
+			ConsecutiveFailures: instance.consecutiveFailures,
+			Ejections:           instance.ejections,
+			LastCheckError:      instance.lastCheckError,
+		}
+		switch {
+		case now.Before(instance.ejectedUntil):
+			s.State = "ejected"
+			s.EjectedUntil = timePtr(instance.ejectedUntil)
+		case !instance.healthy:
+			s.State = "unhealthy"
+		}
+		if !instance.lastCheck.IsZero() {
+			s.LastCheck = timePtr(instance.lastCheck)
+		}
+		stats.Instances[i] = s
+	}
+	return stats
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,271 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// testInstance is an instance whose health endpoint can be failed.
+type testInstance struct {
+	addr    string
+	failing atomic.Bool
+}
+
+// newTestPool starts n instances and a static pool of them.
+func newTestPool(t *testing.T, n int, cfg config.PoolConfig) (*Pool, []*testInstance) {
+	t.Helper()
+	instances := make([]*testInstance, n)
+	for i := range instances {
+		instance := &testInstance{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if instance.failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		instance.addr = srv.Listener.Addr().String()
+		instances[i] = instance
+		cfg.Instances = append(cfg.Instances, config.InstanceConfig{Addr: instance.addr, Weight: 1})
+	}
+	cfg.Discovery = config.DiscoveryStatic
+	cfg.Scheme = "http"
+	return NewPool("test", cfg, zap.NewNop().Sugar()), instances
+}
+
+var testHealthCheck = config.HealthCheckConfig{
+	Timeout:            time.Second,
+	Path:               "/readyz",
+	HealthyThreshold:   2,
+	UnhealthyThreshold: 2,
+}
+
+// picked returns the addresses of the instances n picks land on.
+func picked(t *testing.T, p *Pool, n int) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for range n {
+		instance, err := p.Pick(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[instance.Addr()]++
+		p.Done(instance, true)
+	}
+	return counts
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	p, instances := newTestPool(t, 3, config.PoolConfig{HealthCheck: testHealthCheck})
+	sick := instances[1]
+	sick.failing.Store(true)
+
+	// One failed check is not enough to take it out
+	p.checkHealth(context.Background())
+	if got := picked(t, p, 6); got[sick.addr] == 0 {
+		t.Errorf("instance out of rotation after one failed check: %v", got)
+	}
+	p.checkHealth(context.Background())
+	if got := picked(t, p, 6); got[sick.addr] != 0 || len(got) != 2 {
+		t.Errorf("got picks %v, want the two healthy instances only", got)
+	}
+	if available, total := p.Available(); available != 2 || total != 3 {
+		t.Errorf("got %d of %d available, want 2 of 3", available, total)
+	}
+	if state := p.Stats().Instances[1].State; state != "unhealthy" {
+		t.Errorf("got state %q, want unhealthy", state)
+	}
+
+	sick.failing.Store(false)
+	p.checkHealth(context.Background())
+	if got := picked(t, p, 6); got[sick.addr] != 0 {
+		t.Errorf("instance back in rotation after one passed check: %v", got)
+	}
+	p.checkHealth(context.Background())
+	if got := picked(t, p, 6); got[sick.addr] == 0 {
+		t.Errorf("recovered instance not picked: %v", got)
+	}
+}
+
+func TestPoolOutlierEjection(t *testing.T) {
+	p, instances := newTestPool(t, 4, config.PoolConfig{Outlier: config.OutlierConfig{
+		ConsecutiveFailures: 3,
+		EjectionTime:        20 * time.Millisecond,
+		MaxEjectionTime:     time.Second,
+		MaxEjectionPercent:  50,
+	}})
+	fail := func(addr string, n int) {
+		for n > 0 {
+			instance, err := p.Pick(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instance.Addr() == addr {
+				p.Done(instance, false)
+				n--
+			} else {
+				p.Done(instance, true)
+			}
+		}
+	}
+
+	fail(instances[0].addr, 3)
+	if got := picked(t, p, 8); got[instances[0].addr] != 0 {
+		t.Errorf("ejected instance picked: %v", got)
+	}
+	if s := p.Stats().Instances[0]; s.State != "ejected" || s.Ejections != 1 {
+		t.Errorf("got state %q after %d ejections, want ejected once", s.State, s.Ejections)
+	}
+
+	// Two of four is as many as MaxEjectionPercent allows
+	fail(instances[1].addr, 3)
+	fail(instances[2].addr, 3)
+	if available, _ := p.Available(); available != 2 {
+		t.Errorf("got %d available, want 2 kept despite their failures", available)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if got := picked(t, p, 8); got[instances[0].addr] == 0 || got[instances[1].addr] == 0 {
+		t.Errorf("instances not back after the ejection time: %v", got)
+	}
+}
+
+func TestPoolFailureRateEjection(t *testing.T) {
+	p, instances := newTestPool(t, 2, config.PoolConfig{Outlier: config.OutlierConfig{
+		FailureRate:        0.5,
+		MinRequests:        4,
+		EjectionTime:       time.Minute,
+		MaxEjectionPercent: 50,
+	}})
+	for range 8 {
+		instance, err := p.Pick(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Half of the requests to the first instance fail, none to the other
+		p.Done(instance, instance.Addr() != instances[0].addr || instance.requests%2 == 0)
+	}
+
+	p.endInterval(time.Now())
+	if got := picked(t, p, 4); got[instances[0].addr] != 0 {
+		t.Errorf("instance failing half its requests picked: %v", got)
+	}
+}
+
+func TestPoolAllDown(t *testing.T) {
+	p, instances := newTestPool(t, 2, config.PoolConfig{HealthCheck: testHealthCheck})
+	for _, instance := range instances {
+		instance.failing.Store(true)
+	}
+	for range testHealthCheck.UnhealthyThreshold {
+		p.checkHealth(context.Background())
+	}
+
+	_, err := p.Pick(nil)
+	var noInstance *NoInstanceError
+	if !errors.As(err, &noInstance) || noInstance.Upstream != "test" {
+		t.Fatalf("got %v, want a NoInstanceError", err)
+	}
+	if available, total := p.Available(); available != 0 || total != 2 {
+		t.Errorf("got %d of %d available, want 0 of 2", available, total)
+	}
+
+	instances[0].failing.Store(false)
+	for range testHealthCheck.HealthyThreshold {
+		p.checkHealth(context.Background())
+	}
+	if got := picked(t, p, 2); got[instances[0].addr] != 2 {
+		t.Errorf("got picks %v, want the recovered instance", got)
+	}
+}
+
+func TestPoolPickAvoidsPreviousInstance(t *testing.T) {
+	p, _ := newTestPool(t, 2, config.PoolConfig{})
+	first, _ := p.Pick(nil)
+	p.Done(first, false)
+	for range 4 {
+		retry, err := p.Pick(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retry == first {
+			t.Fatal("retry sent to the instance it is retrying")
+		}
+		p.Done(retry, true)
+	}
+}
+
+func TestPoolConcurrentPicks(t *testing.T) {
+	strategies := []string{config.BalanceRoundRobin, config.BalanceLeastOutstanding, config.BalanceWeighted}
+	for _, strategy := range strategies {
+		t.Run(strategy, func(t *testing.T) {
+			p, instances := newTestPool(t, 3, config.PoolConfig{
+				Strategy:    strategy,
+				HealthCheck: testHealthCheck,
+				Outlier: config.OutlierConfig{
+					ConsecutiveFailures: 5,
+					FailureRate:         0.9,
+					MinRequests:         10,
+					EjectionTime:        time.Millisecond,
+					MaxEjectionPercent:  50,
+				},
+			})
+			instances[2].failing.Store(true)
+
+			const workers, requests = 8, 200
+			var wg sync.WaitGroup
+			var picks atomic.Int64
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var previous *Instance
+					for i := range requests {
+						instance, err := p.Pick(previous)
+						if err != nil {
+							continue
+						}
+						picks.Add(1)
+						switch i % 3 {
+						case 0:
+							p.Cancel(instance)
+						default:
+							p.Done(instance, (i+w)%4 != 0)
+						}
+						previous = instance
+					}
+				}()
+			}
+			// Health checks and intervals end while requests are in flight
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					p.checkHealth(context.Background())
+					p.endInterval(time.Now())
+					p.Stats()
+				}
+			}()
+			wg.Wait()
+
+			var requested uint64
+			for _, s := range p.Stats().Instances {
+				if s.Outstanding != 0 {
+					t.Errorf("%s: %d requests still outstanding", s.Addr, s.Outstanding)
+				}
+				requested += s.Requests
+			}
+			if requested != uint64(picks.Load()) {
+				t.Errorf("got %d requests counted, want %d", requested, picks.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package validation checks JSON request bodies against the JSON Schemas
+// named in the route table before they are forwarded upstream.
+package validation
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+//go:embed schemas/*.json
+var defaultSchemas embed.FS
+
+// maxDepth bounds the nesting of request bodies.
+const maxDepth = 32
+
+var printer = message.NewPrinter(language.English)
+
+// Validator holds the compiled schemas, keyed by name. A schema named
+// "order" is read from order.json.
+type Validator struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewValidator compiles the named schemas from dir, or from the embedded
+// defaults when dir is empty. It fails if any of them is missing or
+// invalid, so a typo in the route table is caught at startup.
+func NewValidator(dir string, names []string) (*Validator, error) {
+	var fsys fs.FS
+	if dir == "" {
+		sub, err := fs.Sub(defaultSchemas, "schemas")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	} else {
+		fsys = os.DirFS(dir)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+
+	v := &Validator{schemas: make(map[string]*jsonschema.Schema)}
+	for _, name := range names {
+		if _, ok := v.schemas[name]; ok {
+			continue
+		}
+		file := name + ".json"
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		url := "file:///" + filepath.ToSlash(file)
+		if err := compiler.AddResource(url, doc); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		v.schemas[name] = schema
+	}
+	return v, nil
+}
+
+// Validate decodes body strictly and checks it against the named schema.
+// A body that is not acceptable JSON is reported as an error; a body that
+// does not match the schema as field errors.
+func (v *Validator) Validate(name string, body []byte) ([]problem.FieldError, error) {
+	schema, ok := v.schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+
+	doc, err := decodeStrict(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = schema.Validate(doc)
+	var verr *jsonschema.ValidationError
+	if err == nil {
+		return nil, nil
+	} else if !errors.As(err, &verr) {
+		return nil, err
+	}
+
+	var fieldErrs []problem.FieldError
+	collect(verr, &fieldErrs)
+	slices.SortStableFunc(fieldErrs, func(a, b problem.FieldError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return fieldErrs, nil
+}
+
+// collect flattens the leaves of a validation error into field errors.
+func collect(verr *jsonschema.ValidationError, out *[]problem.FieldError) {
+	if len(verr.Causes) > 0 {
+		for _, cause := range verr.Causes {
+			collect(cause, out)
+		}
+		return
+	}
+
+	field := strings.Join(verr.InstanceLocation, ".")
+	switch k := verr.ErrorKind.(type) {
+	case *kind.Required:
+		for _, name := range k.Missing {
+			*out = append(*out, problem.Required(joinField(field, name)))
+		}
+	case *kind.AdditionalProperties:
+		for _, name := range k.Properties {
+			*out = append(*out, problem.Unknown(joinField(field, name)))
+		}
+	default:
+		*out = append(*out, problem.Invalid(field, verr.ErrorKind.LocalizedString(printer)))
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// decodeStrict parses exactly one JSON value. Unlike encoding/json it
+// rejects duplicate object keys, which different parsers resolve
+// differently, and nesting deeper than maxDepth.
+func decodeStrict(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeValue(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("JSON value is nested too deeply")
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		obj := make(map[string]interface{})
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			if _, dup := obj[key]; dup {
+				return nil, fmt.Errorf("duplicate key %q", key)
+			}
+			if obj[key], err = decodeValue(dec, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case '[':
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeValue(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
@@ -0,0 +1,138 @@
+package validation
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/nutcase/shop-ecommerce/api-gateway/internal/problem"
+)
+
+// newDefaultValidator compiles every schema shipped with the gateway.
+func newDefaultValidator(t *testing.T) *Validator {
+	t.Helper()
+	files, err := fs.Glob(defaultSchemas, "schemas/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(file, "schemas/"), ".json"))
+	}
+	v, err := NewValidator("", names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// fieldError is the part of a problem.FieldError a test checks; messages
+// come from the schema library.
+type fieldError struct {
+	field string
+	code  string
+}
+
+func TestValidate(t *testing.T) {
+	v := newDefaultValidator(t)
+	const register = `"email":"ada@example.com","password":"correct horse","first_name":"Ada","last_name":"Lovelace"`
+
+	tests := []struct {
+		name   string
+		schema string
+		body   string
+		want   []fieldError
+	}{
+		// Valid bodies
+		{"register", "register", `{` + register + `}`, nil},
+		{"login", "login", `{"email":"ada@example.com","password":"x"}`, nil},
+		{"cart item", "cart-item", `{"product_id":"p1","quantity":2}`, nil},
+		{"cart item update", "cart-item-update", `{"quantity":999}`, nil},
+		{"order", "order", `{"shipping_address":"1 Main St","payment_method":"card"}`, nil},
+		{"product", "product", `{"name":"Mug","price":9.5,"image_url":"https://example.com/mug.jpg","stock":3}`, nil},
+		{"product v2", "product-v2", `{"name":"Mug","price":0,"image":"https://example.com/mug.jpg","stock_quantity":3}`, nil},
+		{"inventory without location", "inventory", `{"quantity":0,"location":null}`, nil},
+		{"refund", "refund", `{"amount":5,"reason":"damaged"}`, nil},
+
+		// Missing required fields, reported sorted by field
+		{"register missing names", "register", `{"email":"ada@example.com","password":"correct horse"}`,
+			[]fieldError{{"first_name", problem.FieldRequired}, {"last_name", problem.FieldRequired}}},
+		{"empty order", "order", `{}`,
+			[]fieldError{{"payment_method", problem.FieldRequired}, {"shipping_address", problem.FieldRequired}}},
+		{"refund without reason", "refund", `{"amount":5}`, []fieldError{{"reason", problem.FieldRequired}}},
+
+		// Wrong types and values
+		{"quantity as string", "cart-item", `{"product_id":"p1","quantity":"2"}`, []fieldError{{"quantity", problem.FieldInvalid}}},
+		{"fractional quantity", "cart-item-update", `{"quantity":1.5}`, []fieldError{{"quantity", problem.FieldInvalid}}},
+		{"quantity below minimum", "cart-item", `{"product_id":"p1","quantity":0}`, []fieldError{{"quantity", problem.FieldInvalid}}},
+		{"price as string", "product", `{"name":"Mug","price":"9.50"}`, []fieldError{{"price", problem.FieldInvalid}}},
+		{"invalid email", "login", `{"email":"ada","password":"x"}`, []fieldError{{"email", problem.FieldInvalid}}},
+		{"short password", "register", `{` + strings.Replace(register, "correct horse", "short", 1) + `}`,
+			[]fieldError{{"password", problem.FieldInvalid}}},
+		{"zero refund", "refund", `{"amount":0,"reason":"damaged"}`, []fieldError{{"amount", problem.FieldInvalid}}},
+		{"array body", "order", `[]`, []fieldError{{"", problem.FieldInvalid}}},
+
+		// Unknown fields
+		{"user_id in order", "order", `{"shipping_address":"1 Main St","payment_method":"card","user_id":"u2"}`,
+			[]fieldError{{"user_id", problem.FieldUnknown}}},
+		{"role in register", "register", `{` + register + `,"role":"admin"}`, []fieldError{{"role", problem.FieldUnknown}}},
+		{"v1 field in v2", "product-v2", `{"name":"Mug","price":1,"image_url":"https://example.com/mug.jpg"}`,
+			[]fieldError{{"image_url", problem.FieldUnknown}}},
+
+		// Every problem at once
+		{"several", "cart-item", `{"quantity":-1,"extra":true}`, []fieldError{
+			{"extra", problem.FieldUnknown}, {"product_id", problem.FieldRequired}, {"quantity", problem.FieldInvalid},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := v.Validate(tt.schema, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]fieldError, len(errs))
+			for i, e := range errs {
+				got[i] = fieldError{e.Field, e.Code}
+				if e.Message == "" {
+					t.Errorf("%s: no message", e.Field)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRejectsAmbiguousJSON(t *testing.T) {
+	v := newDefaultValidator(t)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `quantity=1`},
+		{"truncated", `{"quantity":1`},
+		{"duplicate key", `{"quantity":1,"quantity":999}`},
+		{"trailing data", `{"quantity":1}{"quantity":2}`},
+		{"too deep", strings.Repeat("[", maxDepth+2) + strings.Repeat("]", maxDepth+2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs, err := v.Validate("cart-item-update", []byte(tt.body)); err == nil {
+				t.Errorf("got field errors %+v, want the body rejected", errs)
+			}
+		})
+	}
+}
+
+func TestNewValidatorUnknownSchema(t *testing.T) {
+	if _, err := NewValidator("", []string{"order", "no-such-schema"}); err == nil {
+		t.Error("got no error for a schema that does not exist")
+	}
+}
@@ -3,14 +3,27 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/accesslog"
 	"github.com/nutcase/shop-ecommerce/cart-service/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/health"
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/metrics"
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/tracing"
+	cartv1 "github.com/nutcase/shop-ecommerce/proto/cart/v1"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
@@ -21,9 +34,46 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
+	// Access log lines would be dropped by zap's own sampling
+	accessLogConfig := zap.NewProductionConfig()
+	accessLogConfig.Sampling = nil
+	accessLogger, err := accessLogConfig.Build()
+	if err != nil {
+		log.Fatalf("Failed to initialize access logger: %v", err)
+	}
+	defer accessLogger.Sync()
+	// 2xx lines are sampled; errors are always logged
+	sampleRate, err := strconv.ParseFloat(getEnv("ACCESS_LOG_SAMPLE_RATE", "1"), 64)
+	if err != nil || sampleRate < 0 || sampleRate > 1 {
+		sugar.Fatalf("ACCESS_LOG_SAMPLE_RATE must be a number between 0 and 1")
+	}
+	accessLog := accesslog.Config{Logger: accessLogger.Named("access").Sugar(), SampleRate: sampleRate}
+
+	tracingConfig, err := tracing.ConfigFromEnv("cart-service")
+	if err != nil {
+		sugar.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	tp, err := tracing.Init(context.Background(), tracingConfig)
+	if err != nil {
+		sugar.Fatalf("Failed to initialize tracer: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(getEnv("REDIS_HOST", "localhost"), getEnv("REDIS_PORT", "6379")),
+		Password: os.Getenv("REDIS_PASSWORD"),
+	})
+	defer rdb.Close()
+
+	healthHandler := health.NewHandler(sugar, 2*time.Second)
+	healthHandler.Add("redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+
 	handler := handlers.NewHandler(sugar)
 	mux := http.NewServeMux()
-	
+
+	mux.HandleFunc("GET /livez", healthHandler.Livez)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.Handle("GET /metrics", metrics.Handler())
+
 	mux.HandleFunc("GET /api/carts/{user_id}", handler.GetCart)
 	mux.HandleFunc("POST /api/carts/{user_id}/items", handler.AddToCart)
 	mux.HandleFunc("PUT /api/carts/{user_id}/items/{product_id}", handler.UpdateCartItem)
@@ -31,7 +81,7 @@ func main() {
 	mux.HandleFunc("DELETE /api/carts/{user_id}", handler.ClearCart)
 	server := &http.Server{
 		Addr:    ":8083",
-		Handler: mux,
+		Handler: tracing.Middleware("cart-service", accesslog.Middleware(accessLog, metrics.Middleware(mux))),
 	}
 	go func() {
 		sugar.Infof("Starting cart service on port 8083")
@@ -40,6 +90,24 @@ func main() {
 		}
 	}()
 
+	// The same API over gRPC, for the gateway and other services
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	cartv1.RegisterCartServiceServer(grpcServer, handlers.NewGRPCServer(sugar))
+	healthpb.RegisterHealthServer(grpcServer, grpchealth.NewServer())
+	reflection.Register(grpcServer)
+
+	grpcAddr := ":" + getEnv("GRPC_PORT", "9083")
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		sugar.Fatalf("Failed to listen on %s: %v", grpcAddr, err)
+	}
+	go func() {
+		sugar.Infof("Starting cart gRPC server on %s", grpcAddr)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			sugar.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -52,6 +120,32 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		sugar.Fatalf("Server forced to shutdown: %v", err)
 	}
+	stopGRPC(ctx, grpcServer)
+	// Flushes the spans of the last requests
+	if err := tp.Shutdown(ctx); err != nil {
+		sugar.Errorf("Error shutting down tracer provider: %v", err)
+	}
 
 	sugar.Info("Server exiting")
 }
+
+// stopGRPC lets in-flight calls finish, cutting them off when ctx ends.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
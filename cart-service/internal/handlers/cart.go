@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/problem"
 	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
@@ -42,7 +43,7 @@ func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.PathValue("user_id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 
@@ -81,7 +82,7 @@ func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
 	// Write response
 	if err := json.NewEncoder(w).Encode(cart); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -92,22 +93,25 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.PathValue("user_id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 
 	var req AddToCartRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
+	var fieldErrs []problem.FieldError
 	if req.ProductID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("product_id"))
 	}
 	if req.Quantity <= 0 {
-		http.Error(w, "Quantity must be greater than 0", http.StatusBadRequest)
+		fieldErrs = append(fieldErrs, problem.Invalid("quantity", "quantity must be greater than 0"))
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
@@ -141,11 +145,13 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	cartAddsTotal.Inc()
+	cartAddedQuantityTotal.Add(float64(req.Quantity))
 	
 	// Write response
 	if err := json.NewEncoder(w).Encode(cart); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -158,22 +164,22 @@ func (h *Handler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 	productID := r.PathValue("product_id")
 	
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 	if productID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("product_id")))
 		return
 	}
 
 	var req AddToCartRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if req.Quantity <= 0 {
-		http.Error(w, "Quantity must be greater than 0", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Invalid("quantity", "quantity must be greater than 0")))
 		return
 	}
 
@@ -209,7 +215,7 @@ func (h *Handler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(cart); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -222,11 +228,11 @@ func (h *Handler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	productID := r.PathValue("product_id")
 	
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 	if productID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("product_id")))
 		return
 	}
 
@@ -254,7 +260,7 @@ func (h *Handler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(cart); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -264,7 +270,7 @@ func (h *Handler) ClearCart(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	userID := r.PathValue("user_id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 
@@ -285,7 +291,7 @@ func (h *Handler) ClearCart(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(cart); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/nutcase/shop-ecommerce/cart-service/internal/problem"
+	cartv1 "github.com/nutcase/shop-ecommerce/proto/cart/v1"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+// GRPCServer serves the cart API over gRPC. It mirrors the HTTP handlers
+// and reports errors with the same codes.
+type GRPCServer struct {
+	cartv1.UnimplementedCartServiceServer
+	logger *zap.SugaredLogger
+}
+
+func NewGRPCServer(logger *zap.SugaredLogger) *GRPCServer {
+	return &GRPCServer{logger: logger}
+}
+
+func (s *GRPCServer) GetCart(ctx context.Context, req *cartv1.GetCartRequest) (*cartv1.Cart, error) {
+	_, span := otel.Tracer("cart-service").Start(ctx, "GetCart")
+	defer span.End()
+
+	if req.GetUserId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("user_id"))
+	}
+	s.logger.Infow("Getting cart", "user_id", req.GetUserId())
+
+	// TODO: Implement repository call to get cart
+	return mockCart(req.GetUserId(), sampleItem("1", 2, 29.99), sampleItem("2", 1, 39.99)), nil
+}
+
+func (s *GRPCServer) AddItem(ctx context.Context, req *cartv1.AddItemRequest) (*cartv1.Cart, error) {
+	_, span := otel.Tracer("cart-service").Start(ctx, "AddToCart")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetUserId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("user_id"))
+	}
+	if req.GetProductId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("product_id"))
+	}
+	if req.GetQuantity() <= 0 {
+		fieldErrs = append(fieldErrs, problem.Invalid("quantity", "quantity must be greater than 0"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	s.logger.Infow("Adding to cart", "user_id", req.GetUserId(), "product_id", req.GetProductId(), "quantity", req.GetQuantity())
+
+	// TODO: Implement repository call to add item to cart
+	cart := mockCart(req.GetUserId(), sampleItem("1", 2, 29.99), sampleItem(req.GetProductId(), req.GetQuantity(), 39.99))
+	cartAddsTotal.Inc()
+	cartAddedQuantityTotal.Add(float64(req.GetQuantity()))
+	return cart, nil
+}
+
+func (s *GRPCServer) UpdateItem(ctx context.Context, req *cartv1.UpdateItemRequest) (*cartv1.Cart, error) {
+	_, span := otel.Tracer("cart-service").Start(ctx, "UpdateCartItem")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetUserId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("user_id"))
+	}
+	if req.GetProductId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("product_id"))
+	}
+	if req.GetQuantity() <= 0 {
+		fieldErrs = append(fieldErrs, problem.Invalid("quantity", "quantity must be greater than 0"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	s.logger.Infow("Updating cart item", "user_id", req.GetUserId(), "product_id", req.GetProductId(), "quantity", req.GetQuantity())
+
+	// TODO: Implement repository call to update item in cart
+	return mockCart(req.GetUserId(), sampleItem("1", 2, 29.99), sampleItem(req.GetProductId(), req.GetQuantity(), 39.99)), nil
+}
+
+func (s *GRPCServer) RemoveItem(ctx context.Context, req *cartv1.RemoveItemRequest) (*cartv1.Cart, error) {
+	_, span := otel.Tracer("cart-service").Start(ctx, "RemoveFromCart")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetUserId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("user_id"))
+	}
+	if req.GetProductId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("product_id"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	s.logger.Infow("Removing from cart", "user_id", req.GetUserId(), "product_id", req.GetProductId())
+
+	// TODO: Implement repository call to remove item from cart
+	return mockCart(req.GetUserId(), sampleItem("1", 2, 29.99)), nil
+}
+
+func (s *GRPCServer) ClearCart(ctx context.Context, req *cartv1.ClearCartRequest) (*cartv1.Cart, error) {
+	_, span := otel.Tracer("cart-service").Start(ctx, "ClearCart")
+	defer span.End()
+
+	if req.GetUserId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("user_id"))
+	}
+	s.logger.Infow("Clearing cart", "user_id", req.GetUserId())
+
+	// TODO: Implement repository call to clear cart
+	return mockCart(req.GetUserId()), nil
+}
+
+// sampleItem stands in for a cart item read from the repository.
+func sampleItem(productID string, quantity int32, price float64) *cartv1.CartItem {
+	return &cartv1.CartItem{
+		ProductId:   productID,
+		ProductName: "Sample Product " + productID,
+		Quantity:    quantity,
+		Price:       price,
+		ImageUrl:    "https://example.com/product" + productID + ".jpg",
+	}
+}
+
+func mockCart(userID string, items ...*cartv1.CartItem) *cartv1.Cart {
+	cart := &cartv1.Cart{UserId: userID, Items: items}
+	for _, item := range items {
+		cart.Total += item.GetPrice() * float64(item.GetQuantity())
+	}
+	return cart
+}
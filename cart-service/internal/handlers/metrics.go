@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cartAddsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cart_adds_total",
+		Help: "Items added to carts.",
+	})
+
+	cartAddedQuantityTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cart_added_quantity_total",
+		Help: "Units added to carts, summed over all adds.",
+	})
+)
@@ -0,0 +1,141 @@
+// Package tracing exports the service's spans over OTLP and continues the
+// traces of the requests it serves, so that its spans join the trace the
+// gateway started.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// ProtocolGRPC exports spans over OTLP/gRPC, by default to port 4317.
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP exports spans over OTLP/HTTP, by default to port 4318.
+	ProtocolHTTP = "http"
+)
+
+// Config selects the collector the spans are exported to.
+type Config struct {
+	ServiceName string
+	Endpoint    string
+	Port        int
+	Protocol    string
+	// SampleRatio in [0, 1] is the share of new traces sampled; requests
+	// that carry a trace keep their caller's decision
+	SampleRatio float64
+}
+
+// ConfigFromEnv reads the settings the gateway uses as well: OTLP_ENDPOINT,
+// OTLP_PORT, OTLP_PROTOCOL and TRACE_SAMPLE_RATIO.
+func ConfigFromEnv(serviceName string) (Config, error) {
+	cfg := Config{
+		ServiceName: serviceName,
+		Endpoint:    getEnv("OTLP_ENDPOINT", "jaeger"),
+		Protocol:    strings.ToLower(getEnv("OTLP_PROTOCOL", ProtocolGRPC)),
+	}
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		cfg.Port = 4317
+	case ProtocolHTTP:
+		cfg.Port = 4318
+	default:
+		return cfg, fmt.Errorf("OTLP_PROTOCOL: unknown protocol %q", cfg.Protocol)
+	}
+	if port := os.Getenv("OTLP_PORT"); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return cfg, fmt.Errorf("OTLP_PORT: invalid port %q", port)
+		}
+		cfg.Port = n
+	}
+	ratio, err := strconv.ParseFloat(getEnv("TRACE_SAMPLE_RATIO", "1"), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return cfg, errors.New("TRACE_SAMPLE_RATIO must be a number between 0 and 1")
+	}
+	cfg.SampleRatio = ratio
+	return cfg, nil
+}
+
+// Init installs a TracerProvider exporting to the collector in cfg, and W3C
+// trace context and baggage propagation, as the global ones. The provider
+// must be shut down to flush the spans still buffered.
+func Init(ctx context.Context, cfg Config) (*tracesdk.TracerProvider, error) {
+	endpoint := fmt.Sprintf("%s:%d", cfg.Endpoint, cfg.Port)
+	var client otlptrace.Client
+	switch cfg.Protocol {
+	case ProtocolHTTP:
+		client = otlptracehttp.NewClient(
+			otlptracehttp.WithEndpoint(endpoint),
+			otlptracehttp.WithInsecure(),
+			otlptracehttp.WithTimeout(5*time.Second),
+		)
+	default:
+		client = otlptracegrpc.NewClient(
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(),
+			otlptracegrpc.WithTimeout(5*time.Second),
+		)
+	}
+	exp, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	tp := tracesdk.NewTracerProvider(
+		tracesdk.WithBatcher(exp),
+		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(cfg.SampleRatio))),
+		tracesdk.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return tp, nil
+}
+
+// Middleware starts a server span for every request handled by mux,
+// continuing the caller's trace. The span is named after the ServeMux
+// pattern the request matched, so the handlers between this and the mux
+// must pass the request on as they got it.
+func Middleware(serviceName string, mux http.Handler) http.Handler {
+	return otelhttp.NewHandler(mux, serviceName, otelhttp.WithSpanNameFormatter(spanName))
+}
+
+func spanName(_ string, r *http.Request) string {
+	switch {
+	case r.Pattern == "":
+		return r.Method
+	case strings.Contains(r.Pattern, " "):
+		return r.Pattern
+	default:
+		return r.Method + " " + r.Pattern
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -2,15 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/accesslog"
 	"github.com/nutcase/shop-ecommerce/identity-service/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/health"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/metrics"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/revocation"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/token"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/tracing"
+	identityv1 "github.com/nutcase/shop-ecommerce/proto/identity/v1"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
@@ -21,18 +40,100 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
-	handler := handlers.NewHandler(sugar)
+	// Access log lines would be dropped by zap's own sampling
+	accessLogConfig := zap.NewProductionConfig()
+	accessLogConfig.Sampling = nil
+	accessLogger, err := accessLogConfig.Build()
+	if err != nil {
+		log.Fatalf("Failed to initialize access logger: %v", err)
+	}
+	defer accessLogger.Sync()
+	// 2xx lines are sampled; errors are always logged
+	sampleRate, err := strconv.ParseFloat(getEnv("ACCESS_LOG_SAMPLE_RATE", "1"), 64)
+	if err != nil || sampleRate < 0 || sampleRate > 1 {
+		sugar.Fatalf("ACCESS_LOG_SAMPLE_RATE must be a number between 0 and 1")
+	}
+	accessLog := accesslog.Config{Logger: accessLogger.Named("access").Sugar(), SampleRate: sampleRate}
+
+	tracingConfig, err := tracing.ConfigFromEnv("identity-service")
+	if err != nil {
+		sugar.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	tp, err := tracing.Init(context.Background(), tracingConfig)
+	if err != nil {
+		sugar.Fatalf("Failed to initialize tracer: %v", err)
+	}
+
+	dsn := (&url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(getEnv("DB_USER", "postgres"), getEnv("DB_PASSWORD", "postgres")),
+		Host:   net.JoinHostPort(getEnv("DB_HOST", "localhost"), getEnv("DB_PORT", "5432")),
+		Path:   "/" + getEnv("DB_NAME", "identity_db"),
+	}).String()
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		sugar.Fatalf("Failed to create database pool: %v", err)
+	}
+	defer db.Close()
+
+	// Revoked tokens are recorded in the Redis the gateway checks them against
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(getEnv("REDIS_HOST", "localhost"), getEnv("REDIS_PORT", "6379")),
+		Password: os.Getenv("REDIS_PASSWORD"),
+	})
+	defer rdb.Close()
+
+	healthHandler := health.NewHandler(sugar, 2*time.Second)
+	healthHandler.Add("postgres", db.Ping)
+	healthHandler.Add("redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+
+	signingKey, err := token.LoadKey(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	if err != nil {
+		sugar.Fatalf("Failed to load JWT signing key: %v", err)
+	}
+	if os.Getenv("JWT_PRIVATE_KEY_FILE") == "" {
+		sugar.Warn("JWT_PRIVATE_KEY_FILE is not set, signing tokens with an ephemeral key")
+	}
+	var previousKeys []crypto.Signer
+	for _, path := range strings.Split(os.Getenv("JWT_PREVIOUS_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := token.LoadKey(path)
+		if err != nil {
+			sugar.Fatalf("Failed to load previous JWT signing key %s: %v", path, err)
+		}
+		previousKeys = append(previousKeys, key)
+	}
+	tokenTTL, err := time.ParseDuration(getEnv("JWT_EXPIRATION", "24h"))
+	if err != nil {
+		sugar.Fatalf("Invalid JWT_EXPIRATION: %v", err)
+	}
+	issuer, err := token.NewIssuer(signingKey, previousKeys, getEnv("JWT_ISSUER", "identity-service"), getEnv("JWT_AUDIENCE", "shop-ecommerce"), tokenTTL)
+	if err != nil {
+		sugar.Fatalf("Failed to create token issuer: %v", err)
+	}
+
+	revocations := revocation.NewStore(rdb)
+	handler := handlers.NewHandler(sugar, issuer, revocations)
 	mux := http.NewServeMux()
-	
+
+	mux.HandleFunc("GET /livez", healthHandler.Livez)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	mux.HandleFunc("GET /.well-known/jwks.json", issuer.JWKS)
 	mux.HandleFunc("POST /api/auth/register", handler.Register)
 	mux.HandleFunc("POST /api/auth/login", handler.Login)
+	mux.HandleFunc("POST /api/auth/logout", handler.Logout)
 	mux.HandleFunc("GET /api/users/{id}", handler.GetProfile)
 	mux.HandleFunc("PUT /api/users/{id}", handler.UpdateProfile)
 	mux.HandleFunc("POST /api/users/{id}/change-password", handler.ChangePassword)
+	mux.HandleFunc("POST /api/users/{id}/sessions/revoke", handler.RevokeSessions)
 
 	server := &http.Server{
 		Addr:    ":8084",
-		Handler: mux,
+		Handler: tracing.Middleware("identity-service", accesslog.Middleware(accessLog, metrics.Middleware(mux))),
 	}
 	go func() {
 		sugar.Infof("Starting identity service on port 8084")
@@ -41,6 +142,24 @@ func main() {
 		}
 	}()
 
+	// The same API over gRPC, for the gateway and other services
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	identityv1.RegisterIdentityServiceServer(grpcServer, handlers.NewGRPCServer(sugar, issuer, revocations))
+	healthpb.RegisterHealthServer(grpcServer, grpchealth.NewServer())
+	reflection.Register(grpcServer)
+
+	grpcAddr := ":" + getEnv("GRPC_PORT", "9081")
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		sugar.Fatalf("Failed to listen on %s: %v", grpcAddr, err)
+	}
+	go func() {
+		sugar.Infof("Starting identity gRPC server on %s", grpcAddr)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			sugar.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -53,6 +172,32 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		sugar.Fatalf("Server forced to shutdown: %v", err)
 	}
+	stopGRPC(ctx, grpcServer)
+	// Flushes the spans of the last requests
+	if err := tp.Shutdown(ctx); err != nil {
+		sugar.Errorf("Error shutting down tracer provider: %v", err)
+	}
 
 	sugar.Info("Server exiting")
 }
+
+// stopGRPC lets in-flight calls finish, cutting them off when ctx ends.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/problem"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/revocation"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/token"
+	identityv1 "github.com/nutcase/shop-ecommerce/proto/identity/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// httpCodeHeader tells the gateway's transcoder which HTTP status the REST
+// route answers a successful call with, when it is not 200.
+const httpCodeHeader = "x-http-code"
+
+// GRPCServer serves the identity API over gRPC. It mirrors the HTTP
+// handlers and reports errors with the same codes.
+type GRPCServer struct {
+	identityv1.UnimplementedIdentityServiceServer
+	logger      *zap.SugaredLogger
+	issuer      *token.Issuer
+	revocations *revocation.Store
+}
+
+func NewGRPCServer(logger *zap.SugaredLogger, issuer *token.Issuer, revocations *revocation.Store) *GRPCServer {
+	return &GRPCServer{
+		logger:      logger,
+		issuer:      issuer,
+		revocations: revocations,
+	}
+}
+
+func (s *GRPCServer) Register(ctx context.Context, req *identityv1.RegisterRequest) (*identityv1.AuthResponse, error) {
+	_, span := otel.Tracer("identity-service").Start(ctx, "Register")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetEmail() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("email"))
+	}
+	if req.GetPassword() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("password"))
+	}
+	if req.GetFirstName() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("first_name"))
+	}
+	if req.GetLastName() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("last_name"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	span.SetAttributes(
+		attribute.String("user.email", req.GetEmail()),
+		attribute.String("user.first_name", req.GetFirstName()),
+	)
+
+	// TODO: Implement user registration logic, as in the HTTP handler
+	now := time.Now()
+	user := User{
+		ID:        "user-123",
+		Email:     req.GetEmail(),
+		FirstName: req.GetFirstName(),
+		LastName:  req.GetLastName(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	resp, err := s.authResponse(user)
+	if err != nil {
+		return nil, err
+	}
+	registrationsTotal.Inc()
+	setHTTPStatus(ctx, http.StatusCreated)
+	return resp, nil
+}
+
+func (s *GRPCServer) Login(ctx context.Context, req *identityv1.LoginRequest) (*identityv1.AuthResponse, error) {
+	_, span := otel.Tracer("identity-service").Start(ctx, "Login")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetEmail() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("email"))
+	}
+	if req.GetPassword() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("password"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	span.SetAttributes(attribute.String("user.email", req.GetEmail()))
+
+	// TODO: Implement user authentication logic, as in the HTTP handler
+	now := time.Now()
+	user := User{
+		ID:        "user-123",
+		Email:     req.GetEmail(),
+		FirstName: "John",
+		LastName:  "Doe",
+		CreatedAt: now.Add(-30 * 24 * time.Hour),
+		UpdatedAt: now,
+	}
+
+	resp, err := s.authResponse(user)
+	if err != nil {
+		loginsTotal.WithLabelValues("failure").Inc()
+		return nil, err
+	}
+	loginsTotal.WithLabelValues("success").Inc()
+	return resp, nil
+}
+
+// Logout revokes the bearer token sent in the authorization metadata.
+func (s *GRPCServer) Logout(ctx context.Context, _ *identityv1.LogoutRequest) (*emptypb.Empty, error) {
+	ctx, span := otel.Tracer("identity-service").Start(ctx, "Logout")
+	defer span.End()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var tokenString string
+	if values := md.Get("authorization"); len(values) > 0 {
+		tokenString, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if tokenString == "" {
+		return nil, status.Error(codes.Unauthenticated, "Authorization header required")
+	}
+	// Every token issued by this service has a jti and an expiry
+	claims, err := s.issuer.Verify(tokenString)
+	if err != nil || claims.ID == "" {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	span.SetAttributes(attribute.String("user.id", claims.UserID))
+
+	if err := s.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		s.logger.Errorw("Failed to revoke token", "user_id", claims.UserID, "error", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+
+	setHTTPStatus(ctx, http.StatusNoContent)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GRPCServer) GetUser(ctx context.Context, req *identityv1.GetUserRequest) (*identityv1.User, error) {
+	_, span := otel.Tracer("identity-service").Start(ctx, "GetProfile")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("user.id", req.GetId()))
+
+	// TODO: Implement repository call to get user profile
+	now := time.Now()
+	user := User{
+		ID:        req.GetId(),
+		Email:     "user@example.com",
+		FirstName: "John",
+		LastName:  "Doe",
+		CreatedAt: now.Add(-30 * 24 * time.Hour),
+		UpdatedAt: now,
+	}
+	return user.proto(), nil
+}
+
+func (s *GRPCServer) UpdateUser(ctx context.Context, req *identityv1.UpdateUserRequest) (*identityv1.User, error) {
+	_, span := otel.Tracer("identity-service").Start(ctx, "UpdateProfile")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(
+		attribute.String("user.id", req.GetId()),
+		attribute.String("user.first_name", req.GetFirstName()),
+		attribute.String("user.last_name", req.GetLastName()),
+	)
+
+	// TODO: Implement repository call to update user profile
+	now := time.Now()
+	user := User{
+		ID:        req.GetId(),
+		Email:     "user@example.com",
+		FirstName: req.GetFirstName(),
+		LastName:  req.GetLastName(),
+		CreatedAt: now.Add(-30 * 24 * time.Hour),
+		UpdatedAt: now,
+	}
+	return user.proto(), nil
+}
+
+func (s *GRPCServer) ChangePassword(ctx context.Context, req *identityv1.ChangePasswordRequest) (*emptypb.Empty, error) {
+	_, span := otel.Tracer("identity-service").Start(ctx, "ChangePassword")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	var fieldErrs []problem.FieldError
+	if req.GetCurrentPassword() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("current_password"))
+	}
+	if req.GetNewPassword() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("new_password"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	span.SetAttributes(attribute.String("user.id", req.GetId()))
+
+	// TODO: Implement password change logic, as in the HTTP handler
+	setHTTPStatus(ctx, http.StatusNoContent)
+	return &emptypb.Empty{}, nil
+}
+
+// RevokeSessions revokes every token issued to a user so far. Callers are
+// authorized by the gateway.
+func (s *GRPCServer) RevokeSessions(ctx context.Context, req *identityv1.RevokeSessionsRequest) (*identityv1.RevokeSessionsResponse, error) {
+	ctx, span := otel.Tracer("identity-service").Start(ctx, "RevokeSessions")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("user.id", req.GetId()))
+
+	now := time.Now()
+	if err := s.revocations.RevokeUser(ctx, req.GetId(), now, s.issuer.TTL()); err != nil {
+		s.logger.Errorw("Failed to revoke sessions", "user_id", req.GetId(), "error", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	s.logger.Infow("Revoked all sessions", "user_id", req.GetId())
+
+	return &identityv1.RevokeSessionsResponse{
+		UserId:        req.GetId(),
+		RevokedBefore: timestamppb.New(now.Truncate(time.Second)),
+	}, nil
+}
+
+// authResponse issues a token for user.
+func (s *GRPCServer) authResponse(user User) (*identityv1.AuthResponse, error) {
+	signed, expiresAt, err := s.issuer.Issue(user.ID, user.Email, "customer")
+	if err != nil {
+		s.logger.Errorw("Failed to issue token", "error", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	return &identityv1.AuthResponse{
+		User: user.proto(),
+		Token: &identityv1.Token{
+			Token:     signed,
+			ExpiresAt: uint32(expiresAt.Unix()),
+		},
+	}, nil
+}
+
+func (u User) proto() *identityv1.User {
+	return &identityv1.User{
+		Id:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		UpdatedAt: timestamppb.New(u.UpdatedAt),
+	}
+}
+
+// setHTTPStatus sets the status the REST route answers the call with.
+func setHTTPStatus(ctx context.Context, code int) {
+	grpc.SetHeader(ctx, metadata.Pairs(httpCodeHeader, strconv.Itoa(code)))
+}
@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/problem"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/revocation"
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/token"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
@@ -12,12 +15,17 @@ import (
 
 type Handler struct {
 	logger *zap.SugaredLogger
+	issuer *token.Issuer
+	// revocations records logouts and revoked sessions
+	revocations *revocation.Store
 	// Add repository and other dependencies here
 }
 
-func NewHandler(logger *zap.SugaredLogger) *Handler {
+func NewHandler(logger *zap.SugaredLogger, issuer *token.Issuer, revocations *revocation.Store) *Handler {
 	return &Handler{
-		logger: logger,
+		logger:      logger,
+		issuer:      issuer,
+		revocations: revocations,
 	}
 }
 
@@ -51,24 +59,25 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
+	var fieldErrs []problem.FieldError
 	if req.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("email"))
 	}
 	if req.Password == "" {
-		http.Error(w, "Password is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("password"))
 	}
 	if req.FirstName == "" {
-		http.Error(w, "First name is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("first_name"))
 	}
 	if req.LastName == "" {
-		http.Error(w, "Last name is required", http.StatusBadRequest)
+		fieldErrs = append(fieldErrs, problem.Required("last_name"))
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
@@ -95,27 +104,33 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: now,
 	}
 
-	token := TokenResponse{
-		Token:     "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9...",
-		ExpiresAt: now.Add(24 * time.Hour).Unix(),
+	signed, expiresAt, err := h.issuer.Issue(user.ID, user.Email, "customer")
+	if err != nil {
+		h.logger.Errorw("Failed to issue token", "error", err)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+		return
 	}
 
 	response := struct {
-		User  User         `json:"user"`
+		User  User          `json:"user"`
 		Token TokenResponse `json:"token"`
 	}{
-		User:  user,
-		Token: token,
+		User: user,
+		Token: TokenResponse{
+			Token:     signed,
+			ExpiresAt: expiresAt.Unix(),
+		},
 	}
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	registrationsTotal.Inc()
 	
 	// Write response
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -125,16 +140,19 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
+	var fieldErrs []problem.FieldError
 	if req.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("email"))
 	}
 	if req.Password == "" {
-		http.Error(w, "Password is required", http.StatusBadRequest)
+		fieldErrs = append(fieldErrs, problem.Required("password"))
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
@@ -157,27 +175,34 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: now,
 	}
 
-	token := TokenResponse{
-		Token:     "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9...",
-		ExpiresAt: now.Add(24 * time.Hour).Unix(),
+	signed, expiresAt, err := h.issuer.Issue(user.ID, user.Email, "customer")
+	if err != nil {
+		h.logger.Errorw("Failed to issue token", "error", err)
+		loginsTotal.WithLabelValues("failure").Inc()
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+		return
 	}
 
 	response := struct {
-		User  User         `json:"user"`
+		User  User          `json:"user"`
 		Token TokenResponse `json:"token"`
 	}{
-		User:  user,
-		Token: token,
+		User: user,
+		Token: TokenResponse{
+			Token:     signed,
+			ExpiresAt: expiresAt.Unix(),
+		},
 	}
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	loginsTotal.WithLabelValues("success").Inc()
 	
 	// Write response
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -188,7 +213,7 @@ func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.PathValue("id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -212,7 +237,7 @@ func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(user); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -222,7 +247,7 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	userID := r.PathValue("id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -231,7 +256,7 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		LastName  string `json:"last_name"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -260,7 +285,7 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(user); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -270,7 +295,7 @@ func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	userID := r.PathValue("id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -279,16 +304,19 @@ func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		NewPassword     string `json:"new_password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
+	var fieldErrs []problem.FieldError
 	if req.CurrentPassword == "" {
-		http.Error(w, "Current password is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("current_password"))
 	}
 	if req.NewPassword == "" {
-		http.Error(w, "New password is required", http.StatusBadRequest)
+		fieldErrs = append(fieldErrs, problem.Required("new_password"))
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
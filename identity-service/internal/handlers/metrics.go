@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	registrationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "identity_registrations_total",
+		Help: "Users registered.",
+	})
+
+	loginsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "identity_logins_total",
+		Help: "Login attempts with valid input, by result: success or failure.",
+	}, []string{"result"})
+)
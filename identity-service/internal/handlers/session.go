@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/identity-service/internal/problem"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type RevokeSessionsResponse struct {
+	UserID        string    `json:"user_id"`
+	RevokedBefore time.Time `json:"revoked_before"`
+}
+
+// Logout revokes the bearer token the request was made with.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("identity-service").Start(r.Context(), "Logout")
+	defer span.End()
+
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authorization header required")
+		return
+	}
+	// Every token issued by this service has a jti and an expiry
+	claims, err := h.issuer.Verify(tokenString)
+	if err != nil || claims.ID == "" {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid token")
+		return
+	}
+
+	span.SetAttributes(attribute.String("user.id", claims.UserID))
+
+	if err := h.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		h.logger.Errorw("Failed to revoke token", "user_id", claims.UserID, "error", err)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions revokes every token issued to a user so far. Callers are
+// authorized by the gateway.
+func (h *Handler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("identity-service").Start(r.Context(), "RevokeSessions")
+	defer span.End()
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
+		return
+	}
+
+	span.SetAttributes(attribute.String("user.id", userID))
+
+	now := time.Now()
+	if err := h.revocations.RevokeUser(ctx, userID, now, h.issuer.TTL()); err != nil {
+		h.logger.Errorw("Failed to revoke sessions", "user_id", userID, "error", err)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+		return
+	}
+	h.logger.Infow("Revoked all sessions", "user_id", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(RevokeSessionsResponse{UserID: userID, RevokedBefore: now.Truncate(time.Second)}); err != nil {
+		h.logger.Errorw("Failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Package revocation records revoked access tokens in Redis. The gateway
+// reads the same keys to reject revoked tokens, so the key layout below
+// must match api-gateway/internal/revocation.
+package revocation
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// tokenKeyPrefix + jti marks a single token as revoked
+	tokenKeyPrefix = "revoked:jti:"
+	// userKeyPrefix + user ID holds a Unix timestamp; tokens of that user
+	// issued at or before it are revoked
+	userKeyPrefix = "revoked:user:"
+)
+
+// Store records token revocations.
+type Store struct {
+	client redis.UniversalClient
+}
+
+func NewStore(client redis.UniversalClient) *Store {
+	return &Store{client: client}
+}
+
+// RevokeToken revokes the token with the given ID until it expires.
+func (s *Store) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, tokenKeyPrefix+jti, "1", ttl).Err()
+}
+
+// RevokeUser revokes every token issued to userID up to now. The record is
+// kept for tokenTTL, after which all such tokens have expired anyway.
+func (s *Store) RevokeUser(ctx context.Context, userID string, now time.Time, tokenTTL time.Duration) error {
+	return s.client.Set(ctx, userKeyPrefix+userID, strconv.FormatInt(now.Unix(), 10), tokenTTL).Err()
+}
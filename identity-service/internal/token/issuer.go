@@ -0,0 +1,252 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims of the access tokens issued by the identity
+// service. The gateway decodes them into middleware.UserClaims.
+type Claims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Issuer signs access tokens with an asymmetric key and publishes the
+// public half as a JWK Set.
+type Issuer struct {
+	key      crypto.Signer
+	method   jwt.SigningMethod
+	kid      string
+	issuer   string
+	audience string
+	ttl      time.Duration
+	jwks     []jwk
+	// public holds the verification keys by key ID
+	public map[string]crypto.PublicKey
+}
+
+// NewIssuer creates an issuer signing with key, an RSA, ECDSA P-256 or
+// Ed25519 private key. The public halves of previous keys are still
+// published, so tokens signed before a key rotation keep verifying until
+// they expire.
+func NewIssuer(key crypto.Signer, previous []crypto.Signer, issuer, audience string, ttl time.Duration) (*Issuer, error) {
+	i := &Issuer{
+		key:      key,
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+		public:   make(map[string]crypto.PublicKey),
+	}
+
+	for n, k := range append([]crypto.Signer{key}, previous...) {
+		method, err := signingMethod(k)
+		if err != nil {
+			return nil, err
+		}
+		published, err := toJWK(k.Public(), method)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			i.method = method
+			i.kid = published.Kid
+		}
+		i.jwks = append(i.jwks, published)
+		i.public[published.Kid] = k.Public()
+	}
+	return i, nil
+}
+
+func signingMethod(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		return jwt.SigningMethodES256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// LoadKey reads a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key from
+// path. An empty path generates an ephemeral Ed25519 key, which is only
+// suitable for development since tokens stop verifying on restart.
+func LoadKey(path string) (crypto.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// Issue signs a token for the given user.
+func (i *Issuer) Issue(userID, email, role string) (string, time.Time, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(i.ttl)
+	claims := Claims{
+		UserID: userID,
+		Email:  email,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{i.audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        hex.EncodeToString(jti),
+		},
+	}
+
+	token := jwt.NewWithClaims(i.method, claims)
+	token.Header["kid"] = i.kid
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// TTL returns the lifetime of issued tokens.
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// Verify parses a token issued by i, signed with the current or a
+// previous key.
+func (i *Issuer) Verify(tokenString string) (*Claims, error) {
+	algs := make([]string, 0, len(i.jwks))
+	for _, k := range i.jwks {
+		algs = append(algs, k.Alg)
+	}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(i.issuer),
+		jwt.WithAudience(i.audience),
+		jwt.WithExpirationRequired(),
+	)
+
+	claims := &Claims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := i.public[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// toJWK encodes pub as a JWK. Its key ID is derived from the public key,
+// so it is stable across restarts and differs between keys.
+func toJWK(pub crypto.PublicKey, method jwt.SigningMethod) (jwk, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return jwk{}, err
+	}
+	sum := sha256.Sum256(der)
+
+	key := jwk{
+		Kid: base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Use: "sig",
+		Alg: method.Alg(),
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = b64(pub.N.Bytes())
+		key.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		key.Kty = "EC"
+		key.Crv = "P-256"
+		key.X = b64(pub.X.FillBytes(make([]byte, 32)))
+		key.Y = b64(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = b64(pub)
+	}
+	return key, nil
+}
+
+// JWKS serves the current and previous public signing keys as a JWK Set.
+func (i *Issuer) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: i.jwks})
+}
@@ -42,6 +42,7 @@ async fn main() -> std::io::Result<()> {
                     .route("/reservation/{reservation_id}/release", web::post().to(release_reservation))
             )
             .route("/health", web::get().to(health_check))
+            .route("/readyz", web::get().to(health_check))
     })
     .bind(config.server_address())?
     .run()
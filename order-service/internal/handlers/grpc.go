@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nutcase/shop-ecommerce/order-service/internal/problem"
+	orderv1 "github.com/nutcase/shop-ecommerce/proto/order/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// httpCodeHeader tells the gateway's transcoder which HTTP status the REST
+// route answers a successful call with, when it is not 200.
+const httpCodeHeader = "x-http-code"
+
+// GRPCServer serves the order API over gRPC. It mirrors the HTTP handlers
+// and reports errors with the same codes.
+type GRPCServer struct {
+	orderv1.UnimplementedOrderServiceServer
+	logger *zap.SugaredLogger
+}
+
+func NewGRPCServer(logger *zap.SugaredLogger) *GRPCServer {
+	return &GRPCServer{logger: logger}
+}
+
+func (s *GRPCServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.Order, error) {
+	_, span := otel.Tracer("order-service").Start(ctx, "CreateOrder")
+	defer span.End()
+
+	var fieldErrs []problem.FieldError
+	if req.GetUserId() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("user_id"))
+	}
+	if req.GetShippingAddress() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("shipping_address"))
+	}
+	if req.GetPaymentMethod() == "" {
+		fieldErrs = append(fieldErrs, problem.Required("payment_method"))
+	}
+	if len(fieldErrs) > 0 {
+		return nil, problem.InvalidArgument(fieldErrs...)
+	}
+
+	span.SetAttributes(
+		attribute.String("user.id", req.GetUserId()),
+		attribute.String("payment.method", req.GetPaymentMethod()),
+	)
+
+	// TODO: Implement order creation logic, as in the HTTP handler
+	now := time.Now()
+	order := mockOrder("order-123", req.GetUserId(), "created", now, now)
+	order.ShippingAddress = req.GetShippingAddress()
+	order.PaymentMethod = req.GetPaymentMethod()
+
+	ordersCreatedTotal.Inc()
+	setHTTPStatus(ctx, http.StatusCreated)
+	return order.proto(), nil
+}
+
+func (s *GRPCServer) ListOrders(ctx context.Context, req *orderv1.ListOrdersRequest) (*orderv1.ListOrdersResponse, error) {
+	_, span := otel.Tracer("order-service").Start(ctx, "GetOrders")
+	defer span.End()
+
+	if req.GetUserId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("user_id"))
+	}
+	span.SetAttributes(attribute.String("user.id", req.GetUserId()))
+
+	// TODO: Implement repository call to get orders for user
+	yesterday := time.Now().Add(-24 * time.Hour)
+	order := mockOrder("order-123", req.GetUserId(), "delivered", yesterday, yesterday)
+	return &orderv1.ListOrdersResponse{Orders: []*orderv1.Order{order.proto()}}, nil
+}
+
+func (s *GRPCServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.Order, error) {
+	_, span := otel.Tracer("order-service").Start(ctx, "GetOrder")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("order.id", req.GetId()))
+
+	// TODO: Implement repository call to get order
+	yesterday := time.Now().Add(-24 * time.Hour)
+	order := mockOrder(req.GetId(), "user-123", "delivered", yesterday, yesterday)
+	return order.proto(), nil
+}
+
+func (s *GRPCServer) CancelOrder(ctx context.Context, req *orderv1.CancelOrderRequest) (*orderv1.Order, error) {
+	_, span := otel.Tracer("order-service").Start(ctx, "CancelOrder")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("order.id", req.GetId()))
+
+	// TODO: Implement repository call to cancel order
+	now := time.Now()
+	order := mockOrder(req.GetId(), "user-123", "cancelled", now.Add(-24*time.Hour), now)
+	ordersCancelledTotal.Inc()
+	return order.proto(), nil
+}
+
+// mockOrder stands in for an order read from the repository.
+func mockOrder(id, userID, status string, createdAt, updatedAt time.Time) Order {
+	return Order{
+		ID:              id,
+		UserID:          userID,
+		Status:          status,
+		ShippingAddress: "123 Main St, City, Country",
+		PaymentMethod:   "credit_card",
+		Total:           99.99,
+		CreatedAt:       createdAt,
+		UpdatedAt:       updatedAt,
+		Items: []OrderItem{
+			{ProductID: "product-1", ProductName: "Sample Product 1", Quantity: 2, Price: 29.99},
+			{ProductID: "product-2", ProductName: "Sample Product 2", Quantity: 1, Price: 39.99},
+		},
+	}
+}
+
+func (o Order) proto() *orderv1.Order {
+	order := &orderv1.Order{
+		Id:              o.ID,
+		UserId:          o.UserID,
+		Status:          o.Status,
+		ShippingAddress: o.ShippingAddress,
+		PaymentMethod:   o.PaymentMethod,
+		Total:           o.Total,
+		CreatedAt:       timestamppb.New(o.CreatedAt),
+		UpdatedAt:       timestamppb.New(o.UpdatedAt),
+		Items:           make([]*orderv1.OrderItem, len(o.Items)),
+	}
+	for i, item := range o.Items {
+		order.Items[i] = &orderv1.OrderItem{
+			ProductId:   item.ProductID,
+			ProductName: item.ProductName,
+			Quantity:    int32(item.Quantity),
+			Price:       item.Price,
+		}
+	}
+	return order
+}
+
+// setHTTPStatus sets the status the REST route answers the call with.
+func setHTTPStatus(ctx context.Context, code int) {
+	grpc.SetHeader(ctx, metadata.Pairs(httpCodeHeader, strconv.Itoa(code)))
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/nutcase/shop-ecommerce/order-service/internal/problem"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
@@ -51,20 +52,22 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	var req CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
+	var fieldErrs []problem.FieldError
 	if req.UserID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("user_id"))
 	}
 	if req.ShippingAddress == "" {
-		http.Error(w, "Shipping address is required", http.StatusBadRequest)
-		return
+		fieldErrs = append(fieldErrs, problem.Required("shipping_address"))
 	}
 	if req.PaymentMethod == "" {
-		http.Error(w, "Payment method is required", http.StatusBadRequest)
+		fieldErrs = append(fieldErrs, problem.Required("payment_method"))
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
@@ -109,10 +112,11 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	ordersCreatedTotal.Inc()
 	
 	if err := json.NewEncoder(w).Encode(order); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -122,7 +126,7 @@ func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("user_id")))
 		return
 	}
 
@@ -182,7 +186,7 @@ func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(orders); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -192,7 +196,7 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	orderID := r.PathValue("id")
 	if orderID == "" {
-		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -233,7 +237,7 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(order); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -243,7 +247,7 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	orderID := r.PathValue("id")
 	if orderID == "" {
-		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -280,10 +284,11 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	ordersCancelledTotal.Inc()
 	
 	if err := json.NewEncoder(w).Encode(order); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
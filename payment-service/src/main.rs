@@ -34,6 +34,7 @@ async fn main() -> std::io::Result<()> {
                     .route("/order/{order_id}", web::get().to(get_payments_by_order))
             )
             .route("/health", web::get().to(health_check))
+            .route("/readyz", web::get().to(health_check))
     })
     .bind(config.server_address())?
     .run()
@@ -3,14 +3,28 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/nutcase/shop-ecommerce/product-service/internal/accesslog"
 	"github.com/nutcase/shop-ecommerce/product-service/internal/handlers"
+	"github.com/nutcase/shop-ecommerce/product-service/internal/health"
+	"github.com/nutcase/shop-ecommerce/product-service/internal/metrics"
+	"github.com/nutcase/shop-ecommerce/product-service/internal/tracing"
+	productv1 "github.com/nutcase/shop-ecommerce/proto/product/v1"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
@@ -21,10 +35,47 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
+	// Access log lines would be dropped by zap's own sampling
+	accessLogConfig := zap.NewProductionConfig()
+	accessLogConfig.Sampling = nil
+	accessLogger, err := accessLogConfig.Build()
+	if err != nil {
+		log.Fatalf("Failed to initialize access logger: %v", err)
+	}
+	defer accessLogger.Sync()
+	// 2xx lines are sampled; errors are always logged
+	sampleRate, err := strconv.ParseFloat(getEnv("ACCESS_LOG_SAMPLE_RATE", "1"), 64)
+	if err != nil || sampleRate < 0 || sampleRate > 1 {
+		sugar.Fatalf("ACCESS_LOG_SAMPLE_RATE must be a number between 0 and 1")
+	}
+	accessLog := accesslog.Config{Logger: accessLogger.Named("access").Sugar(), SampleRate: sampleRate}
+
+	tracingConfig, err := tracing.ConfigFromEnv("product-service")
+	if err != nil {
+		sugar.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	tp, err := tracing.Init(context.Background(), tracingConfig)
+	if err != nil {
+		sugar.Fatalf("Failed to initialize tracer: %v", err)
+	}
+
+	mongoClient, err := mongo.Connect(context.Background(), options.Client().ApplyURI(getEnv("MONGO_URI", "mongodb://localhost:27017/product_db")))
+	if err != nil {
+		sugar.Fatalf("Failed to create MongoDB client: %v", err)
+	}
+	defer mongoClient.Disconnect(context.Background())
+
+	healthHandler := health.NewHandler(sugar, 2*time.Second)
+	healthHandler.Add("mongo", func(ctx context.Context) error { return mongoClient.Ping(ctx, nil) })
+
 	handler := handlers.NewHandler(sugar)
 
 	mux := http.NewServeMux()
-	
+
+	mux.HandleFunc("GET /livez", healthHandler.Livez)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.Handle("GET /metrics", metrics.Handler())
+
 	mux.HandleFunc("GET /api/products", handler.ListProducts)
 	mux.HandleFunc("GET /api/products/{id}", handler.GetProduct)
 	mux.HandleFunc("POST /api/products", handler.CreateProduct)
@@ -33,7 +84,7 @@ func main() {
 
 	server := &http.Server{
 		Addr:    ":8081",
-		Handler: mux,
+		Handler: tracing.Middleware("product-service", accesslog.Middleware(accessLog, metrics.Middleware(mux))),
 	}
 
 	go func() {
@@ -43,6 +94,24 @@ func main() {
 		}
 	}()
 
+	// The same API over gRPC, for the gateway and other services
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	productv1.RegisterProductServiceServer(grpcServer, handlers.NewGRPCServer(sugar))
+	healthpb.RegisterHealthServer(grpcServer, grpchealth.NewServer())
+	reflection.Register(grpcServer)
+
+	grpcAddr := ":" + getEnv("GRPC_PORT", "9082")
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		sugar.Fatalf("Failed to listen on %s: %v", grpcAddr, err)
+	}
+	go func() {
+		sugar.Infof("Starting product gRPC server on %s", grpcAddr)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			sugar.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -55,6 +124,32 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		sugar.Fatalf("Server forced to shutdown: %v", err)
 	}
+	stopGRPC(ctx, grpcServer)
+	// Flushes the spans of the last requests
+	if err := tp.Shutdown(ctx); err != nil {
+		sugar.Errorf("Error shutting down tracer provider: %v", err)
+	}
 
 	sugar.Info("Server exiting")
 }
+
+// stopGRPC lets in-flight calls finish, cutting them off when ctx ends.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
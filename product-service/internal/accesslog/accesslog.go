@@ -0,0 +1,175 @@
+// Package accesslog writes a structured access log line for every request
+// through zap. Lines carry the request ID, trace context and user the
+// gateway forwards, so they can be joined with the gateway's lines and
+// with traces.
+package accesslog
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveParams are the query parameters whose values are never logged.
+var sensitiveParams = []string{"password", "passwd", "secret", "token", "authorization", "api_key", "apikey", "email"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+(@|%40)[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Config tunes the access log.
+type Config struct {
+	Logger *zap.SugaredLogger
+	// SampleRate in [0, 1] is the share of 2xx lines written; other
+	// statuses are always logged
+	SampleRate float64
+}
+
+// Middleware logs every request handled by mux. The route is the
+// ServeMux pattern, which the mux sets on the request once it has matched
+// it. The path and query are logged with credentials and email addresses
+// redacted, and headers are not logged at all.
+func Middleware(cfg Config, mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		mux.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		sc := trace.SpanContextFromContext(r.Context())
+		remote := !sc.IsValid()
+		if remote {
+			sc = trace.SpanContextFromContext(propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header)))
+		}
+		if status >= 200 && status < 300 && !sampled(sc.TraceID(), cfg.SampleRate) {
+			return
+		}
+
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		userID := r.Header.Get("X-User-Id")
+		if userID == "" {
+			userID = r.PathValue("user_id")
+		}
+		fields := []interface{}{
+			"request_id", r.Header.Get("X-Request-Id"),
+			"method", r.Method,
+			"path", emailPattern.ReplaceAllString(r.URL.EscapedPath(), redacted),
+			"route", route,
+			"status", status,
+			"bytes", rw.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		}
+		if query := redactQuery(r.URL.Query()); query != "" {
+			fields = append(fields, "query", query)
+		}
+		switch {
+		case !sc.IsValid():
+		case remote:
+			// No span of our own; the caller's span is the parent
+			fields = append(fields, "trace_id", sc.TraceID().String(), "parent_span_id", sc.SpanID().String())
+		default:
+			fields = append(fields, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+		}
+		if userID != "" {
+			fields = append(fields, "user_id", userID)
+		}
+
+		if status >= http.StatusInternalServerError {
+			cfg.Logger.Errorw("HTTP request", fields...)
+		} else {
+			cfg.Logger.Infow("HTTP request", fields...)
+		}
+	})
+}
+
+// sampled decides whether a 2xx line is written. Lines with a trace are
+// sampled by its ID, so that the gateway and every service keep or drop
+// the same requests.
+func sampled(traceID trace.TraceID, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	case traceID.IsValid():
+		return binary.BigEndian.Uint64(traceID[8:16])>>1 < uint64(rate*(1<<63))
+	default:
+		return rand.Float64() < rate
+	}
+}
+
+// redactQuery encodes query with the values of credential parameters and
+// email addresses hidden.
+func redactQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	for key, values := range query {
+		sensitive := isSensitive(key)
+		for i, v := range values {
+			if sensitive {
+				values[i] = redacted
+			} else {
+				values[i] = emailPattern.ReplaceAllString(v, redacted)
+			}
+		}
+	}
+	encoded, err := url.QueryUnescape(query.Encode())
+	if err != nil {
+		return query.Encode()
+	}
+	return encoded
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveParams {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nutcase/shop-ecommerce/product-service/internal/problem"
+	productv1 "github.com/nutcase/shop-ecommerce/proto/product/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// httpCodeHeader tells the gateway's transcoder which HTTP status the REST
+// route answers a successful call with, when it is not 200.
+const httpCodeHeader = "x-http-code"
+
+// GRPCServer serves the product API over gRPC. It mirrors the HTTP
+// handlers and reports errors with the same codes.
+type GRPCServer struct {
+	productv1.UnimplementedProductServiceServer
+	logger *zap.SugaredLogger
+}
+
+func NewGRPCServer(logger *zap.SugaredLogger) *GRPCServer {
+	return &GRPCServer{logger: logger}
+}
+
+func (s *GRPCServer) ListProducts(ctx context.Context, req *productv1.ListProductsRequest) (*productv1.ListProductsResponse, error) {
+	_, span := otel.Tracer("product-service").Start(ctx, "ListProducts")
+	defer span.End()
+
+	limit := req.GetLimit()
+	if limit == 0 {
+		limit = 10
+	}
+	span.SetAttributes(
+		attribute.Int("limit", int(limit)),
+		attribute.Int("offset", int(req.GetOffset())),
+	)
+
+	var products []Product
+	if ids := req.GetIds(); ids != "" {
+		productIDs := strings.Split(ids, ",")
+		if len(productIDs) > maxIDs {
+			return nil, problem.InvalidArgument(problem.Invalid("ids", "at most "+strconv.Itoa(maxIDs)+" IDs are allowed"))
+		}
+		span.SetAttributes(attribute.Int("ids", len(productIDs)))
+
+		// TODO: Implement repository call to get products by ID
+		for _, productID := range productIDs {
+			if productID != "" {
+				products = append(products, mockProduct(productID))
+			}
+		}
+	} else {
+		// TODO: Implement repository call to get products
+		products = mockCatalog()
+	}
+
+	resp := &productv1.ListProductsResponse{Products: make([]*productv1.Product, len(products))}
+	for i, product := range products {
+		resp.Products[i] = product.proto()
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) GetProduct(ctx context.Context, req *productv1.GetProductRequest) (*productv1.Product, error) {
+	_, span := otel.Tracer("product-service").Start(ctx, "GetProduct")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("product.id", req.GetId()))
+
+	// TODO: Implement repository call to get product
+	product := mockProduct(req.GetId())
+	return product.proto(), nil
+}
+
+func (s *GRPCServer) CreateProduct(ctx context.Context, req *productv1.CreateProductRequest) (*productv1.Product, error) {
+	_, span := otel.Tracer("product-service").Start(ctx, "CreateProduct")
+	defer span.End()
+
+	if req.GetProduct() == nil {
+		return nil, problem.InvalidArgument(problem.Required("product"))
+	}
+
+	// TODO: Implement repository call to create product
+	product := productFromProto(req.GetProduct())
+	product.ID = "new-product-id" // In a real implementation, this would be generated
+
+	setHTTPStatus(ctx, http.StatusCreated)
+	return product.proto(), nil
+}
+
+func (s *GRPCServer) UpdateProduct(ctx context.Context, req *productv1.UpdateProductRequest) (*productv1.Product, error) {
+	_, span := otel.Tracer("product-service").Start(ctx, "UpdateProduct")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	if req.GetProduct() == nil {
+		return nil, problem.InvalidArgument(problem.Required("product"))
+	}
+	span.SetAttributes(attribute.String("product.id", req.GetId()))
+
+	// TODO: Implement repository call to update product
+	product := productFromProto(req.GetProduct())
+	product.ID = req.GetId()
+	return product.proto(), nil
+}
+
+func (s *GRPCServer) DeleteProduct(ctx context.Context, req *productv1.DeleteProductRequest) (*emptypb.Empty, error) {
+	_, span := otel.Tracer("product-service").Start(ctx, "DeleteProduct")
+	defer span.End()
+
+	if req.GetId() == "" {
+		return nil, problem.InvalidArgument(problem.Required("id"))
+	}
+	span.SetAttributes(attribute.String("product.id", req.GetId()))
+
+	// TODO: Implement repository call to delete product
+	setHTTPStatus(ctx, http.StatusNoContent)
+	return &emptypb.Empty{}, nil
+}
+
+func (p Product) proto() *productv1.Product {
+	return &productv1.Product{
+		Id:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		ImageUrl:    p.ImageURL,
+		Stock:       int32(p.Stock),
+	}
+}
+
+func productFromProto(p *productv1.Product) Product {
+	return Product{
+		ID:          p.GetId(),
+		Name:        p.GetName(),
+		Description: p.GetDescription(),
+		Price:       p.GetPrice(),
+		ImageURL:    p.GetImageUrl(),
+		Stock:       int(p.GetStock()),
+	}
+}
+
+// setHTTPStatus sets the status the REST route answers the call with.
+func setHTTPStatus(ctx context.Context, code int) {
+	grpc.SetHeader(ctx, metadata.Pairs(httpCodeHeader, strconv.Itoa(code)))
+}
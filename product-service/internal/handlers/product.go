@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/nutcase/shop-ecommerce/product-service/internal/problem"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
@@ -20,6 +22,9 @@ func NewHandler(logger *zap.SugaredLogger) *Handler {
 	}
 }
 
+// maxIDs caps the products one ids filter may ask for.
+const maxIDs = 100
+
 type Product struct {
 	ID          string  `json:"id"`
 	Name        string  `json:"name"`
@@ -29,6 +34,40 @@ type Product struct {
 	Stock       int     `json:"stock"`
 }
 
+// mockProduct stands in for a product read from the repository.
+func mockProduct(productID string) Product {
+	return Product{
+		ID:          productID,
+		Name:        "Product " + productID,
+		Description: "Description for product " + productID,
+		Price:       19.99,
+		ImageURL:    "https://example.com/product" + productID + ".jpg",
+		Stock:       100,
+	}
+}
+
+// mockCatalog stands in for a page of products read from the repository.
+func mockCatalog() []Product {
+	return []Product{
+		{
+			ID:          "1",
+			Name:        "Product 1",
+			Description: "Description for product 1",
+			Price:       19.99,
+			ImageURL:    "https://example.com/product1.jpg",
+			Stock:       100,
+		},
+		{
+			ID:          "2",
+			Name:        "Product 2",
+			Description: "Description for product 2",
+			Price:       29.99,
+			ImageURL:    "https://example.com/product2.jpg",
+			Stock:       50,
+		},
+	}
+}
+
 func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 	_, span := otel.Tracer("product-service").Start(r.Context(), "ListProducts")
 	defer span.End()
@@ -46,35 +85,47 @@ func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 		attribute.Int("offset", offset),
 	)
 
+	// ids=a,b,c fetches those products in one request, ignoring limit and
+	// offset; unknown IDs are left out of the result
+	if ids := query.Get("ids"); ids != "" {
+		productIDs := strings.Split(ids, ",")
+		if len(productIDs) > maxIDs {
+			problem.Write(w, r, problem.Validation(problem.Invalid("ids", "at most "+strconv.Itoa(maxIDs)+" IDs are allowed")))
+			return
+		}
+		span.SetAttributes(attribute.Int("ids", len(productIDs)))
+
+		// TODO: Implement repository call to get products by ID
+		// products, err := h.repo.GetProducts(ctx, productIDs)
+
+		// Mock data for now
+		products := make([]Product, 0, len(productIDs))
+		for _, productID := range productIDs {
+			if productID == "" {
+				continue
+			}
+			products = append(products, mockProduct(productID))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(products); err != nil {
+			h.logger.Errorw("Failed to encode response", "error", err)
+		}
+		return
+	}
+
 	// TODO: Implement repository call to get products
 	// products, err := h.repo.ListProducts(ctx, limit, offset)
 	
 	// Mock data for now
-	products := []Product{
-		{
-			ID:          "1",
-			Name:        "Product 1",
-			Description: "Description for product 1",
-			Price:       19.99,
-			ImageURL:    "https://example.com/product1.jpg",
-			Stock:       100,
-		},
-		{
-			ID:          "2",
-			Name:        "Product 2",
-			Description: "Description for product 2",
-			Price:       29.99,
-			ImageURL:    "https://example.com/product2.jpg",
-			Stock:       50,
-		},
-	}
+	products := mockCatalog()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	
 	if err := json.NewEncoder(w).Encode(products); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -84,7 +135,7 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	productID := r.PathValue("id")
 	if productID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -94,21 +145,14 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 	// product, err := h.repo.GetProduct(ctx, productID)
 	
 	// Mock data for now
-	product := Product{
-		ID:          productID,
-		Name:        "Product " + productID,
-		Description: "Description for product " + productID,
-		Price:       19.99,
-		ImageURL:    "https://example.com/product" + productID + ".jpg",
-		Stock:       100,
-	}
+	product := mockProduct(productID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	
 	if err := json.NewEncoder(w).Encode(product); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -118,7 +162,7 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	var product Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -133,7 +177,7 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	
 	if err := json.NewEncoder(w).Encode(product); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -143,7 +187,7 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	productID := r.PathValue("id")
 	if productID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
@@ -151,7 +195,7 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 	var product Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -168,7 +212,7 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	// Write response
 	if err := json.NewEncoder(w).Encode(product); err != nil {
 		h.logger.Errorw("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
 		return
 	}
 }
@@ -179,7 +223,7 @@ func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 
 	productID := r.PathValue("id")
 	if productID == "" {
-		http.Error(w, "Product ID is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation(problem.Required("id")))
 		return
 	}
 
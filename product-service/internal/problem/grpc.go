@@ -0,0 +1,27 @@
+package problem
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InvalidArgument is the gRPC counterpart of Validation: the invalid
+// fields travel as BadRequest details, with their code as the reason, so
+// the gateway can turn them back into the same problem document.
+func InvalidArgument(errs ...FieldError) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(errs))
+	for i, err := range errs {
+		violations[i] = &errdetails.BadRequest_FieldViolation{
+			Field:       err.Field,
+			Reason:      err.Code,
+			Description: err.Message,
+		}
+	}
+	st, detailErr := status.New(codes.InvalidArgument, "The request has invalid fields").
+		WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, "The request has invalid fields")
+	}
+	return st.Err()
+}